@@ -5,8 +5,10 @@ go 1.12
 require (
 	github.com/spf13/cobra v0.0.0-20180319062004-c439c4fa0937
 	golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f
+	golang.org/x/text v0.3.1-0.20181227161524-e6919f6577db
 	k8s.io/api v0.0.0-20190515023547-db5a9d1c40eb
 	k8s.io/apimachinery v0.0.0-20190515023456-b74e4c97951f
 	k8s.io/cli-runtime v0.0.0-20190515024640-178667528169
 	k8s.io/client-go v0.0.0-20190515023709-78e94f51a042
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,62 @@
+//go:build ignore
+// +build ignore
+
+// release builds the release archives for all platforms and the krew plugin
+// manifest into the dist directory:
+//
+//	go run hack/release.go -version v1.2.3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/buztard/kubectl-htpasswd/pkg/release"
+)
+
+func main() {
+	version := flag.String("version", "", "Version of the release, e.g. v1.2.3")
+	dist := flag.String("dist", "dist", "Directory of the release artifacts")
+	flag.Parse()
+	if err := run(*version, *dist); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(version, dist string) error {
+	if !strings.HasPrefix(version, "v") {
+		return fmt.Errorf("-version must be a tag like v1.2.3")
+	}
+	commit, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("unable to determine the commit: %v", err)
+	}
+	if err := os.MkdirAll(dist, 0755); err != nil {
+		return err
+	}
+	var artifacts []*release.Artifact
+	for _, p := range release.Platforms {
+		fmt.Fprintf(os.Stderr, "Building %s\n", p)
+		a, err := release.Build(".", dist, version, strings.TrimSpace(string(commit)), p)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, a)
+	}
+	manifest, err := release.KrewManifest(version, artifacts)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dist, release.PluginName+".yaml")
+	if err := ioutil.WriteFile(path, manifest, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote krew manifest %s\n", path)
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 func main() {
 	cmd := htpasswd.NewCommand(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr})
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		htpasswd.PrintError(cmd, os.Stderr, err)
+		os.Exit(htpasswd.ExitCode(err))
 	}
 }
@@ -0,0 +1,147 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// defaultFieldManager identifies the changes of the plugin in the managed
+// fields of a secret.
+const defaultFieldManager = "kubectl-htpasswd"
+
+func (o *CommandOptions) addApplyFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.serverSideApply, "server-side-apply", "", false, "Save the secret with a server-side apply of the managed keys instead of replacing it")
+	cmd.Flags().StringVarP(&o.fieldManager, "field-manager", "", defaultFieldManager, "Name of the manager used to track field ownership")
+	cmd.Flags().BoolVarP(&o.forceConflicts, "force-conflicts", "", false, "With --server-side-apply, take over keys managed by others")
+}
+
+// validateApply checks the flags of server-side apply.
+func (o *CommandOptions) validateApply() error {
+	if o.fieldManager == "" {
+		return fmt.Errorf("--field-manager must not be empty")
+	}
+	if o.forceConflicts && !o.serverSideApply {
+		return fmt.Errorf("--force-conflicts requires --server-side-apply")
+	}
+	if o.serverSideApply && o.fromManifest != "" {
+		return fmt.Errorf("--server-side-apply can't be combined with --from-manifest")
+	}
+	return nil
+}
+
+// applyConfiguration returns the part of secret owned by the plugin: the
+// selected keys, the user list and its annotations, and the labels,
+// annotations and owner given with --label, --annotation and --owner. Keys
+// missing in the configuration are removed by the server if the plugin is
+// their only manager.
+func (o *CommandOptions) applyConfiguration(secret *v1.Secret) *v1.Secret {
+	config := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secret.Name,
+			Namespace: secret.Namespace,
+		},
+		Type: secret.Type,
+		Data: make(map[string][]byte),
+	}
+	for _, key := range o.managedKeys(secret) {
+		if data, ok := secret.Data[key]; ok {
+			config.Data[key] = data
+		}
+	}
+	for _, key := range []string{managedByAnnotation, lastModifiedAnnotation, historyAnnotation, expiresAnnotation, commentsAnnotation, usersKeyAnnotation, ownerAnnotation} {
+		if value, ok := secret.Annotations[key]; ok {
+			if config.Annotations == nil {
+				config.Annotations = make(map[string]string)
+			}
+			config.Annotations[key] = value
+		}
+	}
+	for _, l := range o.labels {
+		key, _, _ := splitKeyValue(l)
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[key] = secret.Labels[key]
+	}
+	for _, a := range o.annotations {
+		key, _, _ := splitKeyValue(a)
+		if config.Annotations == nil {
+			config.Annotations = make(map[string]string)
+		}
+		config.Annotations[key] = secret.Annotations[key]
+	}
+	if o.owner != "" {
+		config.OwnerReferences = secret.OwnerReferences
+	}
+	return config
+}
+
+// managedKeys returns the keys of secret written by the operation, including
+// the user list.
+func (o *CommandOptions) managedKeys(secret *v1.Secret) []string {
+	keys := o.keyNames
+	if secret.Type == v1.SecretTypeBasicAuth {
+		keys = []string{v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey}
+	}
+	if o.moveKey != "" {
+		keys = []string{o.moveKey}
+	}
+	if key, ok := secret.Annotations[usersKeyAnnotation]; ok {
+		keys = append(keys[:len(keys):len(keys)], key)
+	}
+	return keys
+}
+
+// Apply sends secret as server-side apply patch.
+func (c *secretsClient) Apply(ctx context.Context, secret *v1.Secret, force bool) (*v1.Secret, error) {
+	if c.client == nil {
+		// the typed Patch of this client-go release has no field manager
+		return nil, errNoRESTClient
+	}
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	opts := &metav1.PatchOptions{DryRun: c.dryRun, FieldManager: c.fieldManager}
+	if force {
+		opts.Force = &force
+	}
+	result := &v1.Secret{}
+	err = c.retry(ctx, func(ctx context.Context) error {
+		return c.client.Patch(types.ApplyPatchType).
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			Name(secret.Name).
+			VersionedParams(opts, scheme.ParameterCodec).
+			Body(body).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// applyConflict turns a field ownership conflict of a server-side apply into
+// an error which isn't retried, as trying again won't resolve it.
+func applyConflict(err error) error {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return err
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			return conflictError("%v, use --force-conflicts to take over the fields", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package htpasswd
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyConfigurationKeepsCreateMetadata(t *testing.T) {
+	secret := newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	secret.Data["tls.crt"] = []byte("not managed")
+	secret.Labels = map[string]string{"app": "gateway", "tier": "edge"}
+	secret.Annotations = map[string]string{"team": "a", "other": "x", managedByAnnotation: managedByValue}
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "gateway", UID: "1234"}
+	secret.OwnerReferences = []metav1.OwnerReference{owner}
+
+	o := &CommandOptions{keyNames: []string{"auth"}, labels: []string{"app=gateway"}, annotations: []string{"team=a"}, owner: "deployment/gateway"}
+	config := o.applyConfiguration(secret)
+	if want := map[string][]byte{"auth": secret.Data["auth"]}; !reflect.DeepEqual(config.Data, want) {
+		t.Errorf("data = %q, want only the managed key", config.Data)
+	}
+	if want := map[string]string{"app": "gateway"}; !reflect.DeepEqual(config.Labels, want) {
+		t.Errorf("labels = %v, want %v", config.Labels, want)
+	}
+	if want := map[string]string{"team": "a", managedByAnnotation: managedByValue}; !reflect.DeepEqual(config.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", config.Annotations, want)
+	}
+	if want := []metav1.OwnerReference{owner}; !reflect.DeepEqual(config.OwnerReferences, want) {
+		t.Errorf("owner references = %v, want %v", config.OwnerReferences, want)
+	}
+
+	o = &CommandOptions{keyNames: []string{"auth"}}
+	config = o.applyConfiguration(secret)
+	if len(config.Labels) > 0 || len(config.OwnerReferences) > 0 || config.Annotations["team"] != "" {
+		t.Errorf("configuration without metadata flags claims metadata: %v, %v, %v", config.Labels, config.Annotations, config.OwnerReferences)
+	}
+}
+
+func TestManagedBy(t *testing.T) {
+	isController := true
+	pluginOwner := map[string]string{ownerAnnotation: "1234"}
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		owners      []metav1.OwnerReference
+		want        string
+	}{
+		{"unmanaged", nil, nil, nil, ""},
+		{"controller", nil, nil, []metav1.OwnerReference{{Kind: "Deployment", Name: "gateway", UID: "1234", Controller: &isController}}, `Deployment "gateway"`},
+		{"other owner", nil, nil, []metav1.OwnerReference{{Kind: "Deployment", Name: "gateway", UID: "1234"}}, `Deployment "gateway"`},
+		{"owner set with --owner", nil, pluginOwner, []metav1.OwnerReference{{Kind: "Deployment", Name: "gateway", UID: "1234"}}, ""},
+		{"owner besides --owner", nil, pluginOwner, []metav1.OwnerReference{{Kind: "Deployment", Name: "gateway", UID: "1234"}, {Kind: "Application", Name: "argo", UID: "5678"}}, `Application "argo"`},
+		{"label", map[string]string{"app.kubernetes.io/managed-by": "Helm"}, nil, nil, `"Helm"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Labels: test.labels, Annotations: test.annotations, OwnerReferences: test.owners}}
+			if got := managedBy(secret); got != test.want {
+				t.Errorf("managedBy = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package htpasswd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2idMagic = "$argon2id$"
+
+	// Defaults of new argon2id hashes, the second recommended option of
+	// RFC 9106 with memory reduced to 64 MiB.
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 4
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// argon2Hasher creates argon2id entries in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash.
+type argon2Hasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func newArgon2Hasher(opts hashOptions) (Hasher, error) {
+	switch {
+	case opts.argon2Parallelism < 1 || opts.argon2Parallelism > 255:
+		return nil, fmt.Errorf("argon2 parallelism must be between 1 and 255")
+	case opts.argon2Iterations < 1:
+		return nil, fmt.Errorf("argon2 iterations must be at least 1")
+	case opts.argon2Memory < 8*opts.argon2Parallelism:
+		return nil, fmt.Errorf("argon2 memory must be at least 8 KiB per thread")
+	}
+	return argon2Hasher{
+		memory:      uint32(opts.argon2Memory),
+		iterations:  uint32(opts.argon2Iterations),
+		parallelism: uint8(opts.argon2Parallelism),
+	}, nil
+}
+
+func (h argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, argon2KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idMagic, argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2Params holds the parameters parsed from an argon2id hash.
+type argon2Params struct {
+	argon2Hasher
+	salt, key []byte
+}
+
+// parseArgon2 parses a PHC formatted argon2id hash.
+func parseArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idMagic), "$")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil {
+		return nil, fmt.Errorf("malformed argon2id hash")
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, fmt.Errorf("malformed argon2id parameters %q", parts[1])
+	}
+	if p.iterations < 1 || p.parallelism < 1 {
+		return nil, fmt.Errorf("malformed argon2id parameters %q", parts[1])
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("malformed argon2id salt")
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil || len(p.key) == 0 {
+		return nil, fmt.Errorf("malformed argon2id hash")
+	}
+	return &p, nil
+}
+
+// verifyArgon2 checks password against an argon2id hash.
+func verifyArgon2(hash, password string) (bool, error) {
+	p, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
@@ -0,0 +1,83 @@
+package htpasswd
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// secretTypes maps the values of --secret-type to secret types.
+var secretTypes = map[string]v1.SecretType{
+	"opaque":     v1.SecretTypeOpaque,
+	"basic-auth": v1.SecretTypeBasicAuth,
+}
+
+// runBasicAuth runs the operation against a kubernetes.io/basic-auth secret.
+// Such secrets hold a single user with a plaintext password under the
+// username and password keys instead of htpasswd data.
+func (o *CommandOptions) runBasicAuth(ctx context.Context, secret *v1.Secret) error {
+	current := string(secret.Data[v1.BasicAuthUsernameKey])
+
+	switch {
+	case o.listUsers:
+		if o.output != "" {
+			k := keyUsers{Key: v1.BasicAuthUsernameKey, Users: []userInfo{}}
+			if current != "" {
+				k.Users = append(k.Users, userInfo{Name: current})
+			}
+			return o.printUserList(secret, []keyUsers{k})
+		}
+		fmt.Fprintf(o.Out, "Existing users:\n")
+		if current != "" {
+			fmt.Fprintln(o.Out, current)
+		}
+		return nil
+	case o.verify:
+		if current != o.username {
+			return notFoundError("user %q does not exist", o.username)
+		}
+		password, err := readPassword(o, "verify")
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(secret.Data[v1.BasicAuthPasswordKey], []byte(password)) != 1 {
+			return fmt.Errorf("password for user %q doesn't match", o.username)
+		}
+		o.logf(logNormal, "Password for user %q is correct", o.username)
+		return nil
+	case o.deleteUser:
+		return fmt.Errorf("basic-auth secrets hold exactly one user, delete the secret instead")
+	}
+
+	if manager := managedBy(secret); manager != "" {
+		if !o.force {
+			return fmt.Errorf("secret %q is managed by %s and changes may be reverted, use --force to edit it anyway", secret.Name, manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %q is managed by %s\n", secret.Name, manager)
+	}
+
+	operation := "add"
+	if current != "" {
+		if o.appendOnly {
+			return fmt.Errorf("secret %q already holds user %q and --append-only is set", secret.Name, current)
+		}
+		operation = "update"
+		if current != o.username {
+			fmt.Fprintf(o.ErrOut, "Warning: replacing user %q with %q\n", current, o.username)
+		}
+	}
+	password, err := readPassword(o, operation)
+	if err != nil {
+		return err
+	}
+	secret.Data[v1.BasicAuthUsernameKey] = []byte(o.username)
+	secret.Data[v1.BasicAuthPasswordKey] = []byte(password)
+	o.recordChange(secret, operation, []string{o.username}, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Password updated successfully%s", o.dryRunSuffix())
+	return o.showGeneratedPassword(password)
+}
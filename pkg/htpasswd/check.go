@@ -0,0 +1,215 @@
+package htpasswd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// newCheckCommand returns the check subcommand which reports all problems of
+// the htpasswd data stored in a secret.
+func newCheckCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check SECRET",
+		Short: "Check the htpasswd data of a secret for problems",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if o.fromManifest == "" {
+				if len(o.args) != 1 {
+					return validationError(fmt.Errorf("secret is required"))
+				}
+				o.secretName = o.args[0]
+			}
+			if err := o.validateKeyNames(); err != nil {
+				return validationError(err)
+			}
+			if err := o.validateSizeWarn(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunCheck(ctx)
+		},
+	}
+	o.addSizeWarnFlag(cmd)
+	return cmd
+}
+
+// RunCheck loads the secret and prints every problem found in its data.
+func (o *CommandOptions) RunCheck(ctx context.Context) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("check only supports htpasswd data, not basic-auth secrets")
+	}
+
+	total := 0
+	for _, key := range o.keyNames {
+		// malformed lines are findings, only data which isn't text at all
+		// stops the report
+		if !isTextData(secret.Data[key]) {
+			return fmt.Errorf("key %q does not contain htpasswd data", key)
+		}
+		problems := checkPasswordData(secret.Data[key], o.ignoreCase, o.target)
+		for _, p := range problems {
+			if len(o.keyNames) > 1 {
+				fmt.Fprintf(o.Out, "key %q: %s\n", key, p)
+			} else {
+				fmt.Fprintln(o.Out, p)
+			}
+		}
+		total += len(problems)
+	}
+	if size := secretSize(secret); o.nearSizeLimit(size) {
+		fmt.Fprintf(o.Out, "secret uses %d of %d bytes, close to the size limit\n", size, maxSecretSize)
+		total++
+	}
+	if total > 0 {
+		return fmt.Errorf("found %d problem(s) in secret %q", total, o.secretName)
+	}
+	o.logf(logNormal, "No problems found in secret %q", o.secretName)
+	return nil
+}
+
+// problem describes an issue found on a single line of htpasswd data.
+type problem struct {
+	line    int
+	message string
+}
+
+func (p problem) String() string {
+	return fmt.Sprintf("line %d: %s", p.line, p.message)
+}
+
+// checkPasswordData validates every entry of data and returns all problems
+// instead of stopping at the first one like newPasswordFile does. Hashes
+// target can't check are reported as well.
+func checkPasswordData(data []byte, ignoreCase bool, target string) []problem {
+	var problems []problem
+	seen := make(map[string]int)
+	for i, l := range strings.Split(string(data), "\n") {
+		line := i + 1
+		l = strings.TrimSpace(l)
+		if isComment(l) {
+			continue
+		}
+		username, password, _, err := parseEntry(l)
+		if err != nil {
+			problems = append(problems, problem{line, fmt.Sprintf("malformed entry, %v", err)})
+			continue
+		}
+		key := username
+		if ignoreCase {
+			key = strings.ToLower(username)
+		}
+		if first, ok := seen[key]; ok {
+			problems = append(problems, problem{line, fmt.Sprintf("duplicate user %q, first defined on line %d", username, first)})
+		} else {
+			seen[key] = line
+		}
+		if password == "" {
+			problems = append(problems, problem{line, fmt.Sprintf("empty password for user %q", username)})
+		} else if hashAlgorithm(password) == "" {
+			problems = append(problems, problem{line, fmt.Sprintf("unknown hash format for user %q", username)})
+		} else if err := checkHashFormat(password); err != nil {
+			problems = append(problems, problem{line, fmt.Sprintf("%v for user %q", err, username)})
+		} else if algorithm := hashAlgorithm(password); !targetSupports(target, algorithm) {
+			problems = append(problems, problem{line, fmt.Sprintf("%s can't check the %s hash of user %q", target, algorithm, username)})
+		} else if isWeakHash(password) {
+			description, _ := hashStrength(password)
+			problems = append(problems, problem{line, fmt.Sprintf("weak hash for user %q (%s), use rehash to upgrade it", username, description)})
+		}
+	}
+	return problems
+}
+
+// hashAlgorithm returns the name of the algorithm used to create hash or an
+// empty string if the format is unknown.
+func hashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		return "sha1"
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return "bcrypt"
+	case strings.HasPrefix(hash, "$apr1$"):
+		return "apr1"
+	case strings.HasPrefix(hash, "$1$"):
+		return "md5-crypt"
+	case strings.HasPrefix(hash, "$5$"):
+		return "sha256-crypt"
+	case strings.HasPrefix(hash, "$6$"):
+		return "sha512-crypt"
+	case strings.HasPrefix(hash, argon2idMagic):
+		return "argon2id"
+	case len(hash) == 13 && isCryptString(hash):
+		// two salt characters and eleven of the digest
+		return "crypt"
+	case isDigest(hash):
+		return "digest"
+	}
+	return ""
+}
+
+// cryptDigestLengths holds the length of the encoded digest of the crypt(3)
+// style hashes.
+var cryptDigestLengths = map[string]int{
+	"apr1":         22,
+	"md5-crypt":    22,
+	"sha256-crypt": 43,
+	"sha512-crypt": 86,
+}
+
+// checkHashFormat reports hashes which have the prefix of a known algorithm
+// but can't be what it produces, e.g. truncated ones.
+func checkHashFormat(hash string) error {
+	switch algorithm := hashAlgorithm(hash); algorithm {
+	case "sha1":
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+		if err != nil || len(sum) != sha1.Size {
+			return fmt.Errorf("malformed SHA-1 hash")
+		}
+	case "bcrypt":
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil || len(hash) != 60 {
+			return fmt.Errorf("malformed bcrypt hash")
+		}
+	case "apr1", "md5-crypt", "sha256-crypt", "sha512-crypt":
+		digest := hash[strings.LastIndex(hash, "$")+1:]
+		if strings.Count(hash, "$") < 3 || len(digest) != cryptDigestLengths[algorithm] || !isCryptString(digest) {
+			return fmt.Errorf("malformed %s hash", algorithm)
+		}
+	case "argon2id":
+		_, err := parseArgon2(hash)
+		return err
+	}
+	return nil
+}
+
+// isWeakHash reports whether hash was created with a weak algorithm. Digest
+// entries are left out as there is no stronger alternative for digest
+// authentication.
+func isWeakHash(hash string) bool {
+	_, strength := hashStrength(hash)
+	return strength == strengthWeak && hashAlgorithm(hash) != "digest"
+}
+
+// isCryptString reports whether s only consists of cryptAlphabet.
+func isCryptString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(cryptAlphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package htpasswd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckPasswordData(t *testing.T) {
+	data := strings.Join([]string{
+		"# users",
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"garbage",
+		"bob:",
+		"alice:$2y$05$LU9C8rU6Yb0u4tVeSDzp6eD4sQ0DVA7.vXcYX4xC5b0u1oYhIOmWa",
+		":nousername",
+		"carol:%unknown%",
+	}, "\n")
+	var got []string
+	for _, p := range checkPasswordData([]byte(data), false, "") {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`line 2: weak hash for user "alice" (SHA-1, unsalted), use rehash to upgrade it`,
+		"line 3: malformed entry, missing colon between username and hash",
+		`line 4: empty password for user "bob"`,
+		`line 5: duplicate user "alice", first defined on line 2`,
+		`line 5: weak hash for user "alice" (bcrypt cost 5), use rehash to upgrade it`,
+		"line 6: malformed entry, empty username",
+		`line 7: unknown hash format for user "carol"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkPasswordData =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckReportsAllMalformedLines(t *testing.T) {
+	c := newTestCluster(t, newTestSecret("gateway", "garbage\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nmore garbage\n"))
+	defer c.close()
+
+	out, _, err := c.run("", "check", "gateway")
+	if err == nil || !strings.Contains(err.Error(), "found 3 problem(s)") {
+		t.Errorf("error = %v, want 3 problems found", err)
+	}
+	for _, want := range []string{"line 1: malformed entry", "line 3: malformed entry"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCheckRejectsBinaryData(t *testing.T) {
+	c := newTestCluster(t, newTestSecret("gateway", "\x00\x01\x02"))
+	defer c.close()
+
+	_, _, err := c.run("", "check", "gateway")
+	if err == nil || !strings.Contains(err.Error(), "does not contain htpasswd data") {
+		t.Errorf("error = %v, want the data rejected", err)
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	tests := []struct {
+		hash string
+		want string
+	}{
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "sha1"},
+		{"$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "bcrypt"},
+		{"$apr1$saltsalt$EGVZDNN6gOqijy.tv9axG/", "apr1"},
+		{"abhv/ZnAzL36k", "crypt"},
+		// 13 characters outside of the crypt alphabet are plaintext
+		{"correct-horse", ""},
+		{"hello world!!", ""},
+		{"abhv/ZnAzL36", ""},
+	}
+	for _, test := range tests {
+		if got := hashAlgorithm(test.hash); got != test.want {
+			t.Errorf("hashAlgorithm(%q) = %q, want %q", test.hash, got, test.want)
+		}
+	}
+	if _, err := (plainHasher{}).Hash("correct-horse"); err != nil {
+		t.Errorf("plaintext password of 13 characters rejected: %v", err)
+	}
+}
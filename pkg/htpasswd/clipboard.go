@@ -0,0 +1,133 @@
+package htpasswd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultCopyClear = 45 * time.Second
+
+// clipboardTool is a pair of commands writing stdin to the clipboard and
+// printing its content.
+type clipboardTool struct {
+	copy  []string
+	paste []string
+}
+
+// clipboardTools returns the clipboard commands of the platform, the first
+// one installed is used.
+func clipboardTools() []clipboardTool {
+	switch runtime.GOOS {
+	case "darwin":
+		return []clipboardTool{{[]string{"pbcopy"}, []string{"pbpaste"}}}
+	case "windows":
+		return []clipboardTool{{[]string{"clip.exe"}, []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}}}
+	}
+	var tools []clipboardTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, clipboardTool{[]string{"wl-copy"}, []string{"wl-paste", "--no-newline"}})
+	}
+	return append(tools,
+		clipboardTool{[]string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+		clipboardTool{[]string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+		// WSL
+		clipboardTool{[]string{"clip.exe"}, []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}},
+	)
+}
+
+// findClipboardTool returns the first installed clipboard tool.
+func findClipboardTool() (clipboardTool, error) {
+	var names []string
+	for _, tool := range clipboardTools() {
+		if _, err := exec.LookPath(tool.copy[0]); err == nil {
+			return tool, nil
+		}
+		names = append(names, tool.copy[0])
+	}
+	return clipboardTool{}, fmt.Errorf("no clipboard tool found, install one of: %s", strings.Join(names, ", "))
+}
+
+// write replaces the clipboard content. Stdout and stderr are left
+// unconnected since xclip and wl-copy keep running in the background to
+// serve the clipboard, which would block reading their output.
+func (t clipboardTool) write(s string) error {
+	cmd := exec.Command(t.copy[0], t.copy[1:]...)
+	cmd.Stdin = strings.NewReader(s)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", t.copy[0], err)
+	}
+	return nil
+}
+
+// read returns the clipboard content.
+func (t clipboardTool) read() (string, error) {
+	out, err := exec.Command(t.paste[0], t.paste[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v", t.paste[0], err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func (o *CommandOptions) addCopyPasswordFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.copyPassword, "copy", "", false, "Copy the generated password to the clipboard instead of printing it")
+	cmd.Flags().DurationVarP(&o.copyClear, "copy-clear", "", defaultCopyClear, "With --copy, wait this long and clear the clipboard again, 0 keeps the password in it")
+}
+
+// validateCopyPassword checks --copy.
+func (o *CommandOptions) validateCopyPassword() error {
+	if o.copyClear < 0 {
+		return fmt.Errorf("--copy-clear must not be negative")
+	}
+	if !o.copyPassword {
+		return nil
+	}
+	if !o.generate {
+		return fmt.Errorf("--copy requires --generate")
+	}
+	if o.generatedPasswordFile != "" || o.storePlaintextURI != "" {
+		return fmt.Errorf("--copy can't be combined with --generated-password-file or --store-plaintext")
+	}
+	return nil
+}
+
+// copyGeneratedPassword puts the password on the clipboard and, with
+// --copy-clear, waits until it's due to be cleared. Ctrl-C clears it right
+// away. The clipboard is left alone if something else was copied meanwhile.
+func (o *CommandOptions) copyGeneratedPassword(password string) error {
+	tool, err := findClipboardTool()
+	if err == nil {
+		err = tool.write(password)
+	}
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "Warning: the generated password couldn't be copied, printing it instead\n")
+		return o.passwordFallback(password, fmt.Errorf("unable to copy the generated password: %v", err))
+	}
+	if o.copyClear == 0 {
+		o.logf(logNormal, "Generated password for user %q copied to the clipboard", o.username)
+		return nil
+	}
+	o.logf(logNormal, "Generated password for user %q copied to the clipboard, clearing it in %s", o.username, o.copyClear)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+	select {
+	case <-time.After(o.copyClear):
+	case <-signals:
+	}
+	if current, err := tool.read(); err == nil && current != password {
+		return nil
+	}
+	if err := tool.write(""); err != nil {
+		return fmt.Errorf("unable to clear the clipboard: %v", err)
+	}
+	o.logf(logDetails, "Cleared the clipboard")
+	return nil
+}
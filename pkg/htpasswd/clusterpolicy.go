@@ -0,0 +1,121 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// The cluster policy is read from this ConfigMap before anything is hashed.
+// Admins create it, users only need to be able to get it.
+const (
+	clusterPolicyNamespace = "kube-system"
+	clusterPolicyName      = "kubectl-htpasswd-policy"
+	clusterPolicyKey       = "policy.yaml"
+)
+
+// clusterPolicy restricts the hashes of new passwords for all users of the
+// cluster.
+type clusterPolicy struct {
+	MinBcryptCost int `json:"minBcryptCost,omitempty"`
+	// AllowedAlgorithms are --hash names, or digest for htdigest data.
+	AllowedAlgorithms []string `json:"allowedAlgorithms,omitempty"`
+}
+
+func (o *CommandOptions) addOverridePolicyFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.overridePolicy, "override-policy", "", false, fmt.Sprintf("Hash even if the settings violate the cluster policy in configmap %s/%s, with a warning", clusterPolicyNamespace, clusterPolicyName))
+}
+
+// loadClusterPolicy reads the cluster policy. It's nil if there is none or
+// it can't be read with the permissions of the user.
+func (o *CommandOptions) loadClusterPolicy(ctx context.Context) (*clusterPolicy, error) {
+	client := o.secrets()
+	client.ns = clusterPolicyNamespace
+	cm, err := client.GetConfigMap(ctx, clusterPolicyName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if apierrors.IsForbidden(err) {
+		o.logf(logDetails, "Not allowed to read the cluster policy %s/%s, skipping it", clusterPolicyNamespace, clusterPolicyName)
+		return nil, nil
+	} else if err != nil {
+		return nil, apiError(err, "unable to get cluster policy %s/%s", clusterPolicyNamespace, clusterPolicyName)
+	}
+	data, ok := cm.Data[clusterPolicyKey]
+	if !ok {
+		return nil, fmt.Errorf("cluster policy %s/%s has no key %q", clusterPolicyNamespace, clusterPolicyName, clusterPolicyKey)
+	}
+	p := &clusterPolicy{}
+	if err := yaml.UnmarshalStrict([]byte(data), p); err != nil {
+		return nil, fmt.Errorf("invalid cluster policy %s/%s: %v", clusterPolicyNamespace, clusterPolicyName, err)
+	}
+	return p, nil
+}
+
+// check returns why hashing with the --hash name and bcrypt cost violates
+// the policy.
+func (p *clusterPolicy) check(name string, bcryptCost int) error {
+	if len(p.AllowedAlgorithms) > 0 && !containsString(p.AllowedAlgorithms, name) {
+		return fmt.Errorf("%s hashes aren't allowed, use one of: %s", name, strings.Join(p.AllowedAlgorithms, ", "))
+	}
+	if name == "bcrypt" && bcryptCost < p.MinBcryptCost {
+		return fmt.Errorf("bcrypt cost %d is below the minimum of %d", bcryptCost, p.MinBcryptCost)
+	}
+	return nil
+}
+
+// enforceClusterPolicy refuses to hash with any of the --hash names if that
+// violates the cluster policy, unless --override-policy is given. Local
+// data isn't subject to it.
+func (o *CommandOptions) enforceClusterPolicy(ctx context.Context, names []string) error {
+	if !o.needsCluster() {
+		return nil
+	}
+	p, err := o.loadClusterPolicy(ctx)
+	if err != nil || p == nil {
+		return err
+	}
+	for _, name := range names {
+		err := p.check(name, o.bcryptCost)
+		if err == nil {
+			continue
+		}
+		if !o.overridePolicy {
+			return validationError(fmt.Errorf("cluster policy %s/%s: %v; use --override-policy to hash anyway", clusterPolicyNamespace, clusterPolicyName, err))
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: overriding cluster policy %s/%s: %v\n", clusterPolicyNamespace, clusterPolicyName, err)
+	}
+	return nil
+}
+
+// specHashNames returns the --hash names the generated users of specs are
+// hashed with.
+func (o *CommandOptions) specHashNames(specs []*userSpecFile) []string {
+	var names []string
+	for _, spec := range specs {
+		for _, u := range spec.Users {
+			name := o.hashName
+			if u.Algorithm != "" {
+				name = u.Algorithm
+			}
+			if u.Generate && !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,215 +1,1315 @@
 package htpasswd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh/terminal"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
 )
 
 // CommandOptions ...
 type CommandOptions struct {
 	configFlags *genericclioptions.ConfigFlags
 	context     *api.Context
-	clientset   *kubernetes.Clientset
+	clientset   kubernetes.Interface
+	coreClient  rest.Interface
 	rawConfig   api.Config
+	restConfig  *rest.Config
+	inCluster   bool
 
-	args         []string
-	namespace    string
-	secretName   string
-	username     string
-	keyName      string
-	createSecret bool
-	deleteUser   bool
-	listUsers    bool
+	args              []string
+	namespace         string
+	secretName        string
+	username          string
+	keyNames          []string
+	createSecret      bool
+	deleteUser        bool
+	listUsers         bool
+	manifestPath      string
+	outputManifest    string
+	encrypt           string
+	ignoreCase        bool
+	output            string
+	controller        string
+	target            string
+	secretType        v1.SecretType
+	secretTypeName    string
+	appendOnly        bool
+	fromManifest      string
+	printOnly         bool
+	timeout           time.Duration
+	force             bool
+	hashName          string
+	bcryptCost        int
+	argon2Memory      int
+	argon2Iterations  int
+	argon2Parallelism int
+	insecureAllow     bool
+	overridePolicy    bool
+	hasher            Hasher
+	strict            bool
+	sortBy            string
+	usernameFlag      string
+	moveKey           string
+	verbosity         int
+	quiet             bool
+	shards            int
+	sizeWarn          int
+	usersKey          string
+	configPath        string
+	mirror            string
+	metricsFile       string
+	metrics           []secretMetrics
+	errorFormat       string
+	maxRetries        int
+	overwrite         bool
+	verify            bool
+	importFile        string
+	skipExisting      bool
+	selector          string
+	allNamespaces     bool
+	usernames         []string
+	deleteAll         bool
+	renameTo          string
+	renaming          bool
+	rehash            bool
+	pruneExpired      bool
+	rotate            bool
+	expires           string
+	expiresAt         time.Time
+	comment           string
+	commentSet        bool
+
+	serverSideApply bool
+	fieldManager    string
+	forceConflicts  bool
+
+	labels      []string
+	annotations []string
+	owner       string
+
+	format string
+
+	local     bool
+	localFile string
+	localMode os.FileMode
+
+	traefik        bool
+	middlewareName string
+	realm          string
+	yes            bool
+	confirmed      string
+	ifNotExists    bool
+	initKey        bool
+	allowUnicode   bool
+
+	dryRun string
+
+	passwordStdin bool
+	passwordFile  string
+	passwordEnv   string
+	passwordFrom  string
+
+	generate              bool
+	generateLength        int
+	charset               string
+	generatedPasswordFile string
+	storePlaintextURI     string
+	copyPassword          bool
+	copyClear             time.Duration
+	passwordsFile         string
+	passwordsSecret       string
+
+	policy     passwordPolicy
+	policyFile string
+
+	// password and importData are read once and reused when an update is
+	// retried after a conflict.
+	password   string
+	importData []byte
 
 	genericclioptions.IOStreams
 }
 
 // NewCommand ...
 func NewCommand(streams genericclioptions.IOStreams) *cobra.Command {
-	o := CommandOptions{
-		configFlags: genericclioptions.NewConfigFlags(true),
+	return newCommand(&CommandOptions{IOStreams: streams})
+}
 
-		IOStreams: streams,
-	}
+// newCommand builds the command tree on o. Clients already set on o, e.g.
+// the ones of a test cluster, are kept by Complete.
+func newCommand(o *CommandOptions) *cobra.Command {
+	o.configFlags = genericclioptions.NewConfigFlags(true)
+	o.fieldManager = defaultFieldManager
+	timeout := defaultRequestTimeout
+	o.configFlags.Timeout = &timeout
 
 	cmd := &cobra.Command{
-		Use:   "htpasswd SECRET <username>",
+		Use:   "htpasswd SECRET [<username>|-u <username>]",
 		Short: "Create or edit a htpasswd secret",
+		Long: `Create or edit htpasswd data stored in Kubernetes secrets.
+
+Without a subcommand the password of the given user is set, like "add".`,
+		Args: cobra.ArbitraryArgs,
+		// Errors are printed by the caller, which also picks the exit
+		// code, see ExitCode.
+		SilenceErrors:          true,
+		BashCompletionFunction: bashCompletionFunc,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := o.Complete(c, args); err != nil {
 				return err
 			}
 			if err := o.Validate(); err != nil {
-				return err
+				return validationError(err)
 			}
-			if err := o.Run(); err != nil {
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			if err := o.Run(ctx); err != nil {
 				return err
 			}
 			return nil
 		},
 	}
+
+	// The operation flags predate the subcommands and are kept for
+	// backward compatibility.
 	cmd.Flags().BoolVarP(&o.createSecret, "create", "c", false, "Create a new secret")
 	cmd.Flags().BoolVarP(&o.deleteUser, "delete-user", "D", false, "Delete the specified user")
 	cmd.Flags().BoolVarP(&o.listUsers, "list-users", "l", false, "List users")
-	cmd.Flags().StringVarP(&o.keyName, "key-name", "", "auth", "Secret key name")
-	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().MarkDeprecated("create", "use the create subcommand instead")
+	cmd.Flags().MarkDeprecated("delete-user", "use the delete subcommand instead")
+	cmd.Flags().MarkDeprecated("list-users", "use the list subcommand instead")
+	cmd.Flags().StringVarP(&o.moveKey, "move-key", "", "", "Move the htpasswd data of --key-name to this key")
+	cmd.Flags().BoolVarP(&o.overwrite, "overwrite", "", false, "Replace existing data in the target key")
+	cmd.Flags().StringVarP(&o.sortBy, "sort", "", "name", "Order of listed users. One of: name, algorithm")
+	cmd.Flags().BoolVarP(&o.printOnly, "print-only", "", false, "With --delete-user, only print the entry that would be removed")
+	o.addInitKeyFlag(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	cmd.Flags().BoolVarP(&o.ifNotExists, "if-not-exists", "", false, "With --create, add to the secret if it already exists")
+	cmd.Flags().BoolVarP(&o.appendOnly, "append-only", "", false, "Only allow adding new users, never change or delete existing ones")
+	o.addUsernameFlag(cmd)
+	o.addPasswordFlags(cmd)
+	o.addGenerateFlags(cmd)
+	o.addPolicyFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addExpiresFlag(cmd)
+	o.addCommentFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addShardsFlag(cmd)
+	o.addMetadataFlags(cmd)
+	o.addYesFlag(cmd)
+
+	cmd.PersistentFlags().StringVarP(&o.format, "format", "", formatHTPasswd, "Format of the password data. One of: htpasswd, htdigest")
+	cmd.PersistentFlags().StringSliceVarP(&o.keyNames, "key-name", "", []string{"auth"}, "Secret key name, may be repeated to apply the operation to several keys")
+	cmd.PersistentFlags().BoolVarP(&o.ignoreCase, "ignore-case", "", false, "Match usernames case-insensitively and store them in lowercase")
+	cmd.PersistentFlags().StringVarP(&o.secretTypeName, "secret-type", "", "", "Type of the secret. One of: opaque, basic-auth (default opaque)")
+	cmd.PersistentFlags().StringVarP(&o.controller, "controller", "", "", "Use the defaults of an ingress controller. One of: nginx, traefik, haproxy")
+	cmd.PersistentFlags().StringVarP(&o.target, "target", "", "", "Only allow hashes the consumer of the data can check and warn about others. One of: "+strings.Join(targetNames(), ", "))
+	cmd.PersistentFlags().IntVarP(&o.maxRetries, "max-retries", "", 3, "Number of retries of API requests failing with transient errors")
+	cmd.PersistentFlags().CountVarP(&o.verbosity, "verbose", "v", "Print more details, -vv also logs the API requests")
+	cmd.PersistentFlags().BoolVarP(&o.quiet, "quiet", "q", false, "Only print warnings, errors and the requested output")
+	cmd.PersistentFlags().StringVarP(&o.metricsFile, "metrics-file", "", "", "After the operation write metrics about the secret to this file for the textfile collector of node_exporter")
+	cmd.PersistentFlags().StringVarP(&o.errorFormat, "error-format", "", errorFormatText, "Format of the error printed on failure. One of: text, json")
+	cmd.PersistentFlags().BoolVarP(&o.inCluster, "in-cluster", "", false, "Connect with the service account of the pod instead of the kubeconfig")
+	cmd.PersistentFlags().StringVarP(&o.configPath, "config", "", "", "Configuration file with defaults for the flags, defaults to ~/.config/kubectl-htpasswd/config.yaml")
+	cmd.PersistentFlags().StringVarP(&o.fromManifest, "from-manifest", "", "", "Read the secret from a YAML manifest (- for stdin) instead of the cluster and print the result to stdout")
+	o.configFlags.AddFlags(cmd.PersistentFlags())
+
+	cmd.AddCommand(newAddCommand(o))
+	cmd.AddCommand(newCreateCommand(o))
+	cmd.AddCommand(newDeleteCommand(o))
+	cmd.AddCommand(newListCommand(o))
+	cmd.AddCommand(newVerifyCommand(o))
+	cmd.AddCommand(newRenameCommand(o))
+	cmd.AddCommand(newRehashCommand(o))
+	cmd.AddCommand(newPruneExpiredCommand(o))
+	cmd.AddCommand(newRotateCommand(o))
+	cmd.AddCommand(newEditCommand(o))
+	cmd.AddCommand(newEditRawCommand(o))
+	cmd.AddCommand(newImportCommand(o))
+	cmd.AddCommand(newExportCommand(o))
+	cmd.AddCommand(newSyncCommand(o))
+	cmd.AddCommand(newApplyCommand(o))
+	cmd.AddCommand(newControllerCommand(o))
+	cmd.AddCommand(newCRDCommand(o))
+	cmd.AddCommand(newCopyCommand(o))
+	cmd.AddCommand(newDiffCommand(o))
+	cmd.AddCommand(newWatchCommand(o))
+	cmd.AddCommand(newAttachIngressCommand(o))
+	cmd.AddCommand(newDetachIngressCommand(o))
+	cmd.AddCommand(newEmitNginxCommand(o))
+	cmd.AddCommand(newCheckCommand(o))
+	cmd.AddCommand(newKeysCommand(o))
+	cmd.AddCommand(newHistoryCommand(o))
+	cmd.AddCommand(newLocalCommand(o))
+	cmd.AddCommand(newVersionCommand(o))
+	cmd.AddCommand(newCompletionCommand(cmd))
+	cmd.AddCommand(newCompleteCommand(o))
+	cmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return validationError(err)
+	})
 
 	return cmd
 }
 
+// controllerDefaults holds the secret layout expected by an ingress controller.
+type controllerDefaults struct {
+	keyName    string
+	secretType v1.SecretType
+}
+
+// The service account of a pod, variables so tests can provide their own.
+var (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	inClusterConfig             = rest.InClusterConfig
+)
+
+var controllers = map[string]controllerDefaults{
+	"nginx":   {keyName: "auth", secretType: v1.SecretTypeOpaque},
+	"traefik": {keyName: "users", secretType: v1.SecretTypeOpaque},
+	"haproxy": {keyName: "auth", secretType: v1.SecretTypeOpaque},
+}
+
 // Complete populates some fields from the factory, grabs command line
 // arguments and looks up the node using Builder
 func (o *CommandOptions) Complete(cmd *cobra.Command, args []string) error {
 	o.args = args
+	o.commentSet = cmd.Flags().Changed("comment")
+	o.secretType = v1.SecretTypeOpaque
+	if o.controller != "" {
+		defaults, ok := controllers[o.controller]
+		if !ok {
+			return fmt.Errorf("unknown controller %q", o.controller)
+		}
+		if !cmd.Flags().Changed("key-name") {
+			o.keyNames = []string{defaults.keyName}
+		}
+		o.secretType = defaults.secretType
+	}
+	if _, ok := targets[o.target]; o.target != "" && !ok {
+		return validationError(fmt.Errorf("unknown target %q, must be one of: %s", o.target, strings.Join(targetNames(), ", ")))
+	}
+	if o.secretTypeName != "" {
+		secretType, ok := secretTypes[o.secretTypeName]
+		if !ok {
+			return fmt.Errorf("unknown secret type %q, must be opaque or basic-auth", o.secretTypeName)
+		}
+		o.secretType = secretType
+	}
+
 	var err error
-	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+	o.timeout, err = o.requestTimeout()
 	if err != nil {
 		return err
 	}
+	if o.maxRetries < 0 {
+		return fmt.Errorf("--max-retries must not be negative")
+	}
+	if err := o.validateVerbosity(); err != nil {
+		return err
+	}
+	if o.errorFormat != errorFormatText && o.errorFormat != errorFormatJSON {
+		return validationError(fmt.Errorf("--error-format must be text or json"))
+	}
+	if o.errorFormat == errorFormatJSON {
+		// keep stderr parseable
+		cmd.SilenceUsage = true
+	}
 
-	context, exists := o.rawConfig.Contexts[o.rawConfig.CurrentContext]
-	if !exists {
-		return fmt.Errorf("missing context")
+	if !o.needsCluster() {
+		if o.configFlags.Namespace != nil {
+			o.namespace = *o.configFlags.Namespace
+		}
+		return o.applyConfig(cmd)
 	}
-	o.context = context
-	if o.configFlags.Namespace != nil && *o.configFlags.Namespace != "" {
-		o.namespace = *o.configFlags.Namespace
+
+	var restConfig *rest.Config
+	var namespace, source string
+	if o.inCluster {
+		if o.configFlags.Context != nil && *o.configFlags.Context != "" {
+			return fmt.Errorf("--in-cluster can't be combined with --context")
+		}
 	} else {
-		o.namespace = context.Namespace
+		o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+		if err != nil {
+			return fmt.Errorf("unable to load kubeconfig: %v", err)
+		}
+		o.context, err = o.selectedContext()
+		if err != nil {
+			return err
+		}
+		if o.context != nil {
+			namespace, source = o.context.Namespace, fmt.Sprintf("context %q", o.contextName())
+		}
 	}
-
-	config, err := o.configFlags.ToRESTConfig()
-	if err != nil {
-		return err
+	if o.context == nil {
+		// Without a kubeconfig context fall back to the service account
+		// when running inside a pod.
+		restConfig, err = inClusterConfig()
+		if err != nil && o.inCluster {
+			return fmt.Errorf("unable to load in-cluster config: %v", err)
+		} else if err != nil {
+			return fmt.Errorf("missing context %q, check your kubeconfig, pass --context or --cluster, or run inside a pod", o.rawConfig.CurrentContext)
+		}
+		data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return fmt.Errorf("unable to read service account namespace: %v", err)
+		}
+		namespace, source = strings.TrimSpace(string(data)), "service account"
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
+	if o.configFlags.Namespace != nil && *o.configFlags.Namespace != "" {
+		namespace, source = *o.configFlags.Namespace, "--namespace flag"
+	} else if namespace == "" && o.context != nil {
+		namespace, source = v1.NamespaceDefault, "fallback, the context has no namespace"
+	}
+	if namespace == "" {
+		return fmt.Errorf("unable to determine the namespace, please specify --namespace")
+	}
+	o.namespace = namespace
+	o.logf(logDetails, "Using namespace %q (%s)", o.namespace, source)
+	if err := o.applyConfig(cmd); err != nil {
 		return err
 	}
-	o.clientset = clientset
+
+	if restConfig == nil {
+		restConfig, err = o.configFlags.ToRESTConfig()
+		if err != nil {
+			return fmt.Errorf("unable to connect to cluster using context %q: %v", o.contextName(), err)
+		}
+	}
+	o.wrapTransport(restConfig)
+	// clients set before Complete, e.g. fake ones, are kept
+	if o.clientset == nil {
+		o.clientset, err = kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("unable to connect to cluster using context %q: %v", o.contextName(), err)
+		}
+	}
+	// the fake clientset returns a nil REST client, its typed clients are
+	// used then
+	if client, ok := o.clientset.CoreV1().RESTClient().(*rest.RESTClient); o.coreClient == nil && (!ok || client != nil) {
+		o.coreClient = o.clientset.CoreV1().RESTClient()
+	}
+	o.restConfig = restConfig
 
 	return nil
 }
 
+// logf prints a message to stderr if the verbosity is at least level, see
+// logNormal.
+func (o *CommandOptions) logf(level int, format string, args ...interface{}) {
+	if o.verbosity >= level {
+		fmt.Fprintf(o.ErrOut, format+"\n", args...)
+	}
+}
+
+// needsCluster reports whether the selected operation talks to the API
+// server. Operations working purely on local data skip the clientset
+// creation so they also work offline.
+func (o *CommandOptions) needsCluster() bool {
+	return o.fromManifest == "" && !o.local
+}
+
+// contextName returns the name of the kubeconfig context in use.
+func (o *CommandOptions) contextName() string {
+	if o.configFlags.Context != nil && *o.configFlags.Context != "" {
+		return *o.configFlags.Context
+	}
+	if o.context == nil {
+		return "in-cluster"
+	}
+	return o.rawConfig.CurrentContext
+}
+
+// selectedContext returns the kubeconfig context given by --context, or the
+// current one, with the --cluster and --user overrides applied. Without any
+// context --cluster alone is enough to connect.
+func (o *CommandOptions) selectedContext() (*api.Context, error) {
+	name := o.rawConfig.CurrentContext
+	if o.configFlags.Context != nil && *o.configFlags.Context != "" {
+		name = *o.configFlags.Context
+		if _, exists := o.rawConfig.Contexts[name]; !exists {
+			return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+		}
+	}
+	context := api.NewContext()
+	if c, exists := o.rawConfig.Contexts[name]; exists {
+		*context = *c
+	} else if o.configFlags.ClusterName == nil || *o.configFlags.ClusterName == "" {
+		return nil, nil
+	}
+	if o.configFlags.ClusterName != nil && *o.configFlags.ClusterName != "" {
+		context.Cluster = *o.configFlags.ClusterName
+	}
+	if o.configFlags.AuthInfoName != nil && *o.configFlags.AuthInfoName != "" {
+		context.AuthInfo = *o.configFlags.AuthInfoName
+	}
+	if _, exists := o.rawConfig.Clusters[context.Cluster]; !exists {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", context.Cluster)
+	}
+	return context, nil
+}
+
 // Validate validates commandline arguments.
 func (o *CommandOptions) Validate() error {
-	if len(o.args) == 1 && o.listUsers {
-		o.secretName = o.args[0]
-		return nil
-	} else if len(o.args) == 2 {
-		o.secretName = o.args[0]
-		o.username = o.args[1]
-		return nil
+	if err := o.validateDryRun(); err != nil {
+		return err
+	}
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if err := o.validateLocal(); err != nil {
+		return err
+	}
+	if err := o.validatePasswordFlags(); err != nil {
+		return err
+	}
+	if err := o.validateGenerate(); err != nil {
+		return err
+	}
+	if err := o.validateCopyPassword(); err != nil {
+		return err
 	}
-	return fmt.Errorf("secret and username are required")
+	if err := o.validatePolicy(); err != nil {
+		return err
+	}
+	if err := o.validateImport(); err != nil {
+		return err
+	}
+	if err := o.validateSelector(); err != nil {
+		return err
+	}
+	if err := o.validateApply(); err != nil {
+		return err
+	}
+	if o.ifNotExists && !o.createSecret {
+		return fmt.Errorf("--if-not-exists requires create")
+	}
+	if o.initKey && (!o.setsPassword() || o.rotate) {
+		return fmt.Errorf("--init-key requires add")
+	}
+	if err := o.validateMetadata(); err != nil {
+		return err
+	}
+	if err := o.validateTraefik(); err != nil {
+		return err
+	}
+	if err := o.validateOutputManifest(); err != nil {
+		return err
+	}
+	if err := o.validateExpires(time.Now()); err != nil {
+		return err
+	}
+	if err := o.validateComment(); err != nil {
+		return err
+	}
+	hasher, err := newHasher(o.hashName, o.hashOptions())
+	if err != nil {
+		return err
+	}
+	o.hasher = hasher
+	if err := o.validateFormat(); err != nil {
+		return err
+	}
+	if err := o.validateTarget(); err != nil {
+		return err
+	}
+	if o.sortBy != "name" && o.sortBy != "algorithm" {
+		return fmt.Errorf("unsupported sort order %q", o.sortBy)
+	}
+	if o.printOnly && !o.deleteUser {
+		return fmt.Errorf("--print-only requires --delete-user")
+	}
+	if o.appendOnly && o.deleteUser {
+		return fmt.Errorf("--append-only can't be combined with --delete-user")
+	}
+	if o.moveKey != "" {
+		if o.secretType == v1.SecretTypeBasicAuth {
+			return fmt.Errorf("--move-key isn't supported for basic-auth secrets")
+		}
+		if len(o.keyNames) != 1 {
+			return fmt.Errorf("--move-key requires exactly one --key-name")
+		}
+		if o.createSecret || o.deleteUser || o.listUsers {
+			return fmt.Errorf("--move-key can't be combined with other operations")
+		}
+		if errs := validation.IsConfigMapKey(o.moveKey); len(errs) > 0 {
+			return fmt.Errorf("invalid key name %q: %s", o.moveKey, strings.Join(errs, ", "))
+		}
+		if o.moveKey == o.keyNames[0] {
+			return fmt.Errorf("--move-key must differ from --key-name")
+		}
+	}
+	if o.fromManifest != "" {
+		if o.createSecret {
+			return fmt.Errorf("--from-manifest can't be combined with --create")
+		}
+		if o.output != "" {
+			return fmt.Errorf("--from-manifest can't be combined with --output")
+		}
+	}
+
+	args := o.args
+	if o.fromManifest == "" && o.selector == "" && !o.local {
+		if len(args) == 0 {
+			return fmt.Errorf("secret is required")
+		}
+		o.secretName, args = args[0], args[1:]
+	}
+	if o.renaming {
+		if len(args) != 2 {
+			return fmt.Errorf("rename requires the old and the new username")
+		}
+		o.renameTo, args = args[1], args[:1]
+	}
+	if len(args) > 1 && !o.deleteUser && !o.rotate {
+		return fmt.Errorf("too many arguments")
+	}
+	if o.pruneExpired && len(args) > 0 {
+		return fmt.Errorf("prune-expired doesn't take usernames")
+	}
+	o.usernames = args
+	if o.usernameFlag != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("username given both as argument and with --username")
+		}
+		o.usernames = []string{o.usernameFlag}
+	}
+	if o.deleteAll {
+		if !o.deleteUser {
+			return fmt.Errorf("--all requires delete")
+		}
+		if len(o.usernames) > 0 {
+			return fmt.Errorf("--all can't be combined with usernames")
+		}
+	}
+	if len(o.usernames) > 0 {
+		o.username = strings.Join(o.usernames, ",")
+		if len(o.usernames) == 1 {
+			o.username = o.usernames[0]
+		}
+	}
+	if o.username == "" && o.needsUsername() {
+		return fmt.Errorf("username is required")
+	}
+	if err := o.validateNewUsernames(); err != nil {
+		return err
+	}
+	if err := o.validateShards(); err != nil {
+		return err
+	}
+	if err := o.validateSizeWarn(); err != nil {
+		return err
+	}
+	if err := o.validateUsersKey(); err != nil {
+		return err
+	}
+	if err := o.validateMirror(); err != nil {
+		return err
+	}
+	return o.validateRotate()
+}
+
+// needsUsername reports whether the selected operation works on a single
+// user.
+func (o *CommandOptions) needsUsername() bool {
+	return !o.listUsers && o.moveKey == "" && o.importFile == "" && !o.deleteAll && !o.pruneExpired && !o.rotate
+}
+
+// validateKeyNames trims the key names and checks that they are valid
+// secret data keys.
+func (o *CommandOptions) validateKeyNames() error {
+	if len(o.keyNames) == 0 {
+		return fmt.Errorf("--key-name must not be empty")
+	}
+	seen := make(map[string]bool)
+	for i, key := range o.keyNames {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return fmt.Errorf("--key-name must not be empty")
+		}
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return fmt.Errorf("invalid key name %q: %s", key, strings.Join(errs, ", "))
+		}
+		if seen[key] {
+			return fmt.Errorf("key name %q given more than once", key)
+		}
+		seen[key] = true
+		o.keyNames[i] = key
+	}
+	return nil
 }
 
-// Run runs the htpasswd command.
-func (o *CommandOptions) Run() error {
+// Run runs the htpasswd command. All API requests are bound to ctx and each
+// one is limited by --request-timeout.
+func (o *CommandOptions) Run(ctx context.Context) error {
+	if o.setsPassword() {
+		if err := o.enforceClusterPolicy(ctx, []string{o.newHashName()}); err != nil {
+			return err
+		}
+	}
 	var err error
-	secret, data, err := o.getSecret()
+	switch {
+	case o.shards > 0:
+		err = o.runShards(ctx)
+	case o.selector != "":
+		err = o.runSelector(ctx)
+	default:
+		err = o.runWithRetry(ctx)
+	}
+	if metricsErr := o.writeMetrics(); metricsErr != nil && err == nil {
+		return metricsErr
+	}
+	return err
+}
+
+// runWithRetry runs the operation on the secret o.secretName.
+func (o *CommandOptions) runWithRetry(ctx context.Context) error {
+	// Updates carry the resourceVersion of the secret read, so a concurrent
+	// change makes the update fail with a conflict. Start over from a fresh
+	// copy instead of overwriting the other change.
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		err := o.run(ctx)
+		if apierrors.IsConflict(err) {
+			o.logf(logDetails, "Secret %q was modified concurrently, retrying", o.secretName)
+		}
+		return err
+	})
+}
+
+// run performs a single read-modify-write cycle of the selected operation.
+func (o *CommandOptions) run(ctx context.Context) error {
+	secret, err := o.getSecret(ctx)
 	if err != nil {
 		return err
 	}
+	if err := o.runOperation(ctx, secret); err != nil {
+		return err
+	}
+	o.collectMetrics(secret)
+	return nil
+}
 
-	htpasswd, err := newPasswordFile(data)
+// runOperation dispatches the selected operation on secret.
+func (o *CommandOptions) runOperation(ctx context.Context, secret *v1.Secret) error {
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return o.runBasicAuth(ctx, secret)
+	}
+
+	files, err := o.loadPasswordFiles(secret)
 	if err != nil {
 		return err
 	}
 
-	if o.listUsers {
-		users, err := htpasswd.ListUsers()
+	switch {
+	case o.listUsers:
+		return o.runList(secret, files)
+	case o.verify:
+		return o.runVerify(files)
+	}
+
+	if manager := managedBy(secret); manager != "" && !o.printOnly {
+		if !o.force {
+			return fmt.Errorf("secret %q is managed by %s and changes may be reverted, use --force to edit it anyway", secret.Name, manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %q is managed by %s\n", secret.Name, manager)
+	}
+
+	switch {
+	case o.moveKey != "":
+		return o.runMoveKey(ctx, secret)
+	case o.importFile != "":
+		return o.runImport(ctx, secret, files)
+	case o.renaming:
+		return o.runRename(ctx, secret, files)
+	case o.rehash:
+		return o.runRehash(ctx, secret, files)
+	case o.deleteUser:
+		return o.runDelete(ctx, secret, files)
+	case o.pruneExpired:
+		return o.runPruneExpired(ctx, secret, files)
+	case o.rotate:
+		return o.runRotate(ctx, secret, files)
+	}
+	return o.runSet(ctx, secret, files)
+}
+
+// runList prints the users of every key.
+func (o *CommandOptions) runList(secret *v1.Secret, files []*keyFile) error {
+	expiries, err := readExpiries(secret)
+	if err != nil {
+		return err
+	}
+	comments, err := readComments(secret)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var keys []keyUsers
+	for _, f := range files {
+		users, err := f.ListUsers()
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Existing users:\n")
+		if o.sortBy == "algorithm" {
+			f.SortUsersByAlgorithm(users)
+		}
+		if o.output != "" {
+			k := keyUsers{Key: f.key, Users: []userInfo{}}
+			for _, u := range users {
+				info := newUserInfo(u, f.passwords[u])
+				if t, ok := expiries[u]; ok {
+					info.Expires = t.Format(time.RFC3339)
+				}
+				info.Comment = comments[u]
+				k.Users = append(k.Users, info)
+			}
+			keys = append(keys, k)
+			continue
+		}
+		if len(files) > 1 {
+			fmt.Fprintf(o.Out, "Existing users in key %q:\n", f.key)
+		} else {
+			fmt.Fprintf(o.Out, "Existing users:\n")
+		}
 		for _, u := range users {
-			fmt.Println(u)
+			fmt.Fprintln(o.Out, u+expiryNote(expiries[u], now)+commentNote(comments[u]))
 		}
-		return nil
 	}
+	if o.output != "" {
+		return o.printUserList(secret, keys)
+	}
+	return nil
+}
 
-	if o.deleteUser {
-		if err := htpasswd.DeleteUser(o.username); err != nil {
-			return err
+// runVerify checks a password against the stored hash of every key.
+func (o *CommandOptions) runVerify(files []*keyFile) error {
+	for _, f := range files {
+		if _, ok := f.lookup(o.username); !ok {
+			return notFoundError("user %q does not exist in key %q", o.username, f.key)
+		}
+	}
+	password, err := readPassword(o, "verify")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		ok, err := f.CheckPassword(o.username, password)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", f.key, err)
+		}
+		if !ok {
+			name, _ := f.lookup(o.username)
+			return fmt.Errorf("password for user %q doesn't match the %s hash in key %q", o.username, f.algorithm(name), f.key)
 		}
-		secret.Data[o.keyName] = htpasswd.Bytes()
-		_, err = o.clientset.CoreV1().Secrets(o.namespace).Update(secret)
+	}
+	o.logf(logNormal, "Password for user %q is correct", o.username)
+	return nil
+}
+
+// runMoveKey moves the data of the selected key to o.moveKey.
+func (o *CommandOptions) runMoveKey(ctx context.Context, secret *v1.Secret) error {
+	from := o.keyNames[0]
+	if _, exists := secret.Data[o.moveKey]; exists && !o.overwrite {
+		return fmt.Errorf("key %q already exists, use --overwrite to replace it", o.moveKey)
+	}
+	secret.Data[o.moveKey] = secret.Data[from]
+	delete(secret.Data, from)
+	o.recordChange(secret, "move-key", nil, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Moved key %q to %q%s", from, o.moveKey, o.dryRunSuffix())
+	return nil
+}
+
+// runRename moves the hash of o.username to o.renameTo in every key.
+func (o *CommandOptions) runRename(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	from, _ := files[0].lookup(o.username)
+	for _, f := range files {
+		if err := f.RenameUser(o.username, o.renameTo); err != nil {
+			if _, ok := f.lookup(o.username); !ok {
+				return notFoundError("user %q does not exist in key %q", o.username, f.key)
+			}
+			return conflictError("key %q: %v", f.key, err)
+		}
+		f.operation = "rename"
+		secret.Data[f.key] = f.Bytes()
+	}
+	if err := o.updateUserMetadata(secret, from, o.renameTo, false); err != nil {
+		return err
+	}
+	o.recordChange(secret, "rename", []string{o.username, o.renameTo}, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
 		return err
 	}
+	o.logf(logNormal, "Renamed user %q to %q%s", o.username, o.renameTo, o.dryRunSuffix())
+	return o.printResults(files)
+}
 
-	fmt.Printf("Enter password: ")
-	password1, err := terminal.ReadPassword(0)
+// runRehash checks the current password of o.username and hashes it again
+// with the selected algorithm, e.g. to migrate legacy SHA-1 entries.
+func (o *CommandOptions) runRehash(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	for _, f := range files {
+		if _, ok := f.lookup(o.username); !ok {
+			return notFoundError("user %q does not exist in key %q", o.username, f.key)
+		}
+	}
+	password, err := readPassword(o, "verify")
 	if err != nil {
 		return err
 	}
-	fmt.Printf("\nRepeat password: ")
-	password2, err := terminal.ReadPassword(0)
+	var messages []string
+	for _, f := range files {
+		name, _ := f.lookup(o.username)
+		old := f.algorithm(name)
+		ok, err := f.CheckPassword(o.username, password)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", f.key, err)
+		}
+		if !ok {
+			return fmt.Errorf("password for user %q doesn't match the %s hash in key %q", o.username, old, f.key)
+		}
+		if err := f.SetPassword(o.username, password); err != nil {
+			return err
+		}
+		f.operation = "rehash"
+		secret.Data[f.key] = f.Bytes()
+		messages = append(messages, fmt.Sprintf("Rehashed user %q in key %q from %s to %s", o.username, f.key, old, o.newHashName()))
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	o.recordChange(secret, "rehash", []string{o.username}, o.newHashName())
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		o.logf(logNormal, "%s%s", m, o.dryRunSuffix())
+	}
+	return o.printResults(files)
+}
+
+// runDelete removes the user from every key.
+func (o *CommandOptions) runDelete(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	var entries []string
+	removed := make(map[*keyFile][]string)
+	for _, f := range files {
+		usernames := o.usernames
+		if o.deleteAll {
+			usernames, _ = f.ListUsers()
+		}
+		for _, username := range usernames {
+			existing, ok := f.lookup(username)
+			if !ok {
+				return notFoundError("user %q does not exist in key %q", username, f.key)
+			}
+			entries = append(entries, fmt.Sprintf("%s from key %q", describeEntry(existing, f.passwords[existing]), f.key))
+			removed[f] = append(removed[f], existing)
+		}
+	}
+	if o.printOnly {
+		for _, e := range entries {
+			fmt.Fprintf(o.Out, "would remove %s\n", e)
+		}
+		return nil
+	}
+	question := fmt.Sprintf("Delete %s of secret %q?", strings.Join(entries, ", "), secret.Name)
+	if o.deleteAll {
+		question = fmt.Sprintf("Delete all %d entries of secret %q?", len(entries), secret.Name)
+	}
+	if err := o.confirm(secret, question); err != nil {
+		return err
+	}
+	var users []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		for _, username := range removed[f] {
+			if err := f.DeleteUser(username); err != nil {
+				return err
+			}
+			if !seen[username] {
+				seen[username] = true
+				users = append(users, username)
+				if err := o.updateUserMetadata(secret, username, "", false); err != nil {
+					return err
+				}
+			}
+		}
+		f.operation = "delete"
+		secret.Data[f.key] = f.Bytes()
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	o.recordChange(secret, "delete", users, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		o.logf(logNormal, "Removed %s%s", e, o.dryRunSuffix())
+	}
+	return o.printResults(files)
+}
+
+// runSet adds the user or changes its password in every key.
+func (o *CommandOptions) runSet(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	operation := "add"
+	for _, f := range files {
+		f.operation = "add"
+		if existing, exists := f.lookup(o.username); exists {
+			if o.appendOnly {
+				return fmt.Errorf("user %q already exists in key %q and --append-only is set", existing, f.key)
+			}
+			f.operation = "update"
+			operation = "update"
+		}
+	}
+
+	password, err := readPassword(o, operation)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("\n")
-	if string(password1) != string(password2) {
-		fmt.Println("passwords don't match")
-		os.Exit(1)
+
+	for _, f := range files {
+		if err := f.SetPassword(o.username, password); err != nil {
+			return err
+		}
+		secret.Data[f.key] = f.Bytes()
+	}
+	name, _ := files[0].lookup(o.username)
+	if err := o.updateUserMetadata(secret, name, "", true); err != nil {
+		return err
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	o.recordChange(secret, operation, []string{o.username}, o.newHashName())
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	for _, f := range files {
+		o.logf(logNormal, "Password updated successfully in key %q%s", f.key, o.dryRunSuffix())
+	}
+	if err := o.showGeneratedPassword(password); err != nil {
+		return err
+	}
+	return o.printResults(files)
+}
+
+// managedBy returns a description of the controller owning secret or an
+// empty string if it isn't managed by one.
+func managedBy(secret *v1.Secret) string {
+	// the owner set with --owner is no controller, other owners may be
+	owner := secret.Annotations[ownerAnnotation]
+	for _, ref := range secret.OwnerReferences {
+		isController := ref.Controller != nil && *ref.Controller
+		if isController || owner == "" || string(ref.UID) != owner {
+			return fmt.Sprintf("%s %q", ref.Kind, ref.Name)
+		}
+	}
+	if manager, ok := secret.Labels["app.kubernetes.io/managed-by"]; ok {
+		return fmt.Sprintf("%q", manager)
+	}
+	return ""
+}
+
+// keyFile is the parsed htpasswd data of a single secret key.
+type keyFile struct {
+	*passwordFile
+
+	key       string
+	operation string
+}
+
+// loadPasswordFiles parses the htpasswd data of every selected key.
+func (o *CommandOptions) loadPasswordFiles(secret *v1.Secret) ([]*keyFile, error) {
+	var files []*keyFile
+	for _, key := range o.keyNames {
+		if !looksLikePasswordFile(secret.Data[key]) {
+			return nil, fmt.Errorf("key %q does not contain htpasswd data", key)
+		}
+		htpasswd, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key, err)
+		}
+		htpasswd.allowPlain = o.insecureAllow
+		for _, w := range htpasswd.warnings {
+			fmt.Fprintf(o.ErrOut, "Warning: key %q: %s\n", key, w)
+		}
+		o.warnTarget(key, htpasswd)
+		files = append(files, &keyFile{passwordFile: htpasswd, key: key})
+	}
+	return files, nil
+}
+
+// describeEntry returns a log friendly description of an entry which only
+// reveals the beginning of the hash.
+func describeEntry(username, hash string) string {
+	algorithm := hashAlgorithm(hash)
+	if algorithm == "" {
+		algorithm = "unknown"
+	}
+	prefix := hash
+	if len(prefix) > 8 {
+		prefix = prefix[:8] + "..."
+	}
+	return fmt.Sprintf("user %q (%s, %s)", username, algorithm, prefix)
+}
+
+// operationResult is the machine-readable summary of a mutating operation.
+type operationResult struct {
+	Secret    string `json:"secret"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Operation string `json:"operation"`
+	Username  string `json:"username"`
+	Users     int    `json:"users"`
+}
+
+// printResults prints the per key results of a successful operation to
+// stdout if a structured output format was requested.
+func (o *CommandOptions) printResults(files []*keyFile) error {
+	if o.output == "" || o.dryRun != "" {
+		return nil
+	}
+	for _, f := range files {
+		users, err := f.ListUsers()
+		if err != nil {
+			return err
+		}
+		result := operationResult{
+			Secret:    o.secretName,
+			Namespace: o.namespace,
+			Key:       f.key,
+			Operation: f.operation,
+			Username:  o.username,
+			Users:     len(users),
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.Out, string(data))
+	}
+	return nil
+}
+
+// saveSecret writes the manifest if requested and creates or updates the
+// secret in the cluster.
+func (o *CommandOptions) saveSecret(ctx context.Context, secret *v1.Secret) error {
+	if o.local {
+		return o.writeLocalFile(secret.Data[o.keyNames[0]])
+	}
+	if err := o.updateUsersKey(secret); err != nil {
+		return err
+	}
+	// Manifests are usually kept in version control, don't add a changing
+	// timestamp to them.
+	if o.fromManifest == "" && o.outputManifest == "" {
+		setManagedBy(secret)
+		setLastModified(secret)
+	}
+	if o.outputManifest != "" {
+		return o.writeOutputManifest(ctx, secret)
 	}
 
-	if err := htpasswd.SetPassword(o.username, string(password1)); err != nil {
+	if o.fromManifest != "" {
+		if o.manifestPath != "" {
+			if err := writeManifest(o.manifestPath, secret); err != nil {
+				return err
+			}
+		}
+		data, err := yaml.Marshal(secret)
+		if err != nil {
+			return err
+		}
+		_, err = o.Out.Write(data)
 		return err
 	}
-	secret.Data[o.keyName] = htpasswd.Bytes()
-	if o.createSecret {
-		_, err = o.clientset.CoreV1().Secrets(o.namespace).Create(secret)
-	} else {
-		_, err = o.clientset.CoreV1().Secrets(o.namespace).Update(secret)
+
+	if o.dryRun == dryRunClient {
+		if o.manifestPath != "" {
+			return writeManifest(o.manifestPath, secret)
+		}
+		return o.printSecret(cleanManifest(secret))
+	}
+
+	// The mirror is written first and restored if saving the secret fails,
+	// so it never holds users the secret doesn't.
+	var mirror *mirrorSecret
+	if o.mirror != "" {
+		m, err := o.writeMirror(ctx, secret)
+		if err != nil {
+			return err
+		}
+		mirror = m
+	}
+	var err error
+	var result *v1.Secret
+	switch {
+	case o.serverSideApply:
+		result, err = o.secrets().Apply(ctx, o.applyConfiguration(secret), o.forceConflicts)
+		err = applyConflict(err)
+	case o.createSecret && secret.ResourceVersion == "":
+		// an existing secret being replaced carries its resourceVersion
+		result, err = o.secrets().Create(ctx, secret)
+	default:
+		result, err = o.secrets().Update(ctx, secret)
 	}
 	if err != nil {
-		fmt.Println("Password updated successfully")
+		if mirror != nil {
+			o.restoreMirror(ctx, mirror)
+		}
+		return err
 	}
-	return err
+	// written from what the server returned, a rejected change must not
+	// end up in the file
+	if o.manifestPath != "" {
+		if err := writeManifest(o.manifestPath, result); err != nil {
+			return err
+		}
+	}
+	if o.traefik {
+		if err := o.applyTraefikMiddleware(ctx); err != nil {
+			return err
+		}
+	}
+	if o.dryRun == dryRunServer && o.manifestPath == "" {
+		return o.printSecret(result)
+	}
+	return nil
 }
 
-func (o *CommandOptions) getSecret() (*v1.Secret, []byte, error) {
+func (o *CommandOptions) getSecret(ctx context.Context) (*v1.Secret, error) {
+	if o.local {
+		return o.readLocalFile()
+	}
 	if o.createSecret {
-		secret := &v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      o.secretName,
-				Namespace: o.namespace,
-			},
-			Type: v1.SecretTypeOpaque,
-			Data: make(map[string][]byte),
+		existing, err := o.existingSecret(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil || !o.ifNotExists {
+			return o.newSecret(ctx, existing)
+		}
+		o.logf(logDetails, "Secret %q already exists, updating it", o.secretName)
+		if existing.Type != o.secretType {
+			return nil, fmt.Errorf("invalid secret type %q, expected %q", existing.Type, o.secretType)
+		}
+		// missing keys are created
+		if existing.Data == nil {
+			existing.Data = make(map[string][]byte)
 		}
-		return secret, nil, nil
+		return existing, nil
 	}
 
-	secret, err := o.clientset.CoreV1().Secrets(o.namespace).Get(o.secretName, metav1.GetOptions{})
+	var secret *v1.Secret
+	if o.fromManifest != "" {
+		var err error
+		secret, err = o.readManifest()
+		if err != nil {
+			return nil, err
+		}
+		o.secretName = secret.Name
+		o.namespace = secret.Namespace
+	} else {
+		var err error
+		secret, err = o.secrets().Get(ctx, o.secretName)
+		if apierrors.IsNotFound(err) {
+			return nil, notFoundError("secret %q not found in namespace %q", o.secretName, o.namespace)
+		} else if err != nil {
+			return nil, apiError(err, "unable to get secret %q", o.secretName)
+		}
+	}
+
+	if secret.Type != o.secretType {
+		return nil, fmt.Errorf("invalid secret type %q, expected %q", secret.Type, o.secretType)
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		return secret, nil
+	}
+	for _, key := range o.keyNames {
+		if _, exists := secret.Data[key]; exists {
+			continue
+		}
+		if !o.initKey {
+			if o.setsPassword() {
+				return nil, notFoundError("secret %q has no key %q, use --init-key to add it", secret.Name, key)
+			}
+			return nil, notFoundError("secret %q has no key %q", secret.Name, key)
+		}
+		o.logf(logDetails, "Adding key %q to secret %q", key, secret.Name)
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+	}
+	return secret, nil
+}
+
+// existingSecret returns the secret create would overwrite, or nil if there
+// is none. Manifests written instead of the cluster never overwrite one.
+func (o *CommandOptions) existingSecret(ctx context.Context) (*v1.Secret, error) {
+	if o.outputManifest != "" {
+		return nil, nil
+	}
+	existing, err := o.secrets().Get(ctx, o.secretName)
 	if apierrors.IsNotFound(err) {
-		fmt.Printf("Secret %q not found\n", o.secretName)
-		os.Exit(1)
-	} else if statusError, isStatus := err.(*apierrors.StatusError); isStatus {
-		fmt.Printf("Error getting secret %v\n", statusError.ErrStatus.Message)
-		os.Exit(1)
+		return nil, nil
 	} else if err != nil {
-		fmt.Printf("Unkown error: %v", err)
-		os.Exit(1)
+		return nil, apiError(err, "unable to get secret %q", o.secretName)
 	}
+	return existing, nil
+}
 
-	if secret.Type != v1.SecretTypeOpaque {
-		return nil, nil, fmt.Errorf("invalid secret type")
+// newSecret returns an empty secret for create. Replacing existing requires
+// --force; the new secret takes over its resourceVersion so saving it
+// updates the existing one.
+func (o *CommandOptions) newSecret(ctx context.Context, existing *v1.Secret) (*v1.Secret, error) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      o.secretName,
+			Namespace: o.namespace,
+		},
+		Type: o.secretType,
+		Data: make(map[string][]byte),
+	}
+	if err := o.setCreateMetadata(ctx, secret); err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return secret, nil
+	}
+	if !o.force {
+		return nil, conflictError("secret %q already exists in namespace %q, use --if-not-exists to add to it or --force to replace it", o.secretName, o.namespace)
+	}
+	if manager := managedBy(existing); manager != "" {
+		fmt.Fprintf(o.ErrOut, "Warning: secret %q is managed by %s\n", existing.Name, manager)
 	}
-	data, exists := secret.Data[o.keyName]
-	if !exists {
-		return nil, nil, fmt.Errorf("Secret with key %q does not exist", o.keyName)
+	if err := o.confirm(existing, fmt.Sprintf("Secret %q already exists in namespace %q, replace it and all its users?", o.secretName, o.namespace)); err != nil {
+		return nil, err
 	}
-	return secret, data, nil
+	secret.ResourceVersion = existing.ResourceVersion
+	return secret, nil
 }
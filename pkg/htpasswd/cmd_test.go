@@ -0,0 +1,269 @@
+package htpasswd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidateKeyNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyNames []string
+		want     string
+		trimmed  []string
+	}{
+		{"default", []string{"auth"}, "", []string{"auth"}},
+		{"several", []string{"auth", "users.htpasswd", "team_a-1"}, "", []string{"auth", "users.htpasswd", "team_a-1"}},
+		{"trimmed", []string{" auth "}, "", []string{"auth"}},
+		{"none", nil, "--key-name must not be empty", nil},
+		{"empty", []string{""}, "--key-name must not be empty", nil},
+		{"blank", []string{"  "}, "--key-name must not be empty", nil},
+		{"slash", []string{"team/auth"}, `invalid key name "team/auth"`, nil},
+		{"space", []string{"my auth"}, `invalid key name "my auth"`, nil},
+		{"too long", []string{strings.Repeat("a", 254)}, "invalid key name", nil},
+		{"duplicate", []string{"auth", " auth"}, `key name "auth" given more than once`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := &CommandOptions{keyNames: test.keyNames}
+			err := o.validateKeyNames()
+			switch {
+			case test.want == "" && err != nil:
+				t.Fatalf("validateKeyNames(%q) = %v", test.keyNames, err)
+			case test.want == "":
+				if strings.Join(o.keyNames, ",") != strings.Join(test.trimmed, ",") {
+					t.Errorf("key names = %q, want %q", o.keyNames, test.trimmed)
+				}
+			case err == nil || !strings.Contains(err.Error(), test.want):
+				t.Errorf("validateKeyNames(%q) = %v, want an error containing %q", test.keyNames, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCompleteNamespace(t *testing.T) {
+	noNamespace := strings.Replace(testKubeconfig, "    namespace: "+testNamespace+"\n", "", 1)
+	noContext := strings.Replace(testKubeconfig, "current-context: test", "current-context: missing", 1)
+	tests := []struct {
+		name         string
+		kubeconfig   string
+		saNamespace  string
+		args         []string
+		want, source string
+	}{
+		{"flag", testKubeconfig, "", []string{"-n", "team-b"}, "team-b", "--namespace flag"},
+		{"context", testKubeconfig, "", nil, testNamespace, `context "test"`},
+		{"flag over context without namespace", noNamespace, "", []string{"--namespace", "team-b"}, "team-b", "--namespace flag"},
+		{"fallback", noNamespace, "", nil, "default", "fallback, the context has no namespace"},
+		{"in-cluster", testKubeconfig, "team-c\n", []string{"--in-cluster"}, "team-c", "service account"},
+		{"flag over in-cluster", testKubeconfig, "team-c\n", []string{"--in-cluster", "-n", "team-b"}, "team-b", "--namespace flag"},
+		{"missing context", noContext, "team-c\n", nil, "team-c", "service account"},
+		{"in-cluster without namespace", testKubeconfig, "\n", []string{"--in-cluster"}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var secrets []runtime.Object
+			for _, ns := range []string{"default", testNamespace, "team-b", "team-c"} {
+				secret := newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+				secret.Namespace = ns
+				secrets = append(secrets, secret)
+			}
+			c := newTestCluster(t, secrets...)
+			defer c.close()
+			c.writeFile("kubeconfig", test.kubeconfig)
+
+			defer func(file string, config func() (*rest.Config, error)) {
+				serviceAccountNamespaceFile, inClusterConfig = file, config
+			}(serviceAccountNamespaceFile, inClusterConfig)
+			serviceAccountNamespaceFile = c.writeFile("namespace", test.saNamespace)
+			inClusterConfig = func() (*rest.Config, error) {
+				if test.saNamespace == "" {
+					return nil, rest.ErrNotInCluster
+				}
+				return &rest.Config{Host: c.server.URL}, nil
+			}
+
+			_, errOut, err := c.run("", append(test.args, "-v", "list", "gateway")...)
+			if test.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "please specify --namespace") {
+					t.Errorf("error = %v, want the namespace asked for", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("list failed: %v\n%s", err, errOut)
+			}
+			if want := fmt.Sprintf("Using namespace %q (%s)", test.want, test.source); !strings.Contains(errOut, want) {
+				t.Errorf("stderr doesn't contain %q:\n%s", want, errOut)
+			}
+		})
+	}
+}
+
+func TestCreateAddVerifyDelete(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.close()
+
+	c.mustRun("secret1\n", "create", "gateway", "alice", "--password-stdin")
+	c.mustRun("secret2\n", "add", "gateway", "bob", "--password-stdin")
+	if users := parseTestUsers(t, c.secret("gateway").Data["auth"]); strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("users after add = %v, want [alice bob]", users)
+	}
+
+	c.mustRun("secret1\n", "verify", "gateway", "alice", "--password-stdin")
+	if _, _, err := c.run("wrong\n", "verify", "gateway", "alice", "--password-stdin"); err == nil {
+		t.Errorf("verify with a wrong password succeeded")
+	}
+
+	c.mustRun("", "delete", "gateway", "alice", "--yes")
+	if users := parseTestUsers(t, c.secret("gateway").Data["auth"]); strings.Join(users, ",") != "bob" {
+		t.Errorf("users after delete = %v, want [bob]", users)
+	}
+	if out := c.mustRun("", "list", "gateway", "-o", "name"); out != "bob\n" {
+		t.Errorf("list = %q, want %q", out, "bob\n")
+	}
+}
+
+func TestCreateExistingSecretFails(t *testing.T) {
+	c := newTestCluster(t, newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	defer c.close()
+
+	_, errOut, err := c.run("secret1\n", "create", "gateway", "bob", "--password-stdin")
+	if err == nil {
+		t.Fatalf("create of an existing secret succeeded")
+	}
+	if !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("error = %v, want an already exists error\n%s", err, errOut)
+	}
+}
+
+func TestDryRunLeavesSecret(t *testing.T) {
+	auth := "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"
+	c := newTestCluster(t, newTestSecret("gateway", auth))
+	defer c.close()
+
+	c.mustRun("secret2\n", "add", "gateway", "bob", "--password-stdin", "--dry-run=server")
+	if got := string(c.secret("gateway").Data["auth"]); got != auth {
+		t.Errorf("auth after dry run = %q, want %q", got, auth)
+	}
+}
+
+func TestFakeClientset(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.close()
+	clientset := fake.NewSimpleClientset(newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+
+	run := func(stdin string, args ...string) string {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		o := &CommandOptions{IOStreams: genericclioptions.IOStreams{In: strings.NewReader(stdin), Out: &out, ErrOut: &errOut}, clientset: clientset}
+		cmd := newCommand(o)
+		cmd.SetArgs(append([]string{"--kubeconfig", c.dir + "/kubeconfig", "--config", c.dir + "/config.yaml"}, args...))
+		cmd.SetOutput(&errOut)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, errOut.String())
+		}
+		return out.String()
+	}
+	run("secret2\n", "add", "gateway", "bob", "--password-stdin")
+	if out := run("", "list", "gateway", "-o", "name"); out != "alice\nbob\n" {
+		t.Errorf("list after add = %q, want alice and bob", out)
+	}
+	run("", "delete", "gateway", "alice", "--yes")
+	secret, err := clientset.CoreV1().Secrets(testNamespace).Get("gateway", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := parseTestUsers(t, secret.Data["auth"]); strings.Join(users, ",") != "bob" {
+		t.Errorf("users after delete = %v, want [bob]", users)
+	}
+}
+
+// parseTestUsers returns the usernames of htpasswd data.
+func parseTestUsers(t *testing.T, data []byte) []string {
+	t.Helper()
+	var users []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		users = append(users, strings.SplitN(line, ":", 2)[0])
+	}
+	return users
+}
+
+func TestMalformedLineIsReportedWithLineNumber(t *testing.T) {
+	c := newTestCluster(t, newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\ngarbage\n"))
+	defer c.close()
+
+	_, _, err := c.run("", "list", "gateway")
+	want := `key "auth": line 2: missing colon between username and hash`
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestToManifestIsWrittenAfterSaving(t *testing.T) {
+	c := newTestCluster(t, newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	defer c.close()
+	manifest := filepath.Join(c.dir, "gateway.yaml")
+
+	if _, _, err := c.run("secret2\n", "create", "gateway", "bob", "--password-stdin", "--to-manifest", manifest); err == nil {
+		t.Fatalf("create of an existing secret succeeded")
+	}
+	if _, err := os.Stat(manifest); !os.IsNotExist(err) {
+		t.Errorf("manifest was written although saving failed: %v", err)
+	}
+
+	c.mustRun("secret2\n", "add", "gateway", "bob", "--password-stdin", "--to-manifest", manifest)
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &v1.Secret{}
+	if err := yaml.Unmarshal(data, secret); err != nil {
+		t.Fatal(err)
+	}
+	if users := parseTestUsers(t, secret.Data["auth"]); strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("users in the manifest = %v, want [alice bob]", users)
+	}
+	if secret.ResourceVersion != "" {
+		t.Errorf("manifest has the resourceVersion %q", secret.ResourceVersion)
+	}
+}
+
+func TestCreateIsNotRetriedAfterLostResponse(t *testing.T) {
+	c := newTestCluster(t)
+	defer c.close()
+	c.dropCreates = 1
+
+	_, errOut, err := c.run("secret1\n", "create", "gateway", "alice", "--password-stdin")
+	if err == nil || strings.Contains(err.Error(), "already exists") {
+		t.Errorf("error = %v, want the lost response reported, not AlreadyExists\n%s", err, errOut)
+	}
+	posts := 0
+	for _, r := range c.requests {
+		if strings.HasPrefix(r, "POST ") {
+			posts++
+		}
+	}
+	if posts != 1 {
+		t.Errorf("sent %d POST requests, want 1: %v", posts, c.requests)
+	}
+	if users := parseTestUsers(t, c.secret("gateway").Data["auth"]); strings.Join(users, ",") != "alice" {
+		t.Errorf("users = %v, want [alice]", users)
+	}
+}
@@ -0,0 +1,170 @@
+package htpasswd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newSubcommand returns a subcommand running through the usual
+// Complete/Validate/Run cycle. setup selects the operation before the
+// arguments are validated.
+func newSubcommand(o *CommandOptions, use, short string, setup func()) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			setup()
+			if err := o.Validate(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.Run(ctx)
+		},
+	}
+}
+
+func newAddCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "add (SECRET | -l SELECTOR) [<username>|-u <username>]", "Add a user or change the password of an existing user", func() {})
+	cmd.Flags().BoolVarP(&o.appendOnly, "append-only", "", false, "Only allow adding new users, never change existing ones")
+	o.addInitKeyFlag(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	o.addUsernameFlag(cmd)
+	o.addPasswordFlags(cmd)
+	o.addGenerateFlags(cmd)
+	o.addPolicyFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addExpiresFlag(cmd)
+	o.addCommentFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addTraefikFlags(cmd)
+	o.addSelectorFlags(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newCreateCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "create SECRET [<username>|-u <username>]", "Create a new secret containing a user", func() {
+		o.createSecret = true
+	})
+	o.addMetadataFlags(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	o.addUsernameFlag(cmd)
+	o.addPasswordFlags(cmd)
+	o.addGenerateFlags(cmd)
+	o.addPolicyFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addExpiresFlag(cmd)
+	o.addCommentFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addTraefikFlags(cmd)
+	o.addYesFlag(cmd)
+	cmd.Flags().BoolVarP(&o.ifNotExists, "if-not-exists", "", false, "Add the user to the secret if it already exists instead of failing")
+	cmd.Flags().Lookup("force").Usage = "Replace the secret and all its users if it already exists, even if it is managed by another controller"
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newDeleteCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "delete (SECRET | -l SELECTOR) [<username>...|-u <username>|--all]", "Delete users", func() {
+		o.deleteUser = true
+	})
+	cmd.Flags().BoolVarP(&o.printOnly, "print-only", "", false, "Only print the entries that would be removed")
+	cmd.Flags().BoolVarP(&o.deleteAll, "all", "", false, "Delete all users")
+	o.addYesFlag(cmd)
+	o.addUsernameFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addSelectorFlags(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newListCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "list (SECRET | -l SELECTOR)", "List the users of a secret", func() {
+		o.listUsers = true
+	})
+	cmd.Flags().StringVarP(&o.sortBy, "sort", "", "name", "Order of listed users. One of: name, algorithm")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: json, yaml, name, wide")
+	o.addSelectorFlags(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newVerifyCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "verify SECRET [<username>|-u <username>]", "Check the password of a user", func() {
+		o.verify = true
+	})
+	o.addUsernameFlag(cmd)
+	o.addPasswordFlags(cmd)
+	o.addInsecureAllowFlag(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newRenameCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "rename SECRET OLDNAME NEWNAME", "Rename a user, keeping its password", func() {
+		o.renaming = true
+	})
+	o.addAllowUnicodeFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func newRehashCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "rehash SECRET [<username>|-u <username>]", "Hash the current password of a user again with a stronger algorithm", func() {
+		o.rehash = true
+	})
+	o.addUsernameFlag(cmd)
+	o.addPasswordFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+func (o *CommandOptions) addUsernameFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.usernameFlag, "username", "u", "", "Username, alternative to the positional argument")
+}
+
+func (o *CommandOptions) addHashFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.hashName, "hash", "", defaultHash, fmt.Sprintf("Hash algorithm for new passwords. One of: %s", strings.Join(hasherNames(), ", ")))
+	cmd.Flags().IntVarP(&o.bcryptCost, "bcrypt-cost", "", bcrypt.DefaultCost, "Work factor of bcrypt hashes")
+	cmd.Flags().IntVarP(&o.argon2Memory, "argon2-memory", "", defaultArgon2Memory, "Memory of argon2id hashes in KiB")
+	cmd.Flags().IntVarP(&o.argon2Iterations, "argon2-iterations", "", defaultArgon2Iterations, "Number of passes of argon2id hashes")
+	cmd.Flags().IntVarP(&o.argon2Parallelism, "argon2-parallelism", "", defaultArgon2Parallelism, "Number of threads of argon2id hashes")
+	o.addInsecureAllowFlag(cmd)
+	cmd.Flags().StringVarP(&o.realm, "realm", "", "", "Realm of new htdigest entries, with --traefik also the realm of the Middleware")
+}
+
+func (o *CommandOptions) addInitKeyFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.initKey, "init-key", "", false, "Add missing keys to the secret, e.g. one pre-created by Helm, instead of failing")
+}
+
+func (o *CommandOptions) addYesFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.yes, "yes", "y", false, "Don't ask for confirmation before deleting users or replacing passwords or secrets")
+}
+
+func (o *CommandOptions) addWriteFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when the secret approaches the size limit")
+	o.addSizeWarnFlag(cmd)
+	o.addUsersKeyFlag(cmd)
+	o.addMirrorFlag(cmd)
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit the secret even if it is managed by another controller")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format for the operation result. One of: json. With --dry-run also yaml, printing the secret")
+	cmd.Flags().StringVarP(&o.manifestPath, "to-manifest", "", "", "Also write the resulting secret as YAML to this file")
+	cmd.Flags().StringVarP(&o.outputManifest, "output-manifest", "", "", "Write the resulting secret as YAML to this file (- for stdout) instead of saving it in the cluster")
+	cmd.Flags().StringVarP(&o.encrypt, "encrypt", "", "", "With --output-manifest, pipe the manifest through kubeseal, sops or the given command line")
+	o.addDryRunFlag(cmd)
+	o.addApplyFlags(cmd)
+}
@@ -0,0 +1,102 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// commentsAnnotation maps usernames to a free text comment, e.g. the person
+// owning the account, as a JSON object.
+const commentsAnnotation = "htpasswd.kubectl.io/comments"
+
+func (o *CommandOptions) addCommentFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.comment, "comment", "", "", `Note about the user shown by list, e.g. "Jane from SRE". An empty value removes it`)
+}
+
+// validateComment checks --comment.
+func (o *CommandOptions) validateComment() error {
+	if !o.commentSet {
+		return nil
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("--comment isn't supported for basic-auth secrets")
+	}
+	if strings.ContainsAny(o.comment, "\r\n") {
+		return fmt.Errorf("--comment must be a single line")
+	}
+	return nil
+}
+
+// readComments returns the comments stored in secret by username.
+func readComments(secret *v1.Secret) (map[string]string, error) {
+	comments := make(map[string]string)
+	data, ok := secret.Annotations[commentsAnnotation]
+	if !ok {
+		return comments, nil
+	}
+	if err := json.Unmarshal([]byte(data), &comments); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", commentsAnnotation, err)
+	}
+	return comments, nil
+}
+
+// writeComments stores comments in secret, removing the annotation when no
+// user has one.
+func writeComments(secret *v1.Secret, comments map[string]string) {
+	if len(comments) == 0 {
+		delete(secret.Annotations, commentsAnnotation)
+		return
+	}
+	data, _ := json.Marshal(comments)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[commentsAnnotation] = string(data)
+}
+
+// updateComments keeps the comment annotation in line with a change of
+// username, like updateExpiries.
+func (o *CommandOptions) updateComments(secret *v1.Secret, username, renameTo string, set bool) error {
+	comments, err := readComments(secret)
+	if err != nil {
+		return err
+	}
+	comment, exists := comments[username]
+	switch {
+	case set && o.commentSet && o.comment == "":
+		delete(comments, username)
+	case set && o.commentSet:
+		comments[username] = o.comment
+	case set, !exists:
+		return nil
+	case renameTo != "":
+		delete(comments, username)
+		comments[renameTo] = comment
+	default:
+		delete(comments, username)
+	}
+	writeComments(secret, comments)
+	return nil
+}
+
+// updateUserMetadata moves or drops the expiry and comment of username, see
+// updateExpiries.
+func (o *CommandOptions) updateUserMetadata(secret *v1.Secret, username, renameTo string, set bool) error {
+	if err := o.updateExpiries(secret, username, renameTo, set); err != nil {
+		return err
+	}
+	return o.updateComments(secret, username, renameTo, set)
+}
+
+// commentNote returns the comment of a user for the plain list output.
+func commentNote(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return " # " + comment
+}
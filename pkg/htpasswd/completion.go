@@ -0,0 +1,192 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// pluginName is the name of the executable the completion is registered
+// for. kubectl itself doesn't complete the arguments of plugins.
+const pluginName = "kubectl-htpasswd"
+
+// bashCompletionFunc completes secret names and the usernames of the secret
+// given as first argument. The names are looked up by the hidden __complete
+// subcommand, forwarding the flags selecting the cluster, namespace and keys.
+const bashCompletionFunc = `__htpasswd_override_flags()
+{
+    local flag
+    for flag in --kubeconfig --context --cluster --namespace -n --key-name --controller --secret-type; do
+        if [[ -n ${flaghash[${flag}]} ]]; then
+            echo "${flag}=${flaghash[${flag}]}"
+        elif [[ -n ${flaghash[${flag}=]} ]]; then
+            echo "${flag}=${flaghash[${flag}=]}"
+        fi
+    done
+}
+
+__htpasswd_get_names()
+{
+    local names
+    if names=$(kubectl-htpasswd __complete $(__htpasswd_override_flags) "$@" 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "${names[*]}" -- "$cur" ) )
+    fi
+}
+
+__custom_func()
+{
+    case ${last_command} in
+        htpasswd_delete | htpasswd_rotate)
+            if [[ ${#nouns[@]} -eq 0 ]]; then
+                __htpasswd_get_names secrets
+            else
+                __htpasswd_get_names users "${nouns[0]}"
+            fi
+            ;;
+        htpasswd | htpasswd_add | htpasswd_verify | htpasswd_rename | htpasswd_rehash)
+            if [[ ${#nouns[@]} -eq 0 ]]; then
+                __htpasswd_get_names secrets
+            elif [[ ${#nouns[@]} -eq 1 ]]; then
+                __htpasswd_get_names users "${nouns[0]}"
+            fi
+            ;;
+        htpasswd_list | htpasswd_check | htpasswd_keys | htpasswd_import | htpasswd_export | htpasswd_prune-expired | htpasswd_copy | htpasswd_edit | htpasswd_edit-raw)
+            if [[ ${#nouns[@]} -eq 0 ]]; then
+                __htpasswd_get_names secrets
+            fi
+            ;;
+    esac
+}`
+
+// zshCompletionHead loads the bash completion into zsh. The functions are
+// defined with sticky sh emulation, so they see bash array semantics.
+const zshCompletionHead = `#compdef kubectl-htpasswd
+
+autoload -U +X bashcompinit && bashcompinit
+emulate sh -c "$(cat <<'EOF'
+`
+
+const zshCompletionTail = `EOF
+)"
+`
+
+// newCompletionCommand returns the completion subcommand printing a shell
+// completion script.
+func newCompletionCommand(root *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion (bash | zsh)",
+		Short: "Print a shell completion script",
+		Long: `Print a shell completion script for kubectl-htpasswd, e.g.
+
+  source <(kubectl-htpasswd completion bash)
+
+Secret names and the usernames of a secret are completed by querying the cluster.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return validationError(fmt.Errorf("shell is required, must be one of: bash, zsh"))
+			}
+			switch args[0] {
+			case "bash", "zsh":
+			default:
+				return validationError(fmt.Errorf("unsupported shell %q, must be one of: bash, zsh", args[0]))
+			}
+			var buf bytes.Buffer
+			if err := root.GenBashCompletion(&buf); err != nil {
+				return err
+			}
+			// Register the completion for the plugin executable instead of
+			// the command name, which would shadow the htpasswd tool.
+			script := strings.Replace(buf.String(), " __start_htpasswd htpasswd\n", " __start_htpasswd "+pluginName+"\n", -1)
+			if args[0] == "zsh" {
+				script = zshCompletionHead + script + zshCompletionTail
+			}
+			_, err := fmt.Fprint(c.OutOrStdout(), script)
+			return err
+		},
+	}
+}
+
+// newCompleteCommand returns the hidden subcommand used by the completion
+// script to look up secret names and usernames.
+func newCompleteCommand(o *CommandOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:    "__complete (secrets | users SECRET)",
+		Hidden: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateKeyNames(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			switch {
+			case len(args) == 1 && args[0] == "secrets":
+				return o.completeSecrets(ctx)
+			case len(args) == 2 && args[0] == "users":
+				o.secretName = args[1]
+				return o.completeUsers(ctx)
+			}
+			return validationError(fmt.Errorf("expected secrets or users SECRET"))
+		},
+	}
+}
+
+// completeSecrets prints the names of the secrets in the namespace which
+// have the selected type and keys.
+func (o *CommandOptions) completeSecrets(ctx context.Context) error {
+	list, err := o.secrets().List(ctx, "")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for i := range list.Items {
+		if o.hasLayout(&list.Items[i]) {
+			names = append(names, list.Items[i].Name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(o.Out, name)
+	}
+	return nil
+}
+
+// completeUsers prints the usernames stored in the selected keys of the
+// secret.
+func (o *CommandOptions) completeUsers(ctx context.Context) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		fmt.Fprintln(o.Out, string(secret.Data[v1.BasicAuthUsernameKey]))
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, key := range o.keyNames {
+		htpasswd, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return err
+		}
+		users, err := htpasswd.ListUsers()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if !seen[u] {
+				seen[u] = true
+				fmt.Fprintln(o.Out, u)
+			}
+		}
+	}
+	return nil
+}
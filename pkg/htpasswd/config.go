@@ -0,0 +1,130 @@
+package htpasswd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// configDefaults are the flag defaults which can be pinned in the
+// configuration file, for all or a single namespace.
+type configDefaults struct {
+	Hash       string `json:"hash,omitempty"`
+	BcryptCost int    `json:"bcryptCost,omitempty"`
+	KeyName    string `json:"keyName,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+	// Confirm false skips the confirmation prompts like --yes.
+	Confirm *bool `json:"confirm,omitempty"`
+}
+
+// configFile is the layout of the configuration file. The settings of the
+// namespace in use override the top-level ones.
+type configFile struct {
+	configDefaults
+	Namespaces map[string]configDefaults `json:"namespaces,omitempty"`
+}
+
+// defaultConfigPath returns ~/.config/kubectl-htpasswd/config.yaml, or its
+// equivalent below $XDG_CONFIG_HOME.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kubectl-htpasswd", "config.yaml")
+}
+
+// loadConfig reads --config, or the default configuration file if it
+// exists.
+func (o *CommandOptions) loadConfig() (*configFile, error) {
+	path := o.configPath
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	config := &configFile{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && o.configPath == "" {
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %q: %v", path, err)
+	}
+	o.logf(logDetails, "Using config %q", path)
+	return config, nil
+}
+
+// applyConfig sets the flags of cmd which weren't given on the command line
+// to the defaults of the configuration file for the namespace.
+func (o *CommandOptions) applyConfig(cmd *cobra.Command) error {
+	config, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	defaults := config.configDefaults
+	if ns, ok := config.Namespaces[o.namespace]; ok {
+		if ns.Hash != "" {
+			defaults.Hash = ns.Hash
+		}
+		if ns.BcryptCost != 0 {
+			defaults.BcryptCost = ns.BcryptCost
+		}
+		if ns.KeyName != "" {
+			defaults.KeyName = ns.KeyName
+		}
+		if ns.Secret != "" {
+			defaults.Secret = ns.Secret
+		}
+		if ns.Confirm != nil {
+			defaults.Confirm = ns.Confirm
+		}
+	}
+
+	flags := map[string]string{"hash": defaults.Hash}
+	if defaults.BcryptCost != 0 {
+		flags["bcrypt-cost"] = strconv.Itoa(defaults.BcryptCost)
+	}
+	// the layout of --controller wins over the configured key
+	if o.controller == "" {
+		flags["key-name"] = defaults.KeyName
+	}
+	if defaults.Confirm != nil && !*defaults.Confirm {
+		flags["yes"] = "true"
+	}
+	for name, value := range flags {
+		flag := cmd.Flags().Lookup(name)
+		if value == "" || flag == nil || flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return validationError(fmt.Errorf("invalid %s %q in config: %v", name, value, err))
+		}
+	}
+
+	if defaults.Secret != "" && len(o.args) == 0 && o.selector == "" && o.needsCluster() && takesSecret(cmd) {
+		o.logf(logDetails, "Using secret %q of the config", defaults.Secret)
+		o.args = []string{defaults.Secret}
+	}
+	return nil
+}
+
+// takesSecret reports whether the first argument of cmd is the secret, so a
+// default secret can stand in for it.
+func takesSecret(cmd *cobra.Command) bool {
+	fields := strings.Fields(cmd.Use)
+	return len(fields) > 1 && (fields[1] == "SECRET" || strings.HasPrefix(fields[1], "(SECRET"))
+}
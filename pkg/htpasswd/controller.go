@@ -0,0 +1,480 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// usersLabel selects the ConfigMaps reconciled by the controller.
+	usersLabel = "htpasswd.kubectl.io/users"
+	// targetSecretAnnotation names the secret of a ConfigMap, it defaults
+	// to the name of the ConfigMap.
+	targetSecretAnnotation = "htpasswd.kubectl.io/secret"
+	// passwordsSecretAnnotation names the secret receiving the generated
+	// passwords, it defaults to the target secret with a -passwords suffix.
+	passwordsSecretAnnotation = "htpasswd.kubectl.io/passwords-secret"
+)
+
+// controllerOptions holds the flags of the controller subcommand.
+type controllerOptions struct {
+	selector      string
+	configMapKey  string
+	resync        time.Duration
+	htpasswdUsers bool
+
+	// mu serializes the reconciliation of the sources, which share the
+	// options.
+	mu sync.Mutex
+}
+
+// newControllerCommand returns the controller subcommand which keeps
+// reconciling secrets with the users declared in ConfigMaps.
+func newControllerCommand(o *CommandOptions) *cobra.Command {
+	var c controllerOptions
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Continuously reconcile secrets with the users declared in ConfigMaps or HtpasswdUser objects",
+		Long: `Continuously reconcile secrets with the users declared in ConfigMaps or HtpasswdUser objects.
+
+Every ConfigMap matching --selector is applied like apply --from-configmap
+whenever it changes and again every --resync period, which also reverts
+manual edits of the secrets. The secret defaults to the name of the ConfigMap
+and can be set with the annotation ` + targetSecretAnnotation + `. Passwords
+generated for new users are stored in the secret SECRET-passwords, or the one
+named by the annotation ` + passwordsSecretAnnotation + `.
+
+HtpasswdUser objects, see crd install, are reconciled as well: all objects
+referring to a secret make up its users. A secret is only ever managed by
+either ConfigMaps or HtpasswdUser objects.
+
+Created secrets are owned by their ConfigMap or HtpasswdUser objects, so
+deleting those deletes the secrets as well. The controller is meant to run in
+a pod, see --in-cluster.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := o.validateController(&c); err != nil {
+				return validationError(err)
+			}
+			cmd.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunController(ctx, &c)
+		},
+	}
+	cmd.Flags().StringVarP(&c.selector, "selector", "l", usersLabel, "Reconcile the ConfigMaps matching this label selector")
+	cmd.Flags().BoolVarP(&o.allNamespaces, "all-namespaces", "A", false, "Watch ConfigMaps in all namespaces")
+	cmd.Flags().StringVarP(&c.configMapKey, "configmap-key", "", "users", "Key of the ConfigMaps holding the users")
+	cmd.Flags().BoolVarP(&c.htpasswdUsers, "htpasswd-users", "", true, "Also reconcile HtpasswdUser objects if the CRD is installed, see crd install")
+	cmd.Flags().DurationVarP(&c.resync, "resync", "", 10*time.Minute, "Reconcile all ConfigMaps again after this period")
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit secrets even if they are managed by another controller")
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when a secret approaches the size limit")
+	o.addSizeWarnFlag(cmd)
+	o.addUsersKeyFlag(cmd)
+	o.addCharsetFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	return cmd
+}
+
+// validateController checks the flags and arguments of the controller
+// subcommand.
+func (o *CommandOptions) validateController(c *controllerOptions) error {
+	if len(o.args) > 0 {
+		return fmt.Errorf("controller takes no arguments")
+	}
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if err := o.validateSizeWarn(); err != nil {
+		return err
+	}
+	if err := o.validateUsersKey(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" || o.outputManifest != "" {
+		return fmt.Errorf("controller doesn't support manifests")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("controller only supports htpasswd data, not basic-auth secrets")
+	}
+	if _, err := labels.Parse(c.selector); err != nil {
+		return fmt.Errorf("invalid selector %q: %v", c.selector, err)
+	}
+	if c.configMapKey == "" {
+		return fmt.Errorf("--configmap-key must not be empty")
+	}
+	if c.resync < time.Minute {
+		return fmt.Errorf("--resync must be at least 1m")
+	}
+	if o.generateLength < 8 {
+		return fmt.Errorf("--length must be at least 8")
+	}
+	hasher, err := newHasher(o.hashName, o.hashOptions())
+	if err != nil {
+		return err
+	}
+	o.hasher = hasher
+	return o.validateTarget()
+}
+
+// RunController reconciles the selected ConfigMaps, and the HtpasswdUser
+// objects if the CRD is installed, until ctx is cancelled. Failures are
+// reported and retried with the next change or resync, they don't stop the
+// controller.
+func (o *CommandOptions) RunController(ctx context.Context, c *controllerOptions) error {
+	client := o.secrets()
+	if o.allNamespaces {
+		client.ns = ""
+	}
+	sources := []controllerSource{o.configMapSource(client, c)}
+	if c.htpasswdUsers {
+		sources = append(sources, o.htpasswdUserSource(client, c))
+	}
+	o.logf(logNormal, "Reconciling configmaps matching %q every %v", c.selector, c.resync)
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Add(1)
+		go func(s controllerSource) {
+			defer wg.Done()
+			o.runSource(ctx, c.resync, s)
+		}(s)
+	}
+	wg.Wait()
+	o.logf(logNormal, "Stopped reconciling")
+	return nil
+}
+
+// controllerSource is a kind of object reconciled by the controller.
+type controllerSource struct {
+	name string
+	// list reconciles all objects and returns the resource version to
+	// watch from.
+	list func(ctx context.Context) (string, error)
+	// watch reconciles the objects changed after resourceVersion until the
+	// watch ends and returns the version to continue from. An empty
+	// version means the objects have to be listed again.
+	watch func(ctx context.Context, resourceVersion string) (string, error)
+}
+
+// runSource reconciles the objects of s until ctx is cancelled. Each resync
+// period starts with a fresh list of all objects, changes in between are
+// picked up by watching them.
+func (o *CommandOptions) runSource(ctx context.Context, resync time.Duration, s controllerSource) {
+	for ctx.Err() == nil {
+		period, cancel := context.WithTimeout(ctx, resync)
+		resourceVersion, err := s.list(period)
+		switch {
+		case ctx.Err() != nil:
+		case apierrors.IsNotFound(err):
+			// e.g. the CRD isn't installed, look again with the next resync
+			o.logf(logDetails, "No %s available: %v", s.name, err)
+			<-period.Done()
+		case err != nil:
+			fmt.Fprintf(o.ErrOut, "Warning: unable to list %s: %v\n", s.name, err)
+			waitFor(period, watchRetryDelay)
+		case resourceVersion == "":
+			// nothing to watch from, wait for the next resync
+			<-period.Done()
+		}
+		for err == nil && resourceVersion != "" && period.Err() == nil {
+			started := time.Now()
+			var watchErr error
+			resourceVersion, watchErr = s.watch(period, resourceVersion)
+			if watchErr != nil && period.Err() == nil {
+				fmt.Fprintf(o.ErrOut, "Warning: unable to watch %s: %v\n", s.name, watchErr)
+			}
+			// don't hammer the API server if watches end right away
+			if time.Since(started) < watchRetryDelay {
+				waitFor(period, watchRetryDelay-time.Since(started))
+			}
+		}
+		cancel()
+	}
+}
+
+// configMapSource lists and watches the ConfigMaps matching the selector.
+func (o *CommandOptions) configMapSource(client *secretsClient, c *controllerOptions) controllerSource {
+	return controllerSource{
+		name: "configmaps",
+		list: func(ctx context.Context) (string, error) {
+			list, err := client.ListConfigMaps(ctx, c.selector)
+			if err != nil {
+				return "", err
+			}
+			sort.Slice(list.Items, func(i, j int) bool {
+				a, b := list.Items[i], list.Items[j]
+				if a.Namespace != b.Namespace {
+					return a.Namespace < b.Namespace
+				}
+				return a.Name < b.Name
+			})
+			for i := range list.Items {
+				o.reconcileConfigMap(ctx, c, &list.Items[i])
+			}
+			return list.ResourceVersion, nil
+		},
+		watch: func(ctx context.Context, resourceVersion string) (string, error) {
+			w, err := client.WatchConfigMaps(ctx, c.selector, resourceVersion)
+			if err != nil {
+				return resourceVersion, err
+			}
+			defer w.Stop()
+			return o.handleConfigMapEvents(ctx, w, c, resourceVersion), nil
+		},
+	}
+}
+
+// handleConfigMapEvents reconciles the added and modified ConfigMaps until
+// the watch ends and returns the resource version to continue from.
+func (o *CommandOptions) handleConfigMapEvents(ctx context.Context, w watch.Interface, c *controllerOptions, resourceVersion string) string {
+	for event := range w.ResultChan() {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			cm, ok := event.Object.(*v1.ConfigMap)
+			if !ok {
+				continue
+			}
+			o.reconcileConfigMap(ctx, c, cm)
+			resourceVersion = cm.ResourceVersion
+		case watch.Deleted:
+			if cm, ok := event.Object.(*v1.ConfigMap); ok {
+				// the owned secrets are removed by the garbage collector
+				o.logf(logDetails, "Configmap %s/%s was deleted", cm.Namespace, cm.Name)
+				resourceVersion = cm.ResourceVersion
+			}
+		case watch.Error:
+			err := apierrors.FromObject(event.Object)
+			if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+				return ""
+			}
+			if ctx.Err() == nil {
+				fmt.Fprintf(o.ErrOut, "Warning: watch of configmaps failed: %v\n", err)
+			}
+			return resourceVersion
+		}
+	}
+	return resourceVersion
+}
+
+// reconcileConfigMap applies the users declared in cm to its secrets.
+// Errors are reported, not returned, so one broken ConfigMap doesn't hold up
+// the others.
+func (o *CommandOptions) reconcileConfigMap(ctx context.Context, c *controllerOptions, cm *v1.ConfigMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := o.applyConfigMap(ctx, c, cm); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(o.ErrOut, "Error: configmap %s/%s: %v\n", cm.Namespace, cm.Name, err)
+	}
+}
+
+func (o *CommandOptions) applyConfigMap(ctx context.Context, c *controllerOptions, cm *v1.ConfigMap) error {
+	specs, err := parseConfigMapSpecs(cm, c.configMapKey)
+	if err != nil {
+		return err
+	}
+	isController := true
+	owners := []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       cm.Name,
+		UID:        cm.UID,
+		Controller: &isController,
+	}}
+	for i, spec := range specs {
+		if spec.Secret == "" {
+			spec.Secret = cm.Annotations[targetSecretAnnotation]
+		}
+		if spec.Secret == "" {
+			if len(specs) > 1 {
+				return fmt.Errorf("document %d names no secret", i+1)
+			}
+			spec.Secret = cm.Name
+		}
+		// owner references don't work across namespaces
+		if spec.Namespace != "" && spec.Namespace != cm.Namespace {
+			return fmt.Errorf("secret %q must be in the namespace of the configmap", spec.Secret)
+		}
+		spec.Namespace, spec.owners = cm.Namespace, owners
+		spec.passwordsSecret = spec.Secret + "-passwords"
+		if len(specs) == 1 && cm.Annotations[passwordsSecretAnnotation] != "" {
+			spec.passwordsSecret = cm.Annotations[passwordsSecretAnnotation]
+		}
+	}
+	return o.reconcileSpecs(ctx, specs)
+}
+
+// reconcileSpecs applies the declared users of the controller and stores
+// the generated passwords in the passwords secret of each spec.
+func (o *CommandOptions) reconcileSpecs(ctx context.Context, specs []*userSpecFile) error {
+	for _, spec := range specs {
+		if err := o.validateUserSpec(spec); err != nil {
+			return fmt.Errorf("secret %q: %v", spec.Secret, err)
+		}
+		for _, u := range spec.Users {
+			if !u.Generate {
+				continue
+			}
+			if errs := validation.IsConfigMapKey(u.Name); len(errs) > 0 {
+				return fmt.Errorf("secret %q: user %q can't be a key of the passwords secret: %s", spec.Secret, u.Name, strings.Join(errs, ", "))
+			}
+		}
+	}
+	if err := o.enforceClusterPolicy(ctx, o.specHashNames(specs)); err != nil {
+		return err
+	}
+
+	keyNames := o.keyNames
+	defer func() { o.keyNames = keyNames }()
+	for _, spec := range specs {
+		o.secretName, o.namespace, o.keyNames = spec.Secret, spec.Namespace, keyNames
+		if spec.key != "" {
+			o.keyNames = []string{spec.key}
+		}
+		var generated map[string]string
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			var err error
+			generated, err = o.applyUserSpec(ctx, spec)
+			return err
+		})
+		if err != nil {
+			return apiError(err, "%s/%s", spec.Namespace, spec.Secret)
+		}
+		if len(generated) == 0 {
+			continue
+		}
+		if err := o.mergePasswordsSecret(ctx, spec.passwordsSecret, spec.owners, generated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergePasswordsSecret adds the generated passwords to the secret name,
+// creating it if needed. Passwords of other users are kept, unlike with
+// --passwords-secret, as the controller only generates them for new users.
+func (o *CommandOptions) mergePasswordsSecret(ctx context.Context, name string, owners []metav1.OwnerReference, passwords map[string]string) error {
+	client := o.secrets()
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		secret, err := client.Get(ctx, name)
+		if apierrors.IsNotFound(err) {
+			secret = &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       o.namespace,
+					OwnerReferences: owners,
+				},
+				Type: v1.SecretTypeOpaque,
+				Data: make(map[string][]byte),
+			}
+		} else if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		for u, p := range passwords {
+			secret.Data[u] = []byte(p)
+		}
+		if secret.ResourceVersion == "" {
+			_, err = client.Create(ctx, secret)
+		} else {
+			_, err = client.Update(ctx, secret)
+		}
+		return err
+	})
+	if err != nil {
+		return apiError(err, "unable to store the generated passwords in secret %q", name)
+	}
+	o.logf(logNormal, "New passwords stored in secret %s/%s", o.namespace, name)
+	return nil
+}
+
+// waitFor waits for d or until ctx is done.
+func waitFor(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// ListConfigMaps returns the config maps matching the label selector.
+func (c *secretsClient) ListConfigMaps(ctx context.Context, selector string) (*v1.ConfigMapList, error) {
+	result := &v1.ConfigMapList{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			list, err := typed.ConfigMaps(c.ns).List(metav1.ListOptions{LabelSelector: selector})
+			if err == nil {
+				result = list
+			}
+			return err
+		}
+		return c.client.Get().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("configmaps").
+			VersionedParams(&metav1.ListOptions{LabelSelector: selector}, scheme.ParameterCodec).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// WatchConfigMaps watches the config maps matching the label selector for
+// changes after resourceVersion.
+func (c *secretsClient) WatchConfigMaps(ctx context.Context, selector, resourceVersion string) (watch.Interface, error) {
+	if c.client == nil {
+		typed, err := c.typedCore(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		return typed.ConfigMaps(c.ns).Watch(metav1.ListOptions{Watch: true, LabelSelector: selector, ResourceVersion: resourceVersion})
+	}
+	return c.client.Get().
+		Context(ctx).
+		Namespace(c.ns).
+		Resource("configmaps").
+		VersionedParams(&metav1.ListOptions{
+			Watch:           true,
+			LabelSelector:   selector,
+			ResourceVersion: resourceVersion,
+		}, scheme.ParameterCodec).
+		Watch()
+}
+
+// ownedBy reports whether the controller of secret is one of owners. Any
+// HtpasswdUser counts, as the controlling one changes when it is deleted.
+func ownedBy(secret *v1.Secret, owners []metav1.OwnerReference) bool {
+	ref := metav1.GetControllerOf(secret)
+	if ref == nil {
+		return false
+	}
+	for _, owner := range owners {
+		if owner.UID == ref.UID || owner.Kind == htpasswdUserKind && owner.Kind == ref.Kind && owner.APIVersion == ref.APIVersion {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// copyOptions holds the flags of the copy subcommand.
+type copyOptions struct {
+	toContext string
+	users     []string
+	overwrite bool
+}
+
+// newCopyCommand returns the copy subcommand which duplicates the htpasswd
+// data of a secret to another namespace or cluster.
+func newCopyCommand(o *CommandOptions) *cobra.Command {
+	var c copyOptions
+	cmd := &cobra.Command{
+		Use:   "copy [NAMESPACE/]SECRET [NAMESPACE/]SECRET2",
+		Short: "Copy the htpasswd data of a secret to another namespace or cluster",
+		Long: `Copy the htpasswd data of a secret to another namespace or cluster.
+
+The selected keys of SECRET are written to SECRET2, which is created unless
+--overwrite is given. With --to-context the target is read from and written
+to the cluster of that kubeconfig context, in its namespace unless SECRET2
+includes one.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := o.validateCopy(&c); err != nil {
+				return validationError(err)
+			}
+			cmd.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunCopy(ctx, o.args[0], o.args[1], &c)
+		},
+	}
+	cmd.Flags().StringVarP(&c.toContext, "to-context", "", "", "Kubeconfig context of the target cluster, defaults to the current one")
+	cmd.Flags().StringSliceVarP(&c.users, "users", "", nil, "Only copy these users")
+	cmd.Flags().BoolVarP(&c.overwrite, "overwrite", "", false, "Replace the keys of an existing target secret")
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Overwrite the target even if it is managed by another controller")
+	o.addDryRunFlag(cmd)
+	return cmd
+}
+
+// validateCopy checks the flags and arguments of the copy subcommand.
+func (o *CommandOptions) validateCopy(c *copyOptions) error {
+	if err := o.validateDryRun(); err != nil {
+		return err
+	}
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("copy doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("copy only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 2 {
+		return fmt.Errorf("a source and a target secret are required")
+	}
+	from, to := o.args[0], o.args[1]
+	if c.toContext == "" && namespacedName(from, o.namespace) == namespacedName(to, o.namespace) {
+		return fmt.Errorf("source and target are the same secret")
+	}
+	return nil
+}
+
+// splitNamespacedName splits [NAMESPACE/]NAME, using namespace if none is
+// given.
+func splitNamespacedName(s, namespace string) (string, string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return namespace, s
+}
+
+func namespacedName(s, namespace string) string {
+	ns, name := splitNamespacedName(s, namespace)
+	return ns + "/" + name
+}
+
+// RunCopy writes the selected keys of the secret from to the secret to,
+// optionally only with some of the users.
+func (o *CommandOptions) RunCopy(ctx context.Context, from, to string, c *copyOptions) error {
+	source := o.secrets()
+	var name string
+	source.ns, name = splitNamespacedName(from, o.namespace)
+	secret, err := source.Get(ctx, name)
+	if apierrors.IsNotFound(err) {
+		return notFoundError("secret %q not found in namespace %q", name, source.ns)
+	} else if err != nil {
+		return apiError(err, "unable to get secret %q", from)
+	}
+	if secret.Type != v1.SecretTypeOpaque {
+		return fmt.Errorf("invalid secret type %q, expected %q", secret.Type, v1.SecretTypeOpaque)
+	}
+
+	data := make(map[string][]byte)
+	copied := make(map[string]bool)
+	for _, key := range o.keyNames {
+		if !looksLikePasswordFile(secret.Data[key]) {
+			return fmt.Errorf("key %q of secret %q does not contain htpasswd data", key, from)
+		}
+		f, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		if len(c.users) > 0 {
+			users, _ := f.ListUsers()
+			for _, u := range users {
+				if !containsUser(c.users, u, o.ignoreCase) {
+					if err := f.DeleteUser(u); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		users, _ := f.ListUsers()
+		for _, u := range users {
+			copied[u] = true
+		}
+		data[key] = f.Bytes()
+	}
+	for _, u := range c.users {
+		if !containsUser(keysOf(copied), u, o.ignoreCase) {
+			return notFoundError("user %q does not exist in secret %q", u, from)
+		}
+	}
+
+	target := o.secrets()
+	if c.toContext != "" {
+		clientset, namespace, err := o.contextClient(c.toContext)
+		if err != nil {
+			return err
+		}
+		target.client, target.ns = clientset.CoreV1().RESTClient(), namespace
+	}
+	target.ns, name = splitNamespacedName(to, target.ns)
+	existing, err := target.Get(ctx, name)
+	exists := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		existing = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: target.ns},
+			Type:       v1.SecretTypeOpaque,
+			Data:       make(map[string][]byte),
+		}
+	case err != nil:
+		return apiError(err, "unable to get target secret %q", to)
+	case !c.overwrite:
+		return conflictError("secret %q already exists in namespace %q, use --overwrite to replace its keys", name, target.ns)
+	case existing.Type != v1.SecretTypeOpaque:
+		return fmt.Errorf("invalid target secret type %q, expected %q", existing.Type, v1.SecretTypeOpaque)
+	}
+	if manager := managedBy(existing); exists && manager != "" {
+		if !o.force {
+			return fmt.Errorf("target secret %q is managed by %s, use --force to overwrite it anyway", name, manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: target secret %q is managed by %s\n", name, manager)
+	}
+	if existing.Data == nil {
+		existing.Data = make(map[string][]byte)
+	}
+	for key, value := range data {
+		existing.Data[key] = value
+	}
+	o.recordChange(existing, "copy", sortedKeys(copied), "")
+	setManagedBy(existing)
+	setLastModified(existing)
+
+	if o.dryRun == dryRunClient {
+		return o.printSecret(cleanManifest(existing))
+	}
+	var result *v1.Secret
+	if exists {
+		result, err = target.Update(ctx, existing)
+	} else {
+		result, err = target.Create(ctx, existing)
+	}
+	if err != nil {
+		return apiError(err, "unable to save target secret %q", to)
+	}
+	o.logf(logNormal, "Copied %d user(s) from %s/%s to %s/%s%s", len(copied), source.ns, secret.Name, target.ns, name, o.dryRunSuffix())
+	if o.dryRun == dryRunServer {
+		return o.printSecret(result)
+	}
+	return nil
+}
+
+// contextClient connects to the cluster of the kubeconfig context name and
+// returns the namespace of the context.
+func (o *CommandOptions) contextClient(name string) (kubernetes.Interface, string, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if o.configFlags.KubeConfig != nil {
+		loadingRules.ExplicitPath = *o.configFlags.KubeConfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: name}
+	if o.configFlags.Timeout != nil {
+		overrides.Timeout = *o.configFlags.Timeout
+	}
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to cluster using context %q: %v", name, err)
+	}
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to determine the namespace of context %q: %v", name, err)
+	}
+	o.wrapTransport(restConfig)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to cluster using context %q: %v", name, err)
+	}
+	return clientset, namespace, nil
+}
+
+// containsUser reports whether usernames contains username.
+func containsUser(usernames []string, username string, ignoreCase bool) bool {
+	for _, u := range usernames {
+		if u == username || ignoreCase && strings.EqualFold(u, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func keysOf(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := keysOf(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,414 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	htpasswdUserGroup    = "htpasswd.kubectl.io"
+	htpasswdUserVersion  = "v1alpha1"
+	htpasswdUserKind     = "HtpasswdUser"
+	htpasswdUserResource = "htpasswdusers"
+
+	crdName = htpasswdUserResource + "." + htpasswdUserGroup
+	crdPath = "/apis/apiextensions.k8s.io/v1/customresourcedefinitions"
+)
+
+// htpasswdUserCRD is the definition of the HtpasswdUser resource.
+const htpasswdUserCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: ` + crdName + `
+spec:
+  group: ` + htpasswdUserGroup + `
+  names:
+    kind: ` + htpasswdUserKind + `
+    listKind: ` + htpasswdUserKind + `List
+    plural: ` + htpasswdUserResource + `
+    singular: htpasswduser
+    shortNames:
+    - htu
+  scope: Namespaced
+  versions:
+  - name: ` + htpasswdUserVersion + `
+    served: true
+    storage: true
+    additionalPrinterColumns:
+    - name: User
+      type: string
+      jsonPath: .spec.username
+    - name: Secret
+      type: string
+      jsonPath: .spec.secretRef.name
+    - name: Expires
+      type: string
+      jsonPath: .spec.expires
+    - name: Age
+      type: date
+      jsonPath: .metadata.creationTimestamp
+    schema:
+      openAPIV3Schema:
+        type: object
+        description: A user of an htpasswd secret, reconciled by kubectl htpasswd controller.
+        required:
+        - spec
+        properties:
+          spec:
+            type: object
+            required:
+            - username
+            - secretRef
+            properties:
+              username:
+                type: string
+                minLength: 1
+              secretRef:
+                type: object
+                description: The secret holding the user, in the namespace of the object.
+                required:
+                - name
+                properties:
+                  name:
+                    type: string
+                    minLength: 1
+                  key:
+                    type: string
+                    description: Key of the htpasswd data, defaults to the first --key-name of the controller. All users of a secret must use the same key.
+              hash:
+                type: string
+                description: The hash of the password, mutually exclusive with generate.
+              generate:
+                type: boolean
+                description: Generate a password once and store it in the secret SECRET-passwords.
+              algorithm:
+                type: string
+                description: Hash algorithm of the generated password, defaults to the --hash of the controller.
+              expires:
+                type: string
+                description: Date like 2006-01-02 or RFC 3339 time after which the user expires.
+              comment:
+                type: string
+`
+
+// htpasswdUser is an HtpasswdUser object.
+type htpasswdUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec htpasswdUserSpec `json:"spec"`
+}
+
+type htpasswdUserSpec struct {
+	Username  string `json:"username"`
+	SecretRef struct {
+		Name string `json:"name"`
+		Key  string `json:"key,omitempty"`
+	} `json:"secretRef"`
+	Hash      string `json:"hash,omitempty"`
+	Generate  bool   `json:"generate,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+type htpasswdUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []htpasswdUser `json:"items"`
+}
+
+// newCRDCommand returns the crd subcommand which prints or registers the
+// HtpasswdUser CRD.
+func newCRDCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "crd",
+		Short: "Print or install the HtpasswdUser CRD consumed by the controller",
+		Long: `Print or install the HtpasswdUser CRD consumed by the controller.
+
+HtpasswdUser objects declare the users of a secret as Kubernetes objects,
+e.g. in a GitOps repository:
+
+  apiVersion: ` + htpasswdUserGroup + `/` + htpasswdUserVersion + `
+  kind: ` + htpasswdUserKind + `
+  metadata:
+    name: gateway-alice
+  spec:
+    username: alice
+    secretRef:
+      name: gateway
+    generate: true
+    algorithm: bcrypt
+    expires: 2026-12-31`,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "print",
+		Short: "Print the CRD manifest, e.g. to add it to a GitOps repository",
+		RunE: func(c *cobra.Command, args []string) error {
+			_, err := io.WriteString(o.Out, htpasswdUserCRD)
+			return err
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install",
+		Short: "Create or update the CRD in the cluster",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if len(o.args) > 0 {
+				return validationError(fmt.Errorf("crd install takes no arguments"))
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunInstallCRD(ctx)
+		},
+	})
+	return cmd
+}
+
+// RunInstallCRD creates the HtpasswdUser CRD or updates it to the current
+// definition.
+func (o *CommandOptions) RunInstallCRD(ctx context.Context) error {
+	body, err := yaml.YAMLToJSON([]byte(htpasswdUserCRD))
+	if err != nil {
+		return err
+	}
+	client := o.secrets()
+	if client.client == nil {
+		return errNoRESTClient
+	}
+	existing := &metav1.PartialObjectMetadata{}
+	err = client.retry(ctx, func(ctx context.Context) error {
+		data, err := client.client.Get().Context(ctx).AbsPath(crdPath, crdName).Do().Raw()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, existing)
+	})
+	verb := "Updated"
+	if apierrors.IsNotFound(err) {
+		verb = "Created"
+		err = client.retryIf(ctx, isRetryableCreate, func(ctx context.Context) error {
+			return client.client.Post().Context(ctx).AbsPath(crdPath).
+				SetHeader("Content-Type", "application/json").Body(body).Do().Error()
+		})
+	} else if err == nil {
+		// keep the resource version to update the existing definition
+		var crd map[string]interface{}
+		if err := json.Unmarshal(body, &crd); err != nil {
+			return err
+		}
+		crd["metadata"].(map[string]interface{})["resourceVersion"] = existing.ResourceVersion
+		body, _ = json.Marshal(crd)
+		err = client.retry(ctx, func(ctx context.Context) error {
+			return client.client.Put().Context(ctx).AbsPath(crdPath, crdName).
+				SetHeader("Content-Type", "application/json").Body(body).Do().Error()
+		})
+	}
+	if err != nil {
+		return apiError(err, "unable to install CRD %q", crdName)
+	}
+	o.logf(logNormal, "%s CRD %s", verb, crdName)
+	return nil
+}
+
+// htpasswdUserSource lists and watches the HtpasswdUser objects.
+func (o *CommandOptions) htpasswdUserSource(client *secretsClient, c *controllerOptions) controllerSource {
+	return controllerSource{
+		name: htpasswdUserResource,
+		list: func(ctx context.Context) (string, error) {
+			list, err := client.ListHtpasswdUsers(ctx)
+			if err != nil {
+				return "", err
+			}
+			o.reconcileHtpasswdUsers(ctx, c, list.Items, nil)
+			return list.ResourceVersion, nil
+		},
+		watch: func(ctx context.Context, resourceVersion string) (string, error) {
+			stream, err := client.WatchHtpasswdUsers(ctx, resourceVersion)
+			if err != nil {
+				return resourceVersion, err
+			}
+			defer stream.Close()
+			return o.handleHtpasswdUserEvents(ctx, client, c, stream, resourceVersion), nil
+		},
+	}
+}
+
+// handleHtpasswdUserEvents reconciles the secret of every changed
+// HtpasswdUser until the watch ends and returns the resource version to
+// continue from. An empty version means the objects have to be listed again.
+func (o *CommandOptions) handleHtpasswdUserEvents(ctx context.Context, client *secretsClient, c *controllerOptions, stream io.Reader, resourceVersion string) string {
+	decoder := json.NewDecoder(stream)
+	for {
+		var event struct {
+			Type   watch.EventType `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return resourceVersion
+		}
+		if event.Type == watch.Error {
+			status := &metav1.Status{}
+			json.Unmarshal(event.Object, status)
+			err := apierrors.FromObject(status)
+			if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+				return ""
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: watch of %s failed: %v\n", htpasswdUserResource, err)
+			return resourceVersion
+		}
+		var user htpasswdUser
+		if err := json.Unmarshal(event.Object, &user); err != nil {
+			continue
+		}
+		resourceVersion = user.ResourceVersion
+		if event.Type != watch.Added && event.Type != watch.Modified && event.Type != watch.Deleted {
+			continue
+		}
+
+		// the users of a secret are declared by all objects referring to it
+		nsClient := *client
+		nsClient.ns = user.Namespace
+		list, err := nsClient.ListHtpasswdUsers(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(o.ErrOut, "Error: unable to list %s in namespace %q: %v\n", htpasswdUserResource, user.Namespace, err)
+			}
+			continue
+		}
+		secret := user.Spec.SecretRef.Name
+		o.reconcileHtpasswdUsers(ctx, c, list.Items, &secret)
+	}
+}
+
+// reconcileHtpasswdUsers applies the users declared by the objects to their
+// secrets, or just to the secret only if given. Errors are reported per
+// secret, not returned, so one broken object doesn't hold up the others.
+func (o *CommandOptions) reconcileHtpasswdUsers(ctx context.Context, c *controllerOptions, users []htpasswdUser, only *string) {
+	type target struct{ namespace, secret string }
+	groups := make(map[target][]htpasswdUser)
+	var targets []target
+	for _, u := range users {
+		t := target{u.Namespace, u.Spec.SecretRef.Name}
+		if only != nil && t.secret != *only {
+			continue
+		}
+		if _, ok := groups[t]; !ok {
+			targets = append(targets, t)
+		}
+		groups[t] = append(groups[t], u)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].namespace != targets[j].namespace {
+			return targets[i].namespace < targets[j].namespace
+		}
+		return targets[i].secret < targets[j].secret
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range targets {
+		spec, err := o.htpasswdUserSpec(t.namespace, t.secret, groups[t])
+		if err == nil {
+			err = o.reconcileSpecs(ctx, []*userSpecFile{spec})
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(o.ErrOut, "Error: %s of secret %s/%s: %v\n", htpasswdUserResource, t.namespace, t.secret, err)
+		}
+	}
+}
+
+// htpasswdUserSpec turns the objects referring to a secret into its
+// declared users. All objects own the secret, the first one by name is its
+// controller.
+func (o *CommandOptions) htpasswdUserSpec(namespace, secret string, users []htpasswdUser) (*userSpecFile, error) {
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	spec := &userSpecFile{
+		Secret:          secret,
+		Namespace:       namespace,
+		passwordsSecret: secret + "-passwords",
+	}
+	for i, u := range users {
+		key := u.Spec.SecretRef.Key
+		if key == "" {
+			key = o.keyNames[0]
+		}
+		if i == 0 {
+			spec.key = key
+		} else if key != spec.key {
+			return nil, fmt.Errorf("%s %q uses key %q, but %q uses %q", htpasswdUserKind, u.Name, key, users[0].Name, spec.key)
+		}
+		spec.Users = append(spec.Users, userSpec{
+			Name:      u.Spec.Username,
+			Hash:      u.Spec.Hash,
+			Generate:  u.Spec.Generate,
+			Algorithm: u.Spec.Algorithm,
+			Expires:   u.Spec.Expires,
+			Comment:   u.Spec.Comment,
+		})
+		isController := i == 0
+		spec.owners = append(spec.owners, metav1.OwnerReference{
+			APIVersion: htpasswdUserGroup + "/" + htpasswdUserVersion,
+			Kind:       htpasswdUserKind,
+			Name:       u.Name,
+			UID:        u.UID,
+			Controller: &isController,
+		})
+	}
+	return spec, nil
+}
+
+// ListHtpasswdUsers returns the HtpasswdUser objects.
+func (c *secretsClient) ListHtpasswdUsers(ctx context.Context) (*htpasswdUserList, error) {
+	if c.client == nil {
+		return nil, errNoRESTClient
+	}
+	result := &htpasswdUserList{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		data, err := c.client.Get().
+			Context(ctx).
+			AbsPath("/apis", htpasswdUserGroup, htpasswdUserVersion).
+			Namespace(c.ns).
+			Resource(htpasswdUserResource).
+			Do().
+			Raw()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, result)
+	})
+	return result, err
+}
+
+// WatchHtpasswdUsers watches the HtpasswdUser objects for changes after
+// resourceVersion and returns the stream of JSON encoded events.
+func (c *secretsClient) WatchHtpasswdUsers(ctx context.Context, resourceVersion string) (io.ReadCloser, error) {
+	if c.client == nil {
+		return nil, errNoRESTClient
+	}
+	return c.client.Get().
+		Context(ctx).
+		AbsPath("/apis", htpasswdUserGroup, htpasswdUserVersion).
+		Namespace(c.ns).
+		Resource(htpasswdUserResource).
+		VersionedParams(&metav1.ListOptions{
+			Watch:           true,
+			ResourceVersion: resourceVersion,
+		}, scheme.ParameterCodec).
+		Stream()
+}
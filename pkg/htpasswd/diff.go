@@ -0,0 +1,130 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// newDiffCommand returns the diff subcommand which shows how the users of a
+// secret differ from a local htpasswd file or another secret.
+func newDiffCommand(o *CommandOptions) *cobra.Command {
+	var showHashes bool
+	cmd := &cobra.Command{
+		Use:   "diff SECRET (FILE | [NAMESPACE/]SECRET2)",
+		Short: "Show the users which differ between a secret and a file or another secret",
+		Long: `Show the users which differ between a secret and a file or another secret.
+
+Users only present in the other side are prefixed with "+", users only present
+in SECRET with "-" and users whose hash differs with "~", which is what an
+import or sync of the other side would change. The second argument is read as
+a file if it exists locally or is "-" for stdin, otherwise as a secret.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateDiff(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunDiff(ctx, o.args[1], showHashes)
+		},
+	}
+	cmd.Flags().BoolVarP(&showHashes, "show-hashes", "", false, "Print the hashes of differing users")
+	return cmd
+}
+
+// validateDiff checks the arguments of the diff subcommand.
+func (o *CommandOptions) validateDiff() error {
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("diff doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("diff only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 2 {
+		return fmt.Errorf("a secret and a file or second secret are required")
+	}
+	o.secretName = o.args[0]
+	return nil
+}
+
+// RunDiff prints the differences of the secret to other per key. Like
+// check, it fails if any difference was found.
+func (o *CommandOptions) RunDiff(ctx context.Context, other string, showHashes bool) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	// the second argument is a file if it exists locally
+	fromFile, fromSecret := "", other
+	if _, err := os.Stat(other); other == "-" || err == nil {
+		fromFile, fromSecret = other, ""
+	}
+	theirs, err := o.loadSources(ctx, fromFile, fromSecret)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, key := range o.keyNames {
+		ours, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		d := diffUsers(theirs[key], ours, true)
+		if d.empty() {
+			continue
+		}
+		if len(o.keyNames) > 1 {
+			fmt.Fprintf(o.Out, "key %q:\n", key)
+		}
+		var lines []string
+		for _, u := range d.added {
+			lines = append(lines, diffLine("+", u, "", theirs[key].passwords[u], showHashes))
+		}
+		for _, u := range d.removed {
+			lines = append(lines, diffLine("-", u, ours.passwords[u], "", showHashes))
+		}
+		for _, u := range d.updated {
+			existing, _ := ours.lookup(u)
+			lines = append(lines, diffLine("~", u, ours.passwords[existing], theirs[key].passwords[u], showHashes))
+		}
+		// sort by username, not by the kind of change
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+		for _, l := range lines {
+			fmt.Fprintln(o.Out, l)
+		}
+		total += len(d.added) + len(d.updated) + len(d.removed)
+	}
+	if total > 0 {
+		return fmt.Errorf("found %d differing user(s) between secret %q and %q", total, o.secretName, other)
+	}
+	o.logf(logNormal, "No differences between secret %q and %q", o.secretName, other)
+	return nil
+}
+
+// diffLine formats a single difference, optionally with the hashes.
+func diffLine(prefix, username, ours, theirs string, showHashes bool) string {
+	l := prefix + " " + username
+	if !showHashes {
+		return l
+	}
+	switch prefix {
+	case "+":
+		return l + " " + theirs
+	case "-":
+		return l + " " + ours
+	}
+	return l + " " + ours + " -> " + theirs
+}
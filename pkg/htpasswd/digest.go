@@ -0,0 +1,102 @@
+package htpasswd
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Formats of the password data selected by --format.
+const (
+	formatHTPasswd = "htpasswd"
+	formatHTDigest = "htdigest"
+)
+
+// userHasher is implemented by hashers whose result depends on the
+// username, like the digests of htdigest files.
+type userHasher interface {
+	HashUser(username, password string) (string, error)
+}
+
+// digestHasher creates the realm:hash part of htdigest entries, the hex MD5
+// of user:realm:password as generated by Apache htdigest.
+type digestHasher struct {
+	realm string
+}
+
+func (h digestHasher) Hash(password string) (string, error) {
+	return "", fmt.Errorf("htdigest hashes depend on the username")
+}
+
+func (h digestHasher) HashUser(username, password string) (string, error) {
+	if h.realm == "" {
+		return "", fmt.Errorf("--realm is required for htdigest entries")
+	}
+	return h.realm + ":" + digest(username, h.realm, password), nil
+}
+
+func digest(username, realm, password string) string {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// isDigest reports whether hash is the realm:hash part of an htdigest entry.
+func isDigest(hash string) bool {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 2 || parts[0] == "" || len(parts[1]) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(parts[1])
+	return err == nil
+}
+
+// verifyDigest checks password against the htdigest entry of username.
+func verifyDigest(username, hash, password string) bool {
+	parts := strings.SplitN(hash, ":", 2)
+	expected := digest(username, parts[0], password)
+	return subtle.ConstantTimeCompare([]byte(parts[1]), []byte(expected)) == 1
+}
+
+// validateFormat checks --format and --realm and replaces the hasher for
+// htdigest data.
+func (o *CommandOptions) validateFormat() error {
+	switch o.format {
+	case formatHTPasswd:
+		if o.realm != "" && !o.traefik {
+			return fmt.Errorf("--realm requires --format %s or --traefik", formatHTDigest)
+		}
+		return nil
+	case formatHTDigest:
+	default:
+		return fmt.Errorf("unsupported format %q, must be one of: %s, %s", o.format, formatHTPasswd, formatHTDigest)
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("--format %s can't be used with basic-auth secrets", formatHTDigest)
+	}
+	if o.hashName != defaultHash {
+		return fmt.Errorf("--hash can't be combined with --format %s, which always uses MD5 digests", formatHTDigest)
+	}
+	if o.setsPassword() && o.realm == "" {
+		return fmt.Errorf("--realm is required for --format %s", formatHTDigest)
+	}
+	o.hasher = digestHasher{realm: o.realm}
+	return nil
+}
+
+// setsPassword reports whether the selected operation hashes a password.
+func (o *CommandOptions) setsPassword() bool {
+	return !o.listUsers && !o.deleteUser && !o.verify && !o.renaming && o.moveKey == "" && o.importFile == "" && !o.pruneExpired
+}
+
+// newHashName names the algorithm of new passwords in messages and the
+// history.
+func (o *CommandOptions) newHashName() string {
+	if o.format == formatHTDigest {
+		return "digest"
+	}
+	return o.hashName
+}
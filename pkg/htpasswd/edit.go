@@ -0,0 +1,418 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const editHelp = `Commands:
+  list [PREFIX]          list the users, marking unsaved changes
+  add USER               add a user, asking for the password
+  passwd USER            set a new password, asking for it
+  generate USER          set a random password and show it once
+  delete USER...         delete users
+  rename OLD NEW         rename a user, keeping the password
+  save                   save all changes in one update and exit
+  quit                   exit, quit! discards unsaved changes
+  help                   show this help
+Usernames are completed with Tab.
+`
+
+// newEditCommand returns the edit subcommand, an interactive editor for the
+// users of a secret.
+func newEditCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit SECRET",
+		Short: "Browse and edit the users of a secret interactively",
+		Long: `Browse and edit the users of a secret interactively.
+
+All changes are kept in memory until "save" writes them with a single update of
+the secret. If the secret was changed in the meantime the update fails and
+nothing is written.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateEdit(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunEdit(ctx)
+		},
+	}
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit the secret even if it is managed by another controller")
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when the secret approaches the size limit")
+	o.addCharsetFlags(cmd)
+	o.addPolicyFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	return cmd
+}
+
+// validateEdit checks the flags and arguments of the edit subcommand.
+func (o *CommandOptions) validateEdit() error {
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if len(o.keyNames) != 1 {
+		return fmt.Errorf("edit works on a single key, got %d", len(o.keyNames))
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("edit doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("edit only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 1 {
+		return fmt.Errorf("secret is required")
+	}
+	o.secretName = o.args[0]
+	if f, ok := o.In.(*os.File); !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return fmt.Errorf("edit requires a terminal")
+	}
+	if err := o.validatePolicy(); err != nil {
+		return err
+	}
+	hasher, err := newHasher(o.hashName, o.hashOptions())
+	if err != nil {
+		return err
+	}
+	o.hasher = hasher
+	if err := o.validateFormat(); err != nil {
+		return err
+	}
+	return o.validateTarget()
+}
+
+// editor holds the state of an edit session.
+type editor struct {
+	o       *CommandOptions
+	term    *terminal.Terminal
+	file    *keyFile
+	changes map[string]string
+	hashed  bool
+	// moves are the deleted and renamed users in order, their expiry and
+	// comment annotations are updated on save
+	moves []userMove
+}
+
+// userMove is a user renamed to to, or deleted if to is empty.
+type userMove struct {
+	from, to string
+}
+
+// RunEdit runs the interactive editor on the terminal until the changes are
+// saved or discarded.
+func (o *CommandOptions) RunEdit(ctx context.Context) error {
+	if err := o.enforceClusterPolicy(ctx, []string{o.newHashName()}); err != nil {
+		return err
+	}
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := o.loadPasswordFiles(secret)
+	if err != nil {
+		return err
+	}
+	if manager := managedBy(secret); manager != "" {
+		if !o.force {
+			return fmt.Errorf("secret %q is managed by %s and changes may be reverted, use --force to edit it anyway", secret.Name, manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %q is managed by %s\n", secret.Name, manager)
+	}
+
+	fd := int(o.In.(*os.File).Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("unable to set up the terminal: %v", err)
+	}
+	defer terminal.Restore(fd, state)
+
+	e := &editor{
+		o: o,
+		term: terminal.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{o.In, o.ErrOut}, fmt.Sprintf("%s> ", secret.Name)),
+		file:    files[0],
+		changes: make(map[string]string),
+	}
+	e.term.AutoCompleteCallback = e.complete
+	fmt.Fprintf(e.term, "Editing key %q of secret %q, type help for the commands.\n", e.file.key, secret.Name)
+	e.list("")
+
+	for {
+		line, err := e.term.ReadLine()
+		if err == io.EOF {
+			line = "quit"
+		} else if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "save":
+			if len(e.changes) == 0 {
+				fmt.Fprintln(e.term, "No changes to save.")
+				return nil
+			}
+			err := e.save(ctx, secret)
+			if err == nil {
+				return nil
+			}
+			fmt.Fprintf(e.term, "Error: %v\n", err)
+		case "quit", "exit":
+			if len(e.changes) == 0 {
+				return nil
+			}
+			fmt.Fprintf(e.term, "%d unsaved change(s), use save or quit! to discard them.\n", len(e.changes))
+		case "quit!":
+			return nil
+		default:
+			if err := e.run(args); err != nil {
+				fmt.Fprintf(e.term, "Error: %v\n", err)
+			}
+		}
+	}
+}
+
+// run executes a single editing command.
+func (e *editor) run(args []string) error {
+	cmd, args := args[0], args[1:]
+	expect := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d argument(s), see help", cmd, n)
+		}
+		return nil
+	}
+	f := e.file
+	switch cmd {
+	case "help", "?":
+		fmt.Fprint(e.term, editHelp)
+	case "list", "ls":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		e.list(prefix)
+	case "add", "passwd":
+		if err := expect(1); err != nil {
+			return err
+		}
+		if cmd == "add" {
+			name, err := e.checkUsername(args[0])
+			if err != nil {
+				return err
+			}
+			args[0] = name
+		}
+		_, exists := f.lookup(args[0])
+		if cmd == "add" && exists {
+			return fmt.Errorf("user %q already exists, use passwd", args[0])
+		} else if cmd == "passwd" && !exists {
+			return notFoundError("user %q does not exist, use add", args[0])
+		}
+		password, err := e.readPassword(args[0])
+		if err != nil {
+			return err
+		}
+		return e.setPassword(args[0], password, exists)
+	case "generate":
+		if err := expect(1); err != nil {
+			return err
+		}
+		if _, exists := f.lookup(args[0]); !exists {
+			name, err := e.checkUsername(args[0])
+			if err != nil {
+				return err
+			}
+			args[0] = name
+		}
+		_, exists := f.lookup(args[0])
+		password, err := generatePassword(e.o.generateLength, e.o.charsetChars())
+		if err != nil {
+			return err
+		}
+		if err := e.setPassword(args[0], password, exists); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.term, "Password of %q: %s\n", args[0], password)
+	case "delete", "rm":
+		if len(args) == 0 {
+			return fmt.Errorf("delete takes at least one username")
+		}
+		for _, username := range args {
+			existing, ok := f.lookup(username)
+			if !ok {
+				return notFoundError("user %q does not exist", username)
+			}
+			if err := f.DeleteUser(existing); err != nil {
+				return err
+			}
+			e.changes[existing] = "deleted"
+			e.moves = append(e.moves, userMove{from: existing})
+		}
+	case "rename", "mv":
+		if err := expect(2); err != nil {
+			return err
+		}
+		name, err := e.checkUsername(args[1])
+		if err != nil {
+			return err
+		}
+		args[1] = name
+		existing, ok := f.lookup(args[0])
+		if !ok {
+			return notFoundError("user %q does not exist", args[0])
+		}
+		if err := f.RenameUser(existing, args[1]); err != nil {
+			return err
+		}
+		e.changes[existing] = "renamed to " + args[1]
+		e.changes[args[1]] = "renamed from " + existing
+		e.moves = append(e.moves, userMove{from: existing, to: args[1]})
+	default:
+		return fmt.Errorf("unknown command %q, see help", cmd)
+	}
+	return nil
+}
+
+// checkUsername validates a new username, printing the warnings.
+func (e *editor) checkUsername(username string) (string, error) {
+	name, warnings, err := checkUsername(username, e.o.allowUnicode)
+	if err != nil {
+		return "", err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(e.term, "Warning: %s\n", w)
+	}
+	return name, nil
+}
+
+// readPassword asks twice for the new password of username.
+func (e *editor) readPassword(username string) (string, error) {
+	password, err := e.term.ReadPassword(fmt.Sprintf("Password for %q: ", username))
+	if err != nil {
+		return "", err
+	}
+	repeated, err := e.term.ReadPassword(fmt.Sprintf("Repeat password for %q: ", username))
+	if err != nil {
+		return "", err
+	}
+	if password != repeated {
+		return "", fmt.Errorf("passwords don't match")
+	}
+	if password == "" {
+		return "", fmt.Errorf("password must not be empty")
+	}
+	if err := e.o.checkPolicy(password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+func (e *editor) setPassword(username, password string, exists bool) error {
+	if err := e.file.SetPassword(username, password); err != nil {
+		return err
+	}
+	e.hashed = true
+	switch change := e.changes[username]; {
+	case !exists:
+		e.changes[username] = "added"
+	case change == "":
+		e.changes[username] = "new password"
+	case change != "added" && !strings.HasSuffix(change, "new password"):
+		e.changes[username] = change + ", new password"
+	}
+	return nil
+}
+
+// list prints the users starting with prefix and their pending changes.
+func (e *editor) list(prefix string) {
+	users, _ := e.file.ListUsers()
+	w := tabwriter.NewWriter(e.term, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tALGORITHM\tCHANGE")
+	for _, u := range users {
+		if strings.HasPrefix(u, prefix) {
+			details, _ := hashStrength(e.file.passwords[u])
+			fmt.Fprintf(w, "%s\t%s\t%s\n", u, details, e.changes[u])
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(e.term, "%d user(s)\n", len(users))
+}
+
+// complete completes the username under the cursor on Tab.
+func (e *editor) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndex(line[:pos], " ") + 1
+	prefix := line[start:pos]
+	users, _ := e.file.ListUsers()
+	var matches []string
+	for _, u := range users {
+		if strings.HasPrefix(u, prefix) {
+			matches = append(matches, u)
+		}
+	}
+	if len(matches) != 1 || start == 0 {
+		return "", 0, false
+	}
+	completed := line[:start] + matches[0] + " "
+	return completed + line[pos:], len(completed), true
+}
+
+// save writes all changes with a single update of the secret.
+func (e *editor) save(ctx context.Context, secret *v1.Secret) error {
+	o := e.o
+	// keep the original for another attempt if saving fails
+	secret = secret.DeepCopy()
+	secret.Data[e.file.key] = e.file.Bytes()
+	for _, m := range e.moves {
+		if err := o.updateUserMetadata(secret, m.from, m.to, false); err != nil {
+			return err
+		}
+	}
+	if err := o.checkSize(secret, []*keyFile{e.file}); err != nil {
+		return err
+	}
+	var users []string
+	for u := range e.changes {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	algorithm := ""
+	if e.hashed {
+		algorithm = o.newHashName()
+	}
+	o.recordChange(secret, "edit", users, algorithm)
+	err := o.saveSecret(ctx, secret)
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("secret %q was changed in the meantime, quit! and edit it again: %v", secret.Name, err)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.term, "Saved %d change(s) to secret %q\n", len(e.changes), secret.Name)
+	return nil
+}
@@ -0,0 +1,68 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestEditMovesUserMetadata(t *testing.T) {
+	secret := newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\ncarol:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	secret.Annotations = map[string]string{
+		expiresAnnotation:  `{"alice":"2030-01-01T00:00:00Z","bob":"2031-01-01T00:00:00Z"}`,
+		commentsAnnotation: `{"alice":"ops","bob":"contractor","carol":"dev"}`,
+	}
+	c := newTestCluster(t, secret)
+	defer c.close()
+
+	// edit stops without a terminal, but only after completing the options
+	var out bytes.Buffer
+	o := c.options(genericclioptions.IOStreams{In: strings.NewReader(""), Out: &out, ErrOut: &out})
+	cmd := newCommand(o)
+	cmd.SetArgs([]string{"--kubeconfig", filepath.Join(c.dir, "kubeconfig"), "--config", filepath.Join(c.dir, "config.yaml"), "edit", "gateway"})
+	cmd.SetOutput(&out)
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "requires a terminal") {
+		t.Fatalf("edit without a terminal = %v, want a terminal required", err)
+	}
+	ctx := context.Background()
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := o.loadPasswordFiles(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &editor{o: o, term: terminal.NewTerminal(&out, "> "), file: files[0], changes: make(map[string]string)}
+	for _, args := range [][]string{{"delete", "alice"}, {"rename", "bob", "dave"}, {"rename", "carol", "bob"}} {
+		if err := e.run(args); err != nil {
+			t.Fatalf("%s: %v", strings.Join(args, " "), err)
+		}
+	}
+	if err := e.save(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := c.secret("gateway")
+	expiries, err := readExpiries(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expiries) != 1 || expiries["dave"].Year() != 2031 {
+		t.Errorf("expiries = %v, want only dave expiring in 2031", expiries)
+	}
+	comments, err := readComments(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"dave": "contractor", "bob": "dev"}; !reflect.DeepEqual(comments, want) {
+		t.Errorf("comments = %v, want %v", comments, want)
+	}
+}
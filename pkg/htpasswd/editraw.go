@@ -0,0 +1,192 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultEditor is used if neither KUBE_EDITOR nor EDITOR is set.
+const defaultEditor = "vi"
+
+// newEditRawCommand returns the edit-raw subcommand which opens the
+// htpasswd data of a secret in an editor, like kubectl edit.
+func newEditRawCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit-raw SECRET",
+		Short: "Edit the htpasswd data of a secret in $EDITOR",
+		Long: `Edit the htpasswd data of a secret in $EDITOR.
+
+The editor is taken from KUBE_EDITOR or EDITOR and defaults to vi. The data is
+checked after the editor exits; if it can't be parsed, the error is shown and
+the file can be edited again. Saving the file unchanged cancels the edit.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateEditRaw(); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunEditRaw(ctx)
+		},
+	}
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit the secret even if it is managed by another controller")
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when the secret approaches the size limit")
+	return cmd
+}
+
+// validateEditRaw checks the flags and arguments of the edit-raw subcommand.
+func (o *CommandOptions) validateEditRaw() error {
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if len(o.keyNames) != 1 {
+		return fmt.Errorf("edit-raw works on a single key, got %d", len(o.keyNames))
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("edit-raw doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("edit-raw only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 1 {
+		return fmt.Errorf("secret is required")
+	}
+	o.secretName = o.args[0]
+	return nil
+}
+
+// RunEditRaw lets the user edit the data of the key and saves the result.
+// If saving fails the edited data is kept in a temporary file.
+func (o *CommandOptions) RunEditRaw(ctx context.Context) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	key := o.keyNames[0]
+	original := secret.Data[key]
+	if !looksLikePasswordFile(original) {
+		return fmt.Errorf("key %q does not contain htpasswd data", key)
+	}
+	if manager := managedBy(secret); manager != "" {
+		if !o.force {
+			return fmt.Errorf("secret %q is managed by %s and changes may be reverted, use --force to edit it anyway", secret.Name, manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %q is managed by %s\n", secret.Name, manager)
+	}
+	old, err := newPasswordFile(original, o.hasher, o.ignoreCase)
+	if err != nil {
+		return fmt.Errorf("key %q: %v", key, err)
+	}
+
+	tmp, err := ioutil.TempFile("", "htpasswd-"+secret.Name+"-*.txt")
+	if err != nil {
+		return err
+	}
+	path := tmp.Name()
+	_, err = tmp.Write(original)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(path)
+		}
+	}()
+
+	var edited *passwordFile
+	var data []byte
+	for {
+		if err := o.runEditor(ctx, path); err != nil {
+			return err
+		}
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, original) {
+			o.logf(logNormal, "Edit cancelled, no changes made.")
+			return nil
+		}
+		edited, err = newPasswordFile(data, o.hasher, o.ignoreCase)
+		if err == nil && !looksLikePasswordFile(data) {
+			err = fmt.Errorf("not htpasswd data, every line needs a colon")
+		}
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(o.ErrOut, "Error: %v\n", err)
+		again, perr := promptConfirm(o, "Edit the file again?")
+		if perr != nil || !again {
+			keep = true
+			return fmt.Errorf("edit cancelled, your changes are kept in %s", path)
+		}
+	}
+	for _, w := range edited.warnings {
+		fmt.Fprintf(o.ErrOut, "Warning: %s\n", w)
+	}
+
+	d := diffUsers(edited, old, true)
+	var users []string
+	users = append(users, d.added...)
+	users = append(users, d.updated...)
+	users = append(users, d.removed...)
+	sort.Strings(users)
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[key] = data
+	if err := o.checkSize(secret, []*keyFile{{passwordFile: edited, key: key}}); err != nil {
+		keep = true
+		return fmt.Errorf("%v, your changes are kept in %s", err, path)
+	}
+	o.recordChange(secret, "edit-raw", users, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		keep = true
+		return apiError(err, "unable to save secret %q, your changes are kept in %s", secret.Name, path)
+	}
+	if d.empty() {
+		o.logf(logNormal, "Saved key %q of secret %q, no users changed", key, secret.Name)
+	} else {
+		o.logf(logNormal, "Saved key %q of secret %q: %s", key, secret.Name, d)
+	}
+	return nil
+}
+
+// runEditor opens path in the editor of the user, connected to the
+// terminal.
+func (o *CommandOptions) runEditor(ctx context.Context, path string) error {
+	editor := os.Getenv("KUBE_EDITOR")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{defaultEditor}
+	}
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = o.ErrOut
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %v", args[0], err)
+	}
+	return nil
+}
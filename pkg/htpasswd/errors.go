@@ -0,0 +1,118 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Exit codes returned by ExitCode. Scripts can rely on them to tell the
+// common failures apart.
+const (
+	ExitOK         = 0
+	ExitError      = 1
+	ExitNotFound   = 2
+	ExitValidation = 3
+	ExitConflict   = 4
+	ExitPermission = 5
+)
+
+// exitReasons names the exit codes in JSON errors.
+var exitReasons = map[int]string{
+	ExitError:      "Error",
+	ExitNotFound:   "NotFound",
+	ExitValidation: "Invalid",
+	ExitConflict:   "Conflict",
+	ExitPermission: "Forbidden",
+}
+
+// Values of --error-format.
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// exitError attaches an exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// validationError marks err as caused by invalid flags or arguments.
+func validationError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: ExitValidation, err: err}
+}
+
+// notFoundError reports a missing secret, user or key.
+func notFoundError(format string, args ...interface{}) error {
+	return &exitError{code: ExitNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// conflictError reports a change that clashes with existing data.
+func conflictError(format string, args ...interface{}) error {
+	return &exitError{code: ExitConflict, err: fmt.Errorf(format, args...)}
+}
+
+// apiError adds context to an error of the API server, keeping its exit
+// code.
+func apiError(err error, format string, args ...interface{}) error {
+	return &exitError{code: ExitCode(err), err: fmt.Errorf(format+": %v", append(args, err)...)}
+}
+
+// ExitCode returns the process exit code for an error returned by the
+// command.
+func ExitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return ExitOK
+	case *exitError:
+		return e.code
+	}
+	switch {
+	case apierrors.IsNotFound(err):
+		return ExitNotFound
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return ExitConflict
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return ExitValidation
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return ExitPermission
+	}
+	return ExitError
+}
+
+// jsonError is the --error-format=json representation of an error.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// PrintError writes err returned by cmd to w in the format selected with
+// --error-format.
+func PrintError(cmd *cobra.Command, w io.Writer, err error) {
+	format := errorFormatText
+	if f := cmd.PersistentFlags().Lookup("error-format"); f != nil {
+		format = f.Value.String()
+	}
+	if format != errorFormatJSON {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+	code := ExitCode(err)
+	data, _ := json.Marshal(struct {
+		Error jsonError `json:"error"`
+	}{jsonError{Code: code, Reason: exitReasons[code], Message: err.Error()}})
+	fmt.Fprintf(w, "%s\n", data)
+}
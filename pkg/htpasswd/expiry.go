@@ -0,0 +1,212 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// expiresAnnotation maps usernames to the RFC 3339 time their account
+// expires, as a JSON object.
+const expiresAnnotation = "htpasswd.kubectl.io/expires"
+
+// expiresNever removes the expiry of a user.
+const expiresNever = "never"
+
+func (o *CommandOptions) addExpiresFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.expires, "expires", "", "", `Expire the user after a date (2006-01-02, valid through that day in UTC), an RFC 3339 time or a duration like 720h or 30d. "never" removes the expiry`)
+}
+
+func newPruneExpiredCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "prune-expired (SECRET | -l SELECTOR)", "Delete the users whose --expires time has passed", func() {
+		o.pruneExpired = true
+	})
+	o.addWriteFlags(cmd)
+	o.addSelectorFlags(cmd)
+	return cmd
+}
+
+// validateExpires parses --expires relative to now.
+func (o *CommandOptions) validateExpires(now time.Time) error {
+	if o.pruneExpired && o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("prune-expired isn't supported for basic-auth secrets")
+	}
+	if o.expires == "" {
+		return nil
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("--expires isn't supported for basic-auth secrets")
+	}
+	if o.expires == expiresNever {
+		return nil
+	}
+	t, err := parseExpires(o.expires, now)
+	if err != nil {
+		return err
+	}
+	if !t.After(now) {
+		return fmt.Errorf("--expires %q is in the past", o.expires)
+	}
+	o.expiresAt = t
+	return nil
+}
+
+// parseExpires accepts a date, an RFC 3339 time or a duration from now. A
+// date includes the whole day.
+func parseExpires(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && days > 0 {
+			return now.AddDate(0, 0, days).UTC().Truncate(time.Second), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(d).UTC().Truncate(time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --expires %q, must be a date like 2006-01-02, an RFC 3339 time or a duration like 720h or 30d", value)
+}
+
+// readExpiries returns the expiry times stored in secret by username.
+func readExpiries(secret *v1.Secret) (map[string]time.Time, error) {
+	expiries := make(map[string]time.Time)
+	data, ok := secret.Annotations[expiresAnnotation]
+	if !ok {
+		return expiries, nil
+	}
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", expiresAnnotation, err)
+	}
+	for username, value := range raw {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: user %q: %v", expiresAnnotation, username, err)
+		}
+		expiries[username] = t
+	}
+	return expiries, nil
+}
+
+// writeExpiries stores expiries in secret, removing the annotation when no
+// user expires.
+func writeExpiries(secret *v1.Secret, expiries map[string]time.Time) {
+	if len(expiries) == 0 {
+		delete(secret.Annotations, expiresAnnotation)
+		return
+	}
+	raw := make(map[string]string, len(expiries))
+	for username, t := range expiries {
+		raw[username] = t.UTC().Format(time.RFC3339)
+	}
+	// encoding/json sorts the keys, keeping the annotation stable
+	data, _ := json.Marshal(raw)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[expiresAnnotation] = string(data)
+}
+
+// updateExpiries keeps the expiry annotation of secret in line with a change
+// of username. With set the user got a new password and --expires, if
+// given, is recorded. Otherwise the entry moves to renameTo or, if that is
+// empty, is dropped.
+func (o *CommandOptions) updateExpiries(secret *v1.Secret, username, renameTo string, set bool) error {
+	expiries, err := readExpiries(secret)
+	if err != nil {
+		return err
+	}
+	t, exists := expiries[username]
+	switch {
+	case set && o.expires == expiresNever:
+		delete(expiries, username)
+	case set && o.expires != "":
+		expiries[username] = o.expiresAt
+	case set:
+		// a new password keeps the expiry
+		return nil
+	case !exists:
+		return nil
+	case renameTo != "":
+		delete(expiries, username)
+		expiries[renameTo] = t
+	default:
+		delete(expiries, username)
+	}
+	writeExpiries(secret, expiries)
+	return nil
+}
+
+// expiryNote returns the expiry of a user for the plain list output.
+func expiryNote(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if !t.After(now) {
+		return fmt.Sprintf(" (expired %s)", t.Format(time.RFC3339))
+	}
+	return fmt.Sprintf(" (expires %s)", t.Format(time.RFC3339))
+}
+
+// runPruneExpired deletes the users whose expiry has passed from every key.
+func (o *CommandOptions) runPruneExpired(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	expiries, err := readExpiries(secret)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var expired []string
+	for username, t := range expiries {
+		if !t.After(now) {
+			expired = append(expired, username)
+		}
+	}
+	sort.Strings(expired)
+	if len(expired) == 0 {
+		o.logf(logNormal, "No expired users in secret %q", secret.Name)
+		return nil
+	}
+
+	var messages []string
+	for _, username := range expired {
+		for _, f := range files {
+			existing, ok := f.lookup(username)
+			if !ok {
+				continue
+			}
+			messages = append(messages, fmt.Sprintf("Removed expired %s from key %q", describeEntry(existing, f.passwords[existing]), f.key))
+			if err := f.DeleteUser(existing); err != nil {
+				return err
+			}
+		}
+		delete(expiries, username)
+		if err := o.updateComments(secret, username, "", false); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		f.operation = "prune-expired"
+		secret.Data[f.key] = f.Bytes()
+	}
+	writeExpiries(secret, expiries)
+	o.recordChange(secret, "prune-expired", expired, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		o.logf(logNormal, "%s%s", m, o.dryRunSuffix())
+	}
+	return o.printResults(files)
+}
@@ -0,0 +1,225 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	exportFile    = "file"
+	exportYAML    = "yaml"
+	exportHAProxy = "haproxy-userlist"
+	exportCaddy   = "caddy"
+)
+
+// exportConfigMapKeys are the default keys of --to-configmap per format, the
+// plain data goes to the exported key.
+var exportConfigMapKeys = map[string]string{
+	exportHAProxy: "userlist.cfg",
+	exportCaddy:   "basic_auth.caddy",
+}
+
+// newExportCommand returns the export subcommand which writes the htpasswd
+// data of a secret to a local file or stdout.
+func newExportCommand(o *CommandOptions) *cobra.Command {
+	var format, path, configMap, configMapKey string
+	cmd := &cobra.Command{
+		Use:   "export SECRET",
+		Short: "Export the htpasswd data of a secret to a local file",
+		Long: `Export the htpasswd data of a secret to a local file.
+
+-o haproxy-userlist converts the entries into a HAProxy userlist named after
+the secret. HAProxy checks passwords with crypt(3), so bcrypt, MD5, SHA-256,
+SHA-512 and DES crypt hashes are kept, plaintext entries (--insecure-allow)
+become insecure-password and other hashes, e.g. apr1 or SHA-1, are skipped
+with a warning.
+
+-o caddy writes a Caddyfile basic_auth directive to import into a site
+block. Caddy only checks bcrypt hashes, users with other hashes are skipped
+with a warning.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if o.fromManifest == "" {
+				if len(o.args) != 1 {
+					return validationError(fmt.Errorf("secret is required"))
+				}
+				o.secretName = o.args[0]
+			}
+			if err := o.validateKeyNames(); err != nil {
+				return validationError(err)
+			}
+			switch format {
+			case exportFile, exportHAProxy, exportCaddy:
+				if len(o.keyNames) != 1 {
+					return validationError(fmt.Errorf("-o %s exports a single key, use -o yaml for several keys", format))
+				}
+			case exportYAML:
+				if configMap != "" {
+					return validationError(fmt.Errorf("-o yaml writes a secret manifest, it can't be stored in a configmap"))
+				}
+			default:
+				return validationError(fmt.Errorf("invalid output format %q, must be one of: %s, %s, %s, %s", format, exportFile, exportYAML, exportHAProxy, exportCaddy))
+			}
+			if configMap != "" {
+				if path != "" {
+					return validationError(fmt.Errorf("--to-file and --to-configmap are mutually exclusive"))
+				}
+				if o.fromManifest != "" {
+					return validationError(fmt.Errorf("--to-configmap requires a cluster, it can't be combined with --from-manifest"))
+				}
+				if configMapKey == "" {
+					configMapKey = exportConfigMapKeys[format]
+				}
+				if configMapKey == "" {
+					configMapKey = o.keyNames[0]
+				}
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			if configMap != "" {
+				return o.RunExportConfigMap(ctx, format, configMap, configMapKey)
+			}
+			return o.RunExport(ctx, format, path)
+		},
+	}
+	cmd.Flags().StringVarP(&format, "output", "o", exportFile, "Output format. One of: file (plain htpasswd data), yaml (secret manifest), haproxy-userlist, caddy")
+	cmd.Flags().StringVarP(&path, "to-file", "f", "", "File to write to instead of stdout")
+	cmd.Flags().StringVarP(&configMap, "to-configmap", "", "", "Store the output in this ConfigMap instead of printing it")
+	cmd.Flags().StringVarP(&configMapKey, "configmap-key", "", "", "Key of --to-configmap, defaults to userlist.cfg for haproxy-userlist, basic_auth.caddy for caddy and the exported key otherwise")
+	o.addInsecureAllowFlag(cmd)
+	return cmd
+}
+
+// RunExport loads the secret and writes the data of the selected keys in the
+// given format to path, or stdout if path is empty.
+func (o *CommandOptions) RunExport(ctx context.Context, format, path string) error {
+	data, err := o.exportData(ctx, format)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = o.Out.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	o.logf(logNormal, "Exported secret %q to %q", o.secretName, path)
+	return nil
+}
+
+// RunExportConfigMap loads the secret and stores the data of the selected key
+// in the given format in key of the ConfigMap name.
+func (o *CommandOptions) RunExportConfigMap(ctx context.Context, format, name, key string) error {
+	data, err := o.exportData(ctx, format)
+	if err != nil {
+		return err
+	}
+	return o.saveConfigMapData(ctx, name, map[string]string{key: string(data)})
+}
+
+// exportData returns the data of the selected keys of the secret in the
+// given format.
+func (o *CommandOptions) exportData(ctx context.Context, format string) ([]byte, error) {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return nil, fmt.Errorf("export only supports htpasswd data, not basic-auth secrets")
+	}
+
+	var data []byte
+	switch format {
+	case exportFile:
+		data = secret.Data[o.keyNames[0]]
+		if !looksLikePasswordFile(data) {
+			return nil, fmt.Errorf("key %q does not contain htpasswd data", o.keyNames[0])
+		}
+	case exportHAProxy:
+		files, err := o.loadPasswordFiles(secret)
+		if err != nil {
+			return nil, err
+		}
+		data = o.haproxyUserlist(secret.Name, files[0])
+	case exportCaddy:
+		files, err := o.loadPasswordFiles(secret)
+		if err != nil {
+			return nil, err
+		}
+		data = o.caddyBasicAuth(files[0])
+	case exportYAML:
+		exported := cleanManifest(secret)
+		exported.Data = map[string][]byte{}
+		for _, key := range o.keyNames {
+			exported.Data[key] = secret.Data[key]
+		}
+		if data, err = yaml.Marshal(exported); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// haproxyUserlist converts the entries of f into a HAProxy userlist. Hashes
+// crypt(3) can't check are skipped with a warning.
+func (o *CommandOptions) haproxyUserlist(name string, f *keyFile) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "userlist %s\n", name)
+	users, _ := f.ListUsers()
+	for _, u := range users {
+		hash := f.passwords[u]
+		switch algorithm := f.algorithm(u); algorithm {
+		case "bcrypt", "md5-crypt", "sha256-crypt", "sha512-crypt", "crypt":
+			fmt.Fprintf(&buf, "    user %s password %s\n", u, hash)
+		case "plain":
+			fmt.Fprintf(&buf, "    user %s insecure-password %s\n", u, hash)
+		default:
+			if algorithm == "" {
+				algorithm = "unknown"
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: skipping user %q, HAProxy can't check %s hashes, rehash it with --hash bcrypt\n", u, algorithm)
+		}
+	}
+	return buf.Bytes()
+}
+
+// caddyBasicAuth converts the bcrypt entries of f into a Caddyfile
+// basic_auth directive. Other hashes are skipped with a warning.
+func (o *CommandOptions) caddyBasicAuth(f *keyFile) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "basic_auth {\n")
+	users, _ := f.ListUsers()
+	for _, u := range users {
+		algorithm := f.algorithm(u)
+		if algorithm != "bcrypt" {
+			if algorithm == "" {
+				algorithm = "unknown"
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: skipping user %q, Caddy only checks bcrypt hashes, not %s\n", u, algorithm)
+			continue
+		}
+		fmt.Fprintf(&buf, "\t%s %s\n", caddyQuote(u), f.passwords[u])
+	}
+	fmt.Fprintf(&buf, "}\n")
+	return buf.Bytes()
+}
+
+// caddyQuote quotes s if it would otherwise not be a single Caddyfile token.
+func caddyQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"{}#") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
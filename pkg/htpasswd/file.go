@@ -0,0 +1,90 @@
+package htpasswd
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// File is the htpasswd data of a single secret key for use outside of the
+// command. It behaves like the command does, e.g. the entry order and
+// comments are preserved.
+type File struct {
+	f *passwordFile
+}
+
+// FileOptions configures ParseFile. The zero value hashes new passwords with
+// bcrypt and its default cost.
+type FileOptions struct {
+	// Hash is the algorithm of new passwords, one of the --hash values.
+	Hash       string
+	BcryptCost int
+	// IgnoreCase matches usernames case-insensitively and stores them in
+	// lowercase.
+	IgnoreCase bool
+	// InsecureAllow enables the plain and crypt hashes and verifies
+	// entries in no known hash format as plaintext passwords.
+	InsecureAllow bool
+}
+
+// ParseFile parses htpasswd data.
+func ParseFile(data []byte, opts FileOptions) (*File, error) {
+	if opts.Hash == "" {
+		opts.Hash = defaultHash
+	}
+	if opts.BcryptCost == 0 {
+		opts.BcryptCost = bcrypt.DefaultCost
+	}
+	hasher, err := newHasher(opts.Hash, hashOptions{
+		bcryptCost:        opts.BcryptCost,
+		argon2Memory:      defaultArgon2Memory,
+		argon2Iterations:  defaultArgon2Iterations,
+		argon2Parallelism: defaultArgon2Parallelism,
+		insecure:          opts.InsecureAllow,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := newPasswordFile(data, hasher, opts.IgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+	f.allowPlain = opts.InsecureAllow
+	return &File{f: f}, nil
+}
+
+// Users returns the sorted usernames.
+func (f *File) Users() []string {
+	users, _ := f.f.ListUsers()
+	return users
+}
+
+// HasUser reports whether username exists.
+func (f *File) HasUser(username string) bool {
+	_, ok := f.f.lookup(username)
+	return ok
+}
+
+// SetPassword adds username or changes its password.
+func (f *File) SetPassword(username, password string) error {
+	return f.f.SetPassword(username, password)
+}
+
+// DeleteUser removes username.
+func (f *File) DeleteUser(username string) error {
+	return f.f.DeleteUser(username)
+}
+
+// CheckPassword reports whether password matches the stored hash of
+// username.
+func (f *File) CheckPassword(username, password string) (bool, error) {
+	return f.f.CheckPassword(username, password)
+}
+
+// Warnings returns the non-fatal problems found while parsing.
+func (f *File) Warnings() []string {
+	return f.f.warnings
+}
+
+// Bytes returns the htpasswd data.
+func (f *File) Bytes() []byte {
+	return f.f.Bytes()
+}
@@ -0,0 +1,144 @@
+package htpasswd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultPasswordLength = 24
+
+// charsets are the named character sets of --charset. Any other value is
+// used as the literal set of characters.
+var charsets = map[string]string{
+	"alnum":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"ascii":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~",
+	"hex":    "0123456789abcdef",
+	"digits": "0123456789",
+}
+
+func charsetNames() []string {
+	var names []string
+	for name := range charsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o *CommandOptions) addGenerateFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.generate, "generate", "", false, "Generate a random password and print it once")
+	cmd.Flags().StringVarP(&o.generatedPasswordFile, "generated-password-file", "", "", "Write the generated password to this file instead of printing it")
+	o.addStorePlaintextFlag(cmd)
+	o.addCopyPasswordFlags(cmd)
+	o.addCharsetFlags(cmd)
+}
+
+func (o *CommandOptions) addStorePlaintextFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.storePlaintextURI, "store-plaintext", "", "", "Store the generated password in a secret manager instead of printing it: vault://PATH#FIELD or pass://PATH, {user} is replaced by the username")
+}
+
+func (o *CommandOptions) addCharsetFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&o.generateLength, "length", "", defaultPasswordLength, "Length of generated passwords")
+	cmd.Flags().StringVarP(&o.charset, "charset", "", "alnum", fmt.Sprintf("Characters of generated passwords. One of: %s, or the characters to use", strings.Join(charsetNames(), ", ")))
+}
+
+// validateGenerate checks the flags of --generate.
+func (o *CommandOptions) validateGenerate() error {
+	if !o.generate {
+		if o.generatedPasswordFile != "" || o.storePlaintextURI != "" {
+			return fmt.Errorf("--generated-password-file and --store-plaintext require --generate")
+		}
+		return nil
+	}
+	if o.storePlaintextURI != "" {
+		if o.generatedPasswordFile != "" || o.passwordsFile != "" || o.passwordsSecret != "" {
+			return fmt.Errorf("--store-plaintext can't be combined with --generated-password-file, --passwords-file or --passwords-secret")
+		}
+		if _, _, err := parseStorePlaintext(o.storePlaintextURI, "user"); err != nil {
+			return err
+		}
+	}
+	if o.passwordStdin || o.passwordFile != "" || o.passwordEnv != "" || o.passwordFrom != "" {
+		return fmt.Errorf("--generate can't be combined with --password-stdin, --password-file, --password-env or --password-from")
+	}
+	if o.generateLength < 8 {
+		return fmt.Errorf("--length must be at least 8")
+	}
+	if !utf8.ValidString(o.charset) {
+		return fmt.Errorf("--charset must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(o.charsetChars()) < 2 {
+		return fmt.Errorf("--charset must contain at least 2 characters")
+	}
+	return nil
+}
+
+// charsetChars returns the characters selected by --charset.
+func (o *CommandOptions) charsetChars() string {
+	if chars, ok := charsets[o.charset]; ok {
+		return chars
+	}
+	return o.charset
+}
+
+// generatePassword returns a password of length characters picked uniformly
+// from chars using crypto/rand. chars may hold multibyte characters.
+func generatePassword(length int, chars string) (string, error) {
+	runes := []rune(chars)
+	max := big.NewInt(int64(len(runes)))
+	password := make([]rune, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("unable to generate password: %v", err)
+		}
+		password[i] = runes[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// showGeneratedPassword hands out the generated password once the secret was
+// saved. It goes to stdout unless stdout already carries the secret or the
+// operation result.
+func (o *CommandOptions) showGeneratedPassword(password string) error {
+	if !o.generate {
+		return nil
+	}
+	if o.storePlaintextURI != "" && o.dryRun == "" {
+		if err := o.storePlaintext(o.username, password); err != nil {
+			// the password is meant for the store only, withhold it
+			return fmt.Errorf("%v; the secret was saved with a password which isn't shown, generate a new one", err)
+		}
+		return nil
+	}
+	if o.copyPassword && o.dryRun == "" {
+		return o.copyGeneratedPassword(password)
+	}
+	if o.generatedPasswordFile != "" {
+		if err := ioutil.WriteFile(o.generatedPasswordFile, []byte(password+"\n"), 0600); err != nil {
+			return fmt.Errorf("unable to write generated password: %v", err)
+		}
+		o.logf(logNormal, "Generated password for user %q written to %q", o.username, o.generatedPasswordFile)
+		return nil
+	}
+	if o.fromManifest != "" || o.dryRun != "" || o.output != "" || o.stdinTaken() || o.outputManifest == "-" {
+		fmt.Fprintf(o.ErrOut, "Generated password for user %q: %s\n", o.username, password)
+		return nil
+	}
+	fmt.Fprintln(o.Out, password)
+	return nil
+}
+
+// passwordFallback prints the generated password to stderr after handing it
+// out failed with err. The secret is already saved, so this is the only copy.
+func (o *CommandOptions) passwordFallback(password string, err error) error {
+	fmt.Fprintf(o.ErrOut, "Generated password for user %q: %s\n", o.username, password)
+	return err
+}
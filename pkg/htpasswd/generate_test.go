@@ -0,0 +1,93 @@
+package htpasswd
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	tests := []struct {
+		name  string
+		chars string
+	}{
+		{"alnum", charsets["alnum"]},
+		{"multibyte", "äöü"},
+		{"mixed", "aä€𝄞"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			password, err := generatePassword(32, test.chars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.ValidString(password) {
+				t.Fatalf("password %q isn't valid UTF-8", password)
+			}
+			if n := utf8.RuneCountInString(password); n != 32 {
+				t.Errorf("password %q has %d characters, want 32", password, n)
+			}
+			for _, r := range password {
+				if !strings.ContainsRune(test.chars, r) {
+					t.Errorf("password %q has %q, which isn't in %q", password, r, test.chars)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateGenerateCharset(t *testing.T) {
+	tests := []struct {
+		charset string
+		valid   bool
+	}{
+		{"alnum", true},
+		{"äö", true},
+		{"ä", false},
+		{"a\xff", false},
+	}
+	for _, test := range tests {
+		o := &CommandOptions{generate: true, generateLength: 16, charset: test.charset}
+		if err := o.validateGenerate(); (err == nil) != test.valid {
+			t.Errorf("validateGenerate with --charset %q = %v, want valid %v", test.charset, err, test.valid)
+		}
+	}
+}
+
+// failingPass puts a pass command which always fails first in $PATH. Call
+// the returned function to restore $PATH.
+func failingPass(c *testCluster) func() {
+	if err := os.Chmod(c.writeFile("pass", "#!/bin/sh\necho 'pass: store is locked' >&2\nexit 1\n"), 0700); err != nil {
+		c.t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", c.dir+string(os.PathListSeparator)+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+func TestStorePlaintextFailureWithholdsPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"add", []string{"add", "gateway", "bob", "--generate", "--store-plaintext", "pass://web/{user}"}, "generate a new one"},
+		{"rotate", []string{"rotate", "gateway", "alice", "--yes", "--store-plaintext", "pass://web/{user}"}, "rotate again"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCluster(t, newTestSecret("gateway", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+			defer c.close()
+			defer failingPass(c)()
+
+			out, errOut, err := c.run("", test.args...)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("error = %v, want the failed store reported\n%s", err, errOut)
+			}
+			if out != "" || strings.Contains(errOut, "PASSWORD") || strings.Contains(errOut, "Generated password for user") {
+				t.Errorf("the password was shown after the store failed:\nstdout: %q\nstderr: %q", out, errOut)
+			}
+		})
+	}
+}
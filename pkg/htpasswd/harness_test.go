@@ -0,0 +1,389 @@
+package htpasswd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// testNamespace is the namespace of the context of the test kubeconfig.
+const testNamespace = "team-a"
+
+// testKubeconfig has a context with testNamespace and a server nobody
+// listens on, the requests of the commands go to the injected clients.
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubernetes.invalid
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: ` + testNamespace + `
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+
+// testCluster is an in-memory API server for secrets and configmaps with
+// the semantics the commands rely on: NotFound, AlreadyExists, conflicts on
+// stale resource versions, label selectors and dry runs. Deployments can be
+// read, along with the discovery documents, as owners of --owner.
+type testCluster struct {
+	t      *testing.T
+	server *httptest.Server
+	dir    string
+
+	mu       sync.Mutex
+	objects  map[string]runtime.Object
+	version  int
+	requests []string
+	// dropCreates is the number of POST requests whose response is lost
+	// after the object was stored, like on a connection reset
+	dropCreates int
+}
+
+// newTestCluster starts a test cluster holding objects, which default to
+// testNamespace. Call close when done.
+func newTestCluster(t *testing.T, objects ...runtime.Object) *testCluster {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "kubectl-htpasswd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCluster{t: t, dir: dir, objects: make(map[string]runtime.Object)}
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accessor.GetNamespace() == "" {
+			accessor.SetNamespace(testNamespace)
+		}
+		c.version++
+		accessor.SetResourceVersion(strconv.Itoa(c.version))
+		c.objects[objectKey(resourceOf(obj), accessor.GetNamespace(), accessor.GetName())] = obj.DeepCopyObject()
+	}
+	c.writeFile("kubeconfig", testKubeconfig)
+	c.writeFile("config.yaml", "")
+	c.server = httptest.NewServer(c)
+	return c
+}
+
+func (c *testCluster) close() {
+	c.server.Close()
+	os.RemoveAll(c.dir)
+}
+
+// writeFile writes a file into the directory of the cluster and returns
+// its path.
+func (c *testCluster) writeFile(name, content string) string {
+	path := filepath.Join(c.dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		c.t.Fatal(err)
+	}
+	return path
+}
+
+// options returns CommandOptions with clients for the cluster.
+func (c *testCluster) options(streams genericclioptions.IOStreams) *CommandOptions {
+	config := &rest.Config{
+		Host: c.server.URL,
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &v1.SchemeGroupVersion,
+			NegotiatedSerializer: scheme.Codecs,
+		},
+		APIPath: "/api",
+	}
+	coreClient, err := rest.RESTClientFor(config)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return &CommandOptions{IOStreams: streams, clientset: clientset, coreClient: coreClient}
+}
+
+// run runs the command line args with stdin against the cluster and returns
+// what was printed to stdout and stderr.
+func (c *testCluster) run(stdin string, args ...string) (string, string, error) {
+	c.t.Helper()
+	var out, errOut bytes.Buffer
+	o := c.options(genericclioptions.IOStreams{In: strings.NewReader(stdin), Out: &out, ErrOut: &errOut})
+	cmd := newCommand(o)
+	cmd.SetArgs(append([]string{"--kubeconfig", filepath.Join(c.dir, "kubeconfig"), "--config", filepath.Join(c.dir, "config.yaml")}, args...))
+	cmd.SetOutput(&errOut)
+	err := cmd.Execute()
+	return out.String(), errOut.String(), err
+}
+
+// mustRun is run failing the test on errors.
+func (c *testCluster) mustRun(stdin string, args ...string) string {
+	c.t.Helper()
+	out, errOut, err := c.run(stdin, args...)
+	if err != nil {
+		c.t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, errOut)
+	}
+	return out
+}
+
+// secret returns the secret name of testNamespace, failing the test if it
+// doesn't exist.
+func (c *testCluster) secret(name string) *v1.Secret {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[objectKey("secrets", testNamespace, name)]
+	if !ok {
+		c.t.Fatalf("secret %q doesn't exist", name)
+	}
+	return obj.(*v1.Secret).DeepCopy()
+}
+
+// newTestSecret returns a secret of testNamespace with the data of the
+// key auth.
+func newTestSecret(name, auth string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{"auth": []byte(auth)},
+	}
+}
+
+func objectKey(resource, namespace, name string) string {
+	return resource + "/" + namespace + "/" + name
+}
+
+func resourceOf(obj runtime.Object) string {
+	switch obj.(type) {
+	case *v1.Secret:
+		return "secrets"
+	case *v1.ConfigMap:
+		return "configmaps"
+	case *appsv1.Deployment:
+		return "deployments"
+	}
+	panic(fmt.Sprintf("unsupported object %T", obj))
+}
+
+// newObject returns an empty object and list of resource.
+func newObject(resource string) (runtime.Object, runtime.Object) {
+	switch resource {
+	case "secrets":
+		return &v1.Secret{}, &v1.SecretList{}
+	case "configmaps":
+		return &v1.ConfigMap{}, &v1.ConfigMapList{}
+	}
+	return nil, nil
+}
+
+// ServeHTTP handles /api/v1/[namespaces/NAMESPACE/]RESOURCE[/NAME].
+func (c *testCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, r.Method+" "+r.URL.Path)
+	if c.serveApps(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/"), "/")
+	var namespace, name string
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	resource := parts[0]
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	obj, list := newObject(resource)
+	gr := schema.GroupResource{Resource: resource}
+	if obj == nil || len(parts) > 2 {
+		c.writeStatus(w, apierrors.NewNotFound(gr, r.URL.Path))
+		return
+	}
+	key := objectKey(resource, namespace, name)
+	dryRun := len(r.URL.Query()["dryRun"]) > 0
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			c.writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		var items []runtime.Object
+		for k, obj := range c.objects {
+			accessor, _ := meta.Accessor(obj)
+			if strings.HasPrefix(k, resource+"/") && (namespace == "" || accessor.GetNamespace() == namespace) && selector.Matches(labels.Set(accessor.GetLabels())) {
+				items = append(items, obj)
+			}
+		}
+		meta.SetList(list, items)
+		c.writeObject(w, http.StatusOK, list)
+	case r.Method == http.MethodGet:
+		existing, ok := c.objects[key]
+		if !ok {
+			c.writeStatus(w, apierrors.NewNotFound(gr, name))
+			return
+		}
+		c.writeObject(w, http.StatusOK, existing)
+	case r.Method == http.MethodPost || r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := runtime.DecodeInto(scheme.Codecs.UniversalDecoder(), body, obj); err != nil {
+			c.writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		accessor, _ := meta.Accessor(obj)
+		accessor.SetNamespace(namespace)
+		if r.Method == http.MethodPost {
+			key = objectKey(resource, namespace, accessor.GetName())
+		}
+		existing, exists := c.objects[key]
+		switch {
+		case r.Method == http.MethodPost && exists:
+			c.writeStatus(w, apierrors.NewAlreadyExists(gr, accessor.GetName()))
+			return
+		case r.Method == http.MethodPut && !exists:
+			c.writeStatus(w, apierrors.NewNotFound(gr, name))
+			return
+		case r.Method == http.MethodPut:
+			previous, _ := meta.Accessor(existing)
+			if accessor.GetResourceVersion() != previous.GetResourceVersion() {
+				c.writeStatus(w, apierrors.NewConflict(gr, name, fmt.Errorf("the object has been modified")))
+				return
+			}
+		}
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		if !dryRun {
+			c.version++
+			accessor.SetResourceVersion(strconv.Itoa(c.version))
+			c.objects[key] = obj
+		}
+		if r.Method == http.MethodPost && c.dropCreates > 0 {
+			c.dropCreates--
+			c.dropResponse(w)
+			return
+		}
+		c.writeObject(w, status, obj)
+	case r.Method == http.MethodDelete:
+		if _, ok := c.objects[key]; !ok {
+			c.writeStatus(w, apierrors.NewNotFound(gr, name))
+			return
+		}
+		if !dryRun {
+			delete(c.objects, key)
+		}
+		c.writeObject(w, http.StatusOK, &metav1.Status{Status: metav1.StatusSuccess})
+	default:
+		c.writeStatus(w, apierrors.NewMethodNotSupported(gr, r.Method))
+	}
+}
+
+// serveApps serves the discovery documents and GET of apps/v1 deployments
+// and reports whether r was one of those requests.
+func (c *testCluster) serveApps(w http.ResponseWriter, r *http.Request) bool {
+	namespaced := func(name, kind string) metav1.APIResource {
+		return metav1.APIResource{Name: name, Kind: kind, Namespaced: true, Verbs: metav1.Verbs{"get", "list"}}
+	}
+	switch r.URL.Path {
+	case "/api":
+		c.writeJSON(w, http.StatusOK, &metav1.APIVersions{Versions: []string{"v1"}})
+		return true
+	case "/api/v1":
+		c.writeJSON(w, http.StatusOK, &metav1.APIResourceList{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			namespaced("configmaps", "ConfigMap"), namespaced("secrets", "Secret"),
+		}})
+		return true
+	case "/apis":
+		apps := metav1.GroupVersionForDiscovery{GroupVersion: "apps/v1", Version: "v1"}
+		c.writeJSON(w, http.StatusOK, &metav1.APIGroupList{Groups: []metav1.APIGroup{{Name: "apps", Versions: []metav1.GroupVersionForDiscovery{apps}, PreferredVersion: apps}}})
+		return true
+	case "/apis/apps/v1":
+		c.writeJSON(w, http.StatusOK, &metav1.APIResourceList{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{namespaced("deployments", "Deployment")}})
+		return true
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/apis/apps/v1/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/apis/apps/v1/") || len(parts) != 4 || parts[0] != "namespaces" || parts[2] != "deployments" || r.Method != http.MethodGet {
+		return false
+	}
+	obj, ok := c.objects[objectKey("deployments", parts[1], parts[3])]
+	if !ok {
+		c.writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, parts[3]))
+		return true
+	}
+	deployment := obj.(*appsv1.Deployment).DeepCopy()
+	deployment.APIVersion, deployment.Kind = "apps/v1", "Deployment"
+	c.writeJSON(w, http.StatusOK, deployment)
+	return true
+}
+
+// writeJSON writes obj, which needn't be registered with the scheme.
+func (c *testCluster) writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		c.t.Errorf("unable to encode %T: %v", obj, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// dropResponse closes the connection without a response.
+func (c *testCluster) dropResponse(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		c.t.Errorf("unable to drop the response: %v", err)
+		return
+	}
+	conn.Close()
+}
+
+func (c *testCluster) writeObject(w http.ResponseWriter, status int, obj runtime.Object) {
+	data, err := runtime.Encode(scheme.Codecs.LegacyCodec(v1.SchemeGroupVersion), obj)
+	if err != nil {
+		c.t.Errorf("unable to encode %T: %v", obj, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (c *testCluster) writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	c.writeObject(w, int(err.ErrStatus.Code), &err.ErrStatus)
+}
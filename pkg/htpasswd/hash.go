@@ -0,0 +1,116 @@
+package htpasswd
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher creates the stored representation of a password.
+type Hasher interface {
+	Hash(password string) (string, error)
+}
+
+// hashOptions holds the tunables of the hash algorithms.
+type hashOptions struct {
+	bcryptCost        int
+	argon2Memory      int
+	argon2Iterations  int
+	argon2Parallelism int
+	// insecure enables the hashers of newInsecureHasher.
+	insecure bool
+}
+
+// hashOptions returns the tunables given on the command line.
+func (o *CommandOptions) hashOptions() hashOptions {
+	return hashOptions{
+		bcryptCost:        o.bcryptCost,
+		argon2Memory:      o.argon2Memory,
+		argon2Iterations:  o.argon2Iterations,
+		argon2Parallelism: o.argon2Parallelism,
+		insecure:          o.insecureAllow,
+	}
+}
+
+// hashers is the registry of supported algorithms keyed by their --hash name.
+var hashers = map[string]func(opts hashOptions) (Hasher, error){
+	"apr1": func(hashOptions) (Hasher, error) {
+		return apr1Hasher{}, nil
+	},
+	"argon2id": newArgon2Hasher,
+	"bcrypt":   newBcryptHasher,
+	"crypt":    newInsecureHasher("crypt", cryptHasher{}),
+	// md5 matches the -m option of Apache htpasswd, which means apr1
+	"md5": func(hashOptions) (Hasher, error) {
+		return apr1Hasher{}, nil
+	},
+	"plain": newInsecureHasher("plain", plainHasher{}),
+	"sha": func(hashOptions) (Hasher, error) {
+		return shaHasher{}, nil
+	},
+	// sha256 and sha512 match the -2 and -5 options of Apache htpasswd
+	"sha256": func(hashOptions) (Hasher, error) {
+		return shaCryptHasher{magic: sha256CryptMagic}, nil
+	},
+	"sha512": func(hashOptions) (Hasher, error) {
+		return shaCryptHasher{magic: sha512CryptMagic}, nil
+	},
+}
+
+// defaultHash is the algorithm used unless --hash is given.
+const defaultHash = "bcrypt"
+
+// newHasher returns the hasher registered for name.
+func newHasher(name string, opts hashOptions) (Hasher, error) {
+	newFunc, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q, must be one of %v", name, hasherNames())
+	}
+	return newFunc(opts)
+}
+
+// hasherNames returns the sorted names of all registered hashers.
+func hasherNames() []string {
+	var names []string
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shaHasher creates {SHA} entries, the base64 encoded SHA-1 of the password.
+type shaHasher struct{}
+
+func (shaHasher) Hash(password string) (string, error) {
+	hash := sha1.New()
+	if _, err := hash.Write([]byte(password)); err != nil {
+		return "", err
+	}
+	return "{SHA}" + base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// bcryptHasher creates $2y$ entries as generated by Apache htpasswd -B.
+type bcryptHasher struct {
+	cost int
+}
+
+func newBcryptHasher(opts hashOptions) (Hasher, error) {
+	if opts.bcryptCost < bcrypt.MinCost || opts.bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return bcryptHasher{cost: opts.bcryptCost}, nil
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	// Go emits $2a$, Apache uses $2y$ for the same algorithm
+	return "$2y$" + strings.TrimPrefix(string(hash), "$2a$"), nil
+}
@@ -0,0 +1,114 @@
+package htpasswd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeHasher is a deterministic Hasher which records the hashed passwords.
+type fakeHasher struct {
+	passwords []string
+	err       error
+}
+
+func (h *fakeHasher) Hash(password string) (string, error) {
+	if h.err != nil {
+		return "", h.err
+	}
+	h.passwords = append(h.passwords, password)
+	return "{FAKE}" + password, nil
+}
+
+// fakeUserHasher is a fakeHasher including the username like the htdigest
+// hasher does.
+type fakeUserHasher struct {
+	fakeHasher
+	usernames []string
+}
+
+func (h *fakeUserHasher) HashUser(username, password string) (string, error) {
+	h.usernames = append(h.usernames, username)
+	return h.Hash(username + "/" + password)
+}
+
+func TestSetPasswordUsesHasher(t *testing.T) {
+	h := &fakeHasher{}
+	f, err := newPasswordFile([]byte("# team\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=:Alice\nbob:{SHA}old\n"), h, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPassword("alice", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPassword("carol", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	want := "# team\nalice:{FAKE}new:Alice\nbob:{SHA}old\ncarol:{FAKE}pw\n"
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+	if strings.Join(h.passwords, ",") != "new,pw" {
+		t.Errorf("hashed passwords = %q, want [new pw]", h.passwords)
+	}
+}
+
+func TestSetPasswordErrors(t *testing.T) {
+	f, err := newPasswordFile(nil, &fakeHasher{err: errors.New("hasher failed")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPassword("alice", ""); err == nil {
+		t.Errorf("SetPassword with an empty password succeeded")
+	}
+	if err := f.SetPassword("alice", "pw"); err == nil || err.Error() != "hasher failed" {
+		t.Errorf("SetPassword error = %v, want the error of the hasher", err)
+	}
+	if got := string(f.Bytes()); got != "" {
+		t.Errorf("Bytes() after failed SetPassword = %q, want no entries", got)
+	}
+}
+
+func TestSetPasswordIgnoreCase(t *testing.T) {
+	h := &fakeUserHasher{}
+	f, err := newPasswordFile([]byte("Alice:{SHA}old\n"), h, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPassword("ALICE", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(f.Bytes()), "alice:{FAKE}alice/new\n"; got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+	if strings.Join(h.usernames, ",") != "alice" {
+		t.Errorf("usernames given to the hasher = %q, want [alice]", h.usernames)
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	for _, name := range hasherNames() {
+		if name == "crypt" || name == "plain" {
+			if _, err := newHasher(name, hashOptions{bcryptCost: 4}); err == nil {
+				t.Errorf("newHasher(%q) without insecure succeeded", name)
+			}
+			continue
+		}
+		h, err := newHasher(name, hashOptions{bcryptCost: 4, argon2Memory: 64, argon2Iterations: 1, argon2Parallelism: 1})
+		if err != nil {
+			t.Errorf("newHasher(%q) = %v", name, err)
+			continue
+		}
+		hash, err := h.Hash("secret")
+		if err != nil {
+			t.Errorf("%s: Hash = %v", name, err)
+			continue
+		}
+		if ok, err := verifyHash(hash, "secret"); !ok || err != nil {
+			t.Errorf("%s: verifyHash(%q) = %v, %v, want true", name, hash, ok, err)
+		}
+	}
+	if _, err := newHasher("rot13", hashOptions{}); err == nil {
+		t.Errorf("newHasher of an unknown algorithm succeeded")
+	}
+}
@@ -0,0 +1,177 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// historyAnnotation holds the audit trail of the changes made by the
+	// plugin as a JSON list, oldest first.
+	historyAnnotation = "htpasswd.kubectl.io/history"
+	// maxHistoryEntries bounds the size of the annotation, older entries
+	// are dropped.
+	maxHistoryEntries = 50
+)
+
+// historyEntry records a single change of a secret.
+type historyEntry struct {
+	Time      string   `json:"time"`
+	Actor     string   `json:"actor"`
+	Operation string   `json:"operation"`
+	Keys      []string `json:"keys,omitempty"`
+	Users     []string `json:"users,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty"`
+}
+
+// readHistory returns the audit trail stored in secret.
+func readHistory(secret *v1.Secret) ([]historyEntry, error) {
+	data, ok := secret.Annotations[historyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var history []historyEntry
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", historyAnnotation, err)
+	}
+	return history, nil
+}
+
+// recordChange appends an entry for the operation to the audit trail of
+// secret. Like the last-modified time, the trail isn't kept in manifests or
+// local files.
+func (o *CommandOptions) recordChange(secret *v1.Secret, operation string, users []string, algorithm string) {
+	if o.fromManifest != "" || o.outputManifest != "" || o.local {
+		return
+	}
+	history, err := readHistory(secret)
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "Warning: discarding history of secret %q: %v\n", secret.Name, err)
+	}
+	entry := historyEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Actor:     o.actor(),
+		Operation: operation,
+		Users:     users,
+		Algorithm: algorithm,
+	}
+	if secret.Type != v1.SecretTypeBasicAuth {
+		entry.Keys = o.keyNames
+	}
+	history = append(history, entry)
+	if len(history) > maxHistoryEntries {
+		history = history[len(history)-maxHistoryEntries:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[historyAnnotation] = string(data)
+}
+
+// actor names who makes the change: the impersonated user if --as is given,
+// otherwise the kubeconfig user of the current context.
+func (o *CommandOptions) actor() string {
+	if o.configFlags.Impersonate != nil && *o.configFlags.Impersonate != "" {
+		return *o.configFlags.Impersonate
+	}
+	if o.configFlags.AuthInfoName != nil && *o.configFlags.AuthInfoName != "" {
+		return *o.configFlags.AuthInfoName
+	}
+	if o.context == nil {
+		return "in-cluster"
+	}
+	return o.context.AuthInfo
+}
+
+// newHistoryCommand returns the history subcommand which prints the audit
+// trail of a secret.
+func newHistoryCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history SECRET",
+		Short: "Show the changes made to a secret",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if len(o.args) != 1 {
+				return validationError(fmt.Errorf("secret is required"))
+			}
+			o.secretName = o.args[0]
+			switch o.output {
+			case "", "json", "yaml":
+			default:
+				return validationError(fmt.Errorf("unsupported output format %q, must be one of: json, yaml", o.output))
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunHistory(ctx)
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: json, yaml")
+	return cmd
+}
+
+// RunHistory prints the audit trail of the secret, oldest change first.
+func (o *CommandOptions) RunHistory(ctx context.Context) error {
+	secret, err := o.secrets().Get(ctx, o.secretName)
+	if apierrors.IsNotFound(err) {
+		return notFoundError("secret %q not found in namespace %q", o.secretName, o.namespace)
+	} else if err != nil {
+		return apiError(err, "unable to get secret %q", o.secretName)
+	}
+	history, err := readHistory(secret)
+	if err != nil {
+		return err
+	}
+
+	switch o.output {
+	case "json":
+		data, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.Out, string(data))
+		return nil
+	case "yaml":
+		data, err := yaml.Marshal(history)
+		if err != nil {
+			return err
+		}
+		_, err = o.Out.Write(data)
+		return err
+	}
+
+	if len(history) == 0 {
+		o.logf(logNormal, "No history recorded for secret %q", o.secretName)
+		return nil
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tOPERATION\tKEYS\tUSERS\tALGORITHM")
+	for _, e := range history {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time, e.Actor, e.Operation, orNone(strings.Join(e.Keys, ",")), orNone(strings.Join(e.Users, ",")), orNone(e.Algorithm))
+	}
+	return w.Flush()
+}
+
+// orNone returns s, or "<none>" if it is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -2,72 +2,317 @@ package htpasswd
 
 import (
 	"bytes"
-	"crypto/sha1"
-	"encoding/base64"
+	"crypto/subtle"
 	"fmt"
+	"sort"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type passwordFile struct {
-	passwords map[string]string
+	passwords  map[string]string
+	hasher     Hasher
+	ignoreCase bool
+	// allowPlain reads entries in no known hash format as plaintext
+	// passwords, see --insecure-allow.
+	allowPlain bool
+
+	// lines keeps the original order of the data including comments and
+	// blank lines, so that Bytes only rewrites the entries which changed.
+	lines []passwordLine
+
+	// warnings collects non-fatal problems found while parsing.
+	warnings []string
+}
+
+// passwordLine is a single line of htpasswd data. Comments and blank lines
+// have no username. text is the original line and is cleared once the entry
+// is modified. extra holds unknown fields following the hash, including the
+// leading colon, which are kept when the hash changes.
+type passwordLine struct {
+	username string
+	text     string
+	extra    string
 }
 
-func newPasswordFile(data []byte) (*passwordFile, error) {
-	bytes.Split(data, []byte{'\n'})
+// isComment reports whether the trimmed line l carries no entry.
+func isComment(l string) bool {
+	return l == "" || strings.HasPrefix(l, "#")
+}
+
+func newPasswordFile(data []byte, hasher Hasher, ignoreCase bool) (*passwordFile, error) {
 	f := &passwordFile{
-		passwords: make(map[string]string),
+		passwords:  make(map[string]string),
+		hasher:     hasher,
+		ignoreCase: ignoreCase,
 	}
-	for _, l := range strings.Split(string(data), "\n") {
-		l = strings.TrimSpace(l)
-		if l == "" {
+	if len(data) == 0 {
+		return f, nil
+	}
+	for i, text := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		line := i + 1
+		l := strings.TrimSpace(text)
+		if isComment(l) {
+			f.lines = append(f.lines, passwordLine{text: text})
 			continue
 		}
-		parts := strings.Split(l, ":")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid number of tokens")
+		username, password, extra, err := parseEntry(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
 		}
-		username := strings.TrimSpace(parts[0])
-		password := strings.TrimSpace(parts[1])
 		if _, ok := f.passwords[username]; ok {
-			return nil, fmt.Errorf("username %q already exists", username)
+			return nil, fmt.Errorf("line %d: username %q already exists", line, username)
+		}
+		if existing, ok := f.lookup(username); ok {
+			return nil, fmt.Errorf("line %d: username %q conflicts with %q when ignoring case, remove one of them first", line, username, existing)
+		}
+		if password == "" {
+			f.warnings = append(f.warnings, fmt.Sprintf("user %q has an empty password hash and can't log in", username))
 		}
 		f.passwords[username] = password
+		f.lines = append(f.lines, passwordLine{username: username, text: text, extra: extra})
 	}
 	return f, nil
 }
 
+// parseEntry splits the trimmed line l into username, hash and any further
+// fields. Only the first colon separates the username; the hash ends at the
+// next colon, except for htdigest entries whose hash is realm:digest.
+func parseEntry(l string) (string, string, string, error) {
+	i := strings.Index(l, ":")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("missing colon between username and hash")
+	}
+	username := strings.TrimSpace(l[:i])
+	if username == "" {
+		return "", "", "", fmt.Errorf("empty username")
+	}
+	rest := l[i+1:]
+	hash := rest
+	if parts := strings.SplitN(rest, ":", 3); len(parts) >= 2 && isDigest(parts[0]+":"+parts[1]) {
+		hash = parts[0] + ":" + parts[1]
+	} else if j := strings.Index(rest, ":"); j >= 0 {
+		hash = rest[:j]
+	}
+	return username, strings.TrimSpace(hash), rest[len(hash):], nil
+}
+
+// looksLikePasswordFile is a heuristic telling whether data could be htpasswd
+// content. It catches obvious mistakes like pointing at a certificate or
+// binary data. Single malformed lines are left to newPasswordFile, which
+// reports them with their line number.
+func looksLikePasswordFile(data []byte) bool {
+	if !isTextData(data) {
+		return false
+	}
+	entries, parsed := 0, 0
+	for _, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimSpace(l)
+		if isComment(l) {
+			continue
+		}
+		entries++
+		if _, _, _, err := parseEntry(l); err == nil {
+			parsed++
+		}
+	}
+	return entries == 0 || parsed > 0
+}
+
+// isTextData reports whether data is UTF-8 text and no PEM block.
+func isTextData(data []byte) bool {
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN"))
+}
+
 // ListUsers ...
 func (f *passwordFile) ListUsers() ([]string, error) {
 	var users []string
 	for username := range f.passwords {
 		users = append(users, username)
 	}
+	sort.Strings(users)
 	return users, nil
 }
 
 func (f *passwordFile) DeleteUser(username string) error {
-	if _, ok := f.passwords[username]; !ok {
+	existing, ok := f.lookup(username)
+	if !ok {
 		return fmt.Errorf("user %q does not exist", username)
 	}
-	delete(f.passwords, username)
+	delete(f.passwords, existing)
+	for i, l := range f.lines {
+		if l.username == existing {
+			f.lines = append(f.lines[:i], f.lines[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 
+// lookup returns the stored spelling of username. With ignoreCase set the
+// match is case-insensitive.
+func (f *passwordFile) lookup(username string) (string, bool) {
+	if _, ok := f.passwords[username]; ok {
+		return username, true
+	}
+	if !f.ignoreCase {
+		return "", false
+	}
+	for u := range f.passwords {
+		if strings.EqualFold(u, username) {
+			return u, true
+		}
+	}
+	return "", false
+}
+
 // SetPassword ...
 func (f *passwordFile) SetPassword(username, password string) error {
-	hash := sha1.New()
-	if _, err := hash.Write([]byte(password)); err != nil {
+	if password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+	var hash string
+	var err error
+	if h, ok := f.hasher.(userHasher); ok {
+		name := username
+		if f.ignoreCase {
+			name = strings.ToLower(username)
+		}
+		hash, err = h.HashUser(name, password)
+	} else {
+		hash, err = f.hasher.Hash(password)
+	}
+	if err != nil {
 		return err
 	}
-	f.passwords[username] = "{SHA}" + base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	f.SetHash(username, hash)
 	return nil
 }
 
+// SetHash stores an already hashed password for username. An existing entry
+// is updated in place, new users are appended.
+func (f *passwordFile) SetHash(username, hash string) {
+	existing, exists := f.lookup(username)
+	if f.ignoreCase {
+		if exists {
+			delete(f.passwords, existing)
+		}
+		username = strings.ToLower(username)
+	}
+	f.passwords[username] = hash
+	if !exists {
+		f.lines = append(f.lines, passwordLine{username: username})
+		return
+	}
+	for i := range f.lines {
+		if f.lines[i].username == existing {
+			f.lines[i] = passwordLine{username: username, extra: f.lines[i].extra}
+			break
+		}
+	}
+}
+
+// RenameUser moves the hash of username to newName, keeping the position of
+// the entry.
+func (f *passwordFile) RenameUser(username, newName string) error {
+	existing, ok := f.lookup(username)
+	if !ok {
+		return fmt.Errorf("user %q does not exist", username)
+	}
+	if f.ignoreCase {
+		newName = strings.ToLower(newName)
+	}
+	if other, ok := f.lookup(newName); ok && other != existing {
+		return fmt.Errorf("user %q already exists", other)
+	}
+	hash := f.passwords[existing]
+	if isDigest(hash) {
+		return fmt.Errorf("the htdigest entry of user %q includes the username, set a new password instead", existing)
+	}
+	delete(f.passwords, existing)
+	f.passwords[newName] = hash
+	for i := range f.lines {
+		if f.lines[i].username == existing {
+			f.lines[i] = passwordLine{username: newName, extra: f.lines[i].extra}
+			break
+		}
+	}
+	return nil
+}
+
+// SortUsersByAlgorithm orders users by the algorithm of their hash and by
+// name within each algorithm.
+func (f *passwordFile) SortUsersByAlgorithm(users []string) {
+	sort.SliceStable(users, func(i, j int) bool {
+		ai := hashAlgorithm(f.passwords[users[i]])
+		aj := hashAlgorithm(f.passwords[users[j]])
+		if ai != aj {
+			return ai < aj
+		}
+		return users[i] < users[j]
+	})
+}
+
+// CheckPassword reports whether password matches the stored hash of
+// username.
+func (f *passwordFile) CheckPassword(username, password string) (bool, error) {
+	existing, ok := f.lookup(username)
+	if !ok {
+		return false, fmt.Errorf("user %q does not exist", username)
+	}
+	if hash := f.passwords[existing]; isDigest(hash) {
+		return verifyDigest(existing, hash, password), nil
+	} else if f.allowPlain && hashAlgorithm(hash) == "" {
+		return verifyPlain(hash, password), nil
+	}
+	return verifyHash(f.passwords[existing], password)
+}
+
+// verifyHash checks password against hash. Hashes generated by other tools
+// are accepted as well, e.g. bcrypt hashes with the $2a$, $2b$ and $2y$
+// identifiers used by Apache htpasswd, node and PHP.
+func verifyHash(hash, password string) (bool, error) {
+	switch hashAlgorithm(hash) {
+	case "sha1":
+		expected, err := shaHasher{}.Hash(password)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1, nil
+	case "bcrypt":
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case "apr1", "md5-crypt":
+		return verifyMD5Crypt(hash, password)
+	case "sha256-crypt", "sha512-crypt":
+		return verifySHACrypt(hash, password)
+	case "argon2id":
+		return verifyArgon2(hash, password)
+	case "crypt":
+		return verifyDESCrypt(hash, password)
+	case "":
+		return false, fmt.Errorf("unknown hash format, pass --insecure-allow to read it as a plaintext password")
+	}
+	return false, fmt.Errorf("unsupported hash format %s", hashAlgorithm(hash))
+}
+
 // Bytes ...
 func (f *passwordFile) Bytes() []byte {
 	var buf bytes.Buffer
-	for user, pass := range f.passwords {
-		buf.WriteString(user + ":" + pass + "\n")
+	for _, l := range f.lines {
+		if l.text != "" || l.username == "" {
+			buf.WriteString(l.text + "\n")
+			continue
+		}
+		buf.WriteString(l.username + ":" + f.passwords[l.username] + l.extra + "\n")
 	}
 	return buf.Bytes()
 }
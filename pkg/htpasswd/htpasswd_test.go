@@ -0,0 +1,105 @@
+package htpasswd
+
+import (
+	"testing"
+)
+
+// externalHashes were created by other tools than this plugin.
+var externalHashes = []struct {
+	source   string
+	hash     string
+	password string
+}{
+	// crypt_blowfish test vectors, used by PHP and Apache htpasswd -B
+	{"crypt_blowfish $2a$", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+	{"crypt_blowfish $2b$", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+	{"crypt_blowfish $2y$", "$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+	{"crypt_blowfish $2a$ U*U*", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK", "U*U*"},
+	// example of the PHP manual for password_verify
+	{"PHP password_hash", "$2y$07$BCryptRequires22Chrcte/VlQH0piJtjXl.0t1XkA8pw9dMXTpOq", "rasmuslerdorf"},
+	// openssl passwd -apr1 gives the same hashes as Apache htpasswd -m
+	{"openssl passwd -apr1", "$apr1$saltsalt$EGVZDNN6gOqijy.tv9axG/", "correct horse"},
+	{"openssl passwd -1", "$1$saltsalt$NuzA7WTAelpl95xgBGWN60", "correct horse"},
+	{"openssl passwd -5", "$5$saltsalt$myjXcpMpE2Ofk7fj9hqyNYSn6lmWG4Mqnjx.KIRRr4/", "correct horse"},
+	{"openssl passwd -6", "$6$saltsalt$hRM5XZ86KXEw9UOmjigeVqFgULtFB2sgpC9lXQDfMib3Zgw7mEiUvBJI2EplzfAqxL5Vvwp2scFtv/uamSo5z0", "correct horse"},
+	// Apache htpasswd -s
+	{"htpasswd -s", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+	// glibc crypt(3) with a two character salt, htpasswd -d
+	{"crypt(3) DES", "abhv/ZnAzL36k", "secret12"},
+}
+
+func TestVerifyExternalHashes(t *testing.T) {
+	for _, test := range externalHashes {
+		t.Run(test.source, func(t *testing.T) {
+			ok, err := verifyHash(test.hash, test.password)
+			if err != nil || !ok {
+				t.Errorf("verifyHash(%q, %q) = %v, %v, want true", test.hash, test.password, ok, err)
+			}
+			ok, err = verifyHash(test.hash, "x"+test.password)
+			if err != nil || ok {
+				t.Errorf("verifyHash(%q) with a wrong password = %v, %v, want false", test.hash, ok, err)
+			}
+		})
+	}
+}
+
+func TestNewPasswordFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"missing colon", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n# comment\ngarbage\n", "line 3: missing colon between username and hash"},
+		{"empty username", "\n :{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", "line 2: empty username"},
+		{"duplicate", "alice:x\nbob:y\nalice:z\n", `line 3: username "alice" already exists`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newPasswordFile([]byte(test.data), nil, false)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("newPasswordFile(%q) error = %v, want %q", test.data, err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewPasswordFileKeepsExtraFields(t *testing.T) {
+	data := "alice:$apr1$salt$hash:Alice Liddell:admin\nbob:realm:0123456789abcdef0123456789abcdef:x\n"
+	f, err := newPasswordFile([]byte(data), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.passwords["alice"]; got != "$apr1$salt$hash" {
+		t.Errorf("hash of alice = %q, want %q", got, "$apr1$salt$hash")
+	}
+	if got := f.passwords["bob"]; got != "realm:0123456789abcdef0123456789abcdef" {
+		t.Errorf("digest of bob = %q", got)
+	}
+	if got := string(f.Bytes()); got != data {
+		t.Errorf("Bytes() = %q, want the unchanged %q", got, data)
+	}
+}
+
+func TestLooksLikePasswordFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"empty", "", true},
+		{"comments only", "# users\n\n", true},
+		{"entries", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nbob:$apr1$x$y\n", true},
+		{"one malformed line", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\ngarbage\nbob:$apr1$x$y\n", true},
+		{"no entry parses", "hello\nworld\n", false},
+		{"certificate", "-----BEGIN CERTIFICATE-----\nMIIB:xyz\n-----END CERTIFICATE-----\n", false},
+		{"binary", "alice:\x00\x01\x02", false},
+		{"invalid UTF-8", "alice:\xff\xfe", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := looksLikePasswordFile([]byte(test.data)); got != test.want {
+				t.Errorf("looksLikePasswordFile(%q) = %v, want %v", test.data, got, test.want)
+			}
+		})
+	}
+}
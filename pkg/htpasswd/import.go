@@ -0,0 +1,92 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func newImportCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "import SECRET --from-file FILE", "Import all users of a local htpasswd file", func() {})
+	cmd.Flags().StringVarP(&o.importFile, "from-file", "f", "", "htpasswd file to import, - for stdin")
+	cmd.Flags().BoolVarP(&o.overwrite, "overwrite", "", false, "Replace the hashes of users which already exist")
+	cmd.Flags().BoolVarP(&o.skipExisting, "skip-existing", "", false, "Keep the hashes of users which already exist")
+	o.addWriteFlags(cmd)
+	return cmd
+}
+
+// validateImport checks the flags of the import subcommand.
+func (o *CommandOptions) validateImport() error {
+	if o.overwrite && o.skipExisting {
+		return fmt.Errorf("--overwrite and --skip-existing are mutually exclusive")
+	}
+	if o.importFile == "-" && o.fromManifest == "-" {
+		return fmt.Errorf("the import file and the manifest can't both be read from stdin")
+	}
+	return nil
+}
+
+// runImport merges the users of the import file into every key.
+func (o *CommandOptions) runImport(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	if o.importData == nil {
+		var err error
+		if o.importFile == "-" {
+			o.importData, err = ioutil.ReadAll(o.In)
+		} else {
+			o.importData, err = ioutil.ReadFile(o.importFile)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %q: %v", o.importFile, err)
+		}
+	}
+	imported, err := newPasswordFile(o.importData, o.hasher, o.ignoreCase)
+	if err != nil {
+		return fmt.Errorf("%s: %v", o.importFile, err)
+	}
+	users, err := imported.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		added, updated, skipped := 0, 0, 0
+		for _, username := range users {
+			if _, exists := f.lookup(username); exists {
+				switch {
+				case o.skipExisting:
+					skipped++
+					continue
+				case !o.overwrite:
+					return fmt.Errorf("user %q already exists in key %q, use --overwrite or --skip-existing", username, f.key)
+				}
+				updated++
+			} else {
+				added++
+			}
+			f.SetHash(username, imported.passwords[username])
+			if !seen[username] {
+				seen[username] = true
+				changed = append(changed, username)
+			}
+		}
+		f.operation = "import"
+		secret.Data[f.key] = f.Bytes()
+		o.logf(logNormal, "Key %q: %d added, %d updated, %d skipped", f.key, added, updated, skipped)
+	}
+
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	o.recordChange(secret, "import", changed, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Imported %d users from %q%s", len(users), o.importFile, o.dryRunSuffix())
+	return o.printResults(files)
+}
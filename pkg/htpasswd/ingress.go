@@ -0,0 +1,186 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Annotations of the nginx ingress controller enabling basic auth.
+const (
+	nginxAuthType       = "nginx.ingress.kubernetes.io/auth-type"
+	nginxAuthSecret     = "nginx.ingress.kubernetes.io/auth-secret"
+	nginxAuthSecretType = "nginx.ingress.kubernetes.io/auth-secret-type"
+	nginxAuthRealm      = "nginx.ingress.kubernetes.io/auth-realm"
+)
+
+// nginxAuthKey is the key the nginx ingress controller reads the htpasswd
+// data from.
+const nginxAuthKey = "auth"
+
+const defaultRealm = "Authentication Required"
+
+// newAttachIngressCommand returns the attach-ingress subcommand which
+// enables basic auth with the secret on an nginx ingress.
+func newAttachIngressCommand(o *CommandOptions) *cobra.Command {
+	var realm string
+	cmd := &cobra.Command{
+		Use:   "attach-ingress SECRET INGRESS",
+		Short: "Protect an nginx ingress with basic auth using the secret",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateIngress(2); err != nil {
+				return validationError(err)
+			}
+			if len(o.keyNames) != 1 || o.keyNames[0] != nginxAuthKey {
+				return validationError(fmt.Errorf("nginx reads the htpasswd data from key %q", nginxAuthKey))
+			}
+			o.secretName = o.args[0]
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunAttachIngress(ctx, o.args[1], realm)
+		},
+	}
+	cmd.Flags().StringVarP(&realm, "realm", "", defaultRealm, "Realm shown by browsers when asking for the password")
+	o.addDryRunFlag(cmd)
+	return cmd
+}
+
+// newDetachIngressCommand returns the detach-ingress subcommand which
+// removes the basic auth annotations from an nginx ingress.
+func newDetachIngressCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "detach-ingress INGRESS",
+		Short: "Remove basic auth from an nginx ingress",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateIngress(1); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunDetachIngress(ctx, o.args[0])
+		},
+	}
+	o.addDryRunFlag(cmd)
+	return cmd
+}
+
+// validateIngress checks the flags and arguments shared by attach-ingress
+// and detach-ingress.
+func (o *CommandOptions) validateIngress(nargs int) error {
+	if err := o.validateDryRun(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("--from-manifest is not supported for ingresses")
+	}
+	if o.controller != "" && o.controller != "nginx" {
+		return fmt.Errorf("only the nginx ingress controller is supported, not %q", o.controller)
+	}
+	if len(o.args) != nargs {
+		return fmt.Errorf("expected %d arguments, got %d", nargs, len(o.args))
+	}
+	return nil
+}
+
+// RunAttachIngress points the auth annotations of the ingress at the secret.
+// The secret has to exist and hold htpasswd data in the key read by nginx.
+func (o *CommandOptions) RunAttachIngress(ctx context.Context, ingress, realm string) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("nginx expects htpasswd data in key %q, not a basic-auth secret", nginxAuthKey)
+	}
+	if !looksLikePasswordFile(secret.Data[nginxAuthKey]) {
+		return fmt.Errorf("key %q does not contain htpasswd data", nginxAuthKey)
+	}
+
+	annotations := map[string]interface{}{
+		nginxAuthType:       "basic",
+		nginxAuthSecret:     o.secretName,
+		nginxAuthSecretType: "auth-file",
+		nginxAuthRealm:      realm,
+	}
+	if err := o.patchIngress(ctx, ingress, annotations); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Ingress %q now requires a password from secret %q%s", ingress, o.secretName, o.dryRunSuffix())
+	return nil
+}
+
+// RunDetachIngress removes the auth annotations from the ingress.
+func (o *CommandOptions) RunDetachIngress(ctx context.Context, ingress string) error {
+	annotations := map[string]interface{}{
+		nginxAuthType:       nil,
+		nginxAuthSecret:     nil,
+		nginxAuthSecretType: nil,
+		nginxAuthRealm:      nil,
+	}
+	if err := o.patchIngress(ctx, ingress, annotations); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Removed basic auth from ingress %q%s", ingress, o.dryRunSuffix())
+	return nil
+}
+
+// patchIngress sends a merge patch of the annotations; nil values remove an
+// annotation. With --dry-run=client the patch is only printed.
+func (o *CommandOptions) patchIngress(ctx context.Context, name string, annotations map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	if o.dryRun == dryRunClient {
+		fmt.Fprintln(o.Out, string(patch))
+		return nil
+	}
+
+	c := o.secrets()
+	opts := &metav1.PatchOptions{DryRun: c.dryRun}
+	result := &networkingv1beta1.Ingress{}
+	err = c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			// the fake clientset, see secretsClient
+			if _, err := c.typedCore(ctx, true); err != nil {
+				return err
+			}
+			_, err := o.clientset.NetworkingV1beta1().Ingresses(o.namespace).Patch(name, types.MergePatchType, patch)
+			return err
+		}
+		return o.clientset.NetworkingV1beta1().RESTClient().Patch(types.MergePatchType).
+			Context(ctx).
+			Namespace(o.namespace).
+			Resource("ingresses").
+			Name(name).
+			VersionedParams(opts, scheme.ParameterCodec).
+			Body(patch).
+			Do().
+			Into(result)
+	})
+	if apierrors.IsNotFound(err) {
+		return notFoundError("ingress %q not found in namespace %q", name, o.namespace)
+	} else if err != nil {
+		return apiError(err, "unable to patch ingress %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// newKeysCommand returns the keys subcommand which lists the keys of a
+// secret holding htpasswd data. The listed names can be passed to
+// --key-name to work on several keys at once.
+func newKeysCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys SECRET",
+		Short: "List the keys of a secret containing htpasswd data",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if o.fromManifest == "" {
+				if len(o.args) != 1 {
+					return validationError(fmt.Errorf("secret is required"))
+				}
+				o.secretName = o.args[0]
+			}
+			switch o.output {
+			case "", "name":
+			default:
+				return validationError(fmt.Errorf("unsupported output format %q, must be: name", o.output))
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunKeys(ctx)
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: name")
+	return cmd
+}
+
+// RunKeys prints the keys of the secret which look like htpasswd data
+// together with the number of users they contain.
+func (o *CommandOptions) RunKeys(ctx context.Context) error {
+	// Every key is inspected, so none has to exist.
+	o.keyNames = nil
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("keys only supports htpasswd data, not basic-auth secrets")
+	}
+
+	var keys []string
+	for key, data := range secret.Data {
+		if len(data) > 0 && looksLikePasswordFile(data) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return notFoundError("secret %q has no key containing htpasswd data", secret.Name)
+	}
+
+	if o.output == "name" {
+		for _, key := range keys {
+			fmt.Fprintln(o.Out, key)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tUSERS")
+	for _, key := range keys {
+		htpasswd, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		users, err := htpasswd.ListUsers()
+		if err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		fmt.Fprintf(w, "%s\t%d\n", key, len(users))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,156 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// lastModifiedAnnotation records when the plugin last changed a secret.
+const lastModifiedAnnotation = "htpasswd.kubectl.io/last-modified"
+
+// userList is the structured output of list.
+type userList struct {
+	Secret       string     `json:"secret"`
+	Namespace    string     `json:"namespace"`
+	LastModified string     `json:"lastModified,omitempty"`
+	Keys         []keyUsers `json:"keys"`
+}
+
+type keyUsers struct {
+	Key   string     `json:"key"`
+	Users []userInfo `json:"users"`
+}
+
+type userInfo struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Details   string `json:"details,omitempty"`
+	Strength  string `json:"strength,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+const (
+	strengthWeak   = "weak"
+	strengthStrong = "strong"
+)
+
+// newUserInfo describes the hash of a user.
+func newUserInfo(name, hash string) userInfo {
+	info := userInfo{Name: name, Algorithm: hashAlgorithm(hash)}
+	info.Details, info.Strength = hashStrength(hash)
+	return info
+}
+
+// hashStrength returns a description of the algorithm and its parameters
+// and assesses whether the hash withstands offline brute forcing. Unsalted
+// or fast hashes like SHA-1, crypt and the MD5 based ones are weak, as is
+// bcrypt below its default cost.
+func hashStrength(hash string) (string, string) {
+	switch algorithm := hashAlgorithm(hash); algorithm {
+	case "bcrypt":
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return "bcrypt, malformed", strengthWeak
+		}
+		if cost < bcrypt.DefaultCost {
+			return fmt.Sprintf("bcrypt cost %d", cost), strengthWeak
+		}
+		return fmt.Sprintf("bcrypt cost %d", cost), strengthStrong
+	case "sha256-crypt", "sha512-crypt":
+		return algorithm, strengthStrong
+	case "argon2id":
+		p, err := parseArgon2(hash)
+		if err != nil {
+			return "argon2id, malformed", strengthWeak
+		}
+		return fmt.Sprintf("argon2id m=%d,t=%d,p=%d", p.memory, p.iterations, p.parallelism), strengthStrong
+	case "sha1":
+		return "SHA-1, unsalted", strengthWeak
+	case "crypt":
+		return "crypt, 8 characters significant", strengthWeak
+	case "digest":
+		realm := strings.SplitN(hash, ":", 2)[0]
+		return fmt.Sprintf("MD5 digest, realm %q", realm), strengthWeak
+	case "":
+		return "unknown", ""
+	default:
+		return algorithm, strengthWeak
+	}
+}
+
+// validateListOutput checks the output formats of list.
+func (o *CommandOptions) validateListOutput() error {
+	switch o.output {
+	case "", "json", "yaml", "name", "wide":
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q, must be one of: json, yaml, name, wide", o.output)
+}
+
+// printUserList prints the users of secret in the format of --output. With
+// "name" only the usernames are printed, one per line, "wide" prints a table
+// including the hash strength.
+func (o *CommandOptions) printUserList(secret *v1.Secret, keys []keyUsers) error {
+	switch o.output {
+	case "name":
+		for _, k := range keys {
+			for _, u := range k.Users {
+				fmt.Fprintln(o.Out, u.Name)
+			}
+		}
+		return nil
+	case "wide":
+		w := tabwriter.NewWriter(o.Out, 0, 8, 2, ' ', 0)
+		if len(keys) > 1 {
+			fmt.Fprintln(w, "KEY\tNAME\tALGORITHM\tSTRENGTH\tEXPIRES\tCOMMENT")
+		} else {
+			fmt.Fprintln(w, "NAME\tALGORITHM\tSTRENGTH\tEXPIRES\tCOMMENT")
+		}
+		for _, k := range keys {
+			for _, u := range k.Users {
+				if len(keys) > 1 {
+					fmt.Fprintf(w, "%s\t", k.Key)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Name, u.Details, u.Strength, orNone(u.Expires), u.Comment)
+			}
+		}
+		return w.Flush()
+	}
+
+	list := userList{
+		Secret:       secret.Name,
+		Namespace:    secret.Namespace,
+		LastModified: secret.Annotations[lastModifiedAnnotation],
+		Keys:         keys,
+	}
+	var data []byte
+	var err error
+	if o.output == "json" {
+		data, err = json.MarshalIndent(list, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(list)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = o.Out.Write(data)
+	return err
+}
+
+// setLastModified stamps secret with the current time.
+func setLastModified(secret *v1.Secret) {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[lastModifiedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+}
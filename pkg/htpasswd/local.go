@@ -0,0 +1,146 @@
+package htpasswd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// localFileMode is the permission of htpasswd files created by the local
+// subcommands.
+const localFileMode = 0600
+
+// newLocalCommand returns the local subcommand whose subcommands edit an
+// htpasswd file, or stdin, with the same logic as the secret operations but
+// without any API requests.
+func newLocalCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "local",
+		Short: "Edit a local htpasswd file without a cluster",
+		Long: `Edit a local htpasswd file without a cluster.
+
+With "--file -", the default, the htpasswd data is read from stdin and the
+result is written to stdout, e.g. to build manifests in air-gapped pipelines.
+"local add" creates the file if it doesn't exist.`,
+		PersistentPreRun: func(c *cobra.Command, args []string) {
+			o.local = true
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&o.localFile, "file", "f", "-", "htpasswd file to edit, - reads stdin and writes the result to stdout")
+
+	add := newSubcommand(o, "add [<username>|-u <username>]", "Add a user or change the password of an existing user", func() {})
+	add.Flags().BoolVarP(&o.appendOnly, "append-only", "", false, "Only allow adding new users, never change existing ones")
+	o.addAllowUnicodeFlag(add)
+	o.addUsernameFlag(add)
+	o.addPasswordFlags(add)
+	o.addGenerateFlags(add)
+	o.addPolicyFlags(add)
+	o.addHashFlags(add)
+
+	del := newSubcommand(o, "delete [<username>...|-u <username>|--all]", "Delete users", func() {
+		o.deleteUser = true
+	})
+	del.Flags().BoolVarP(&o.printOnly, "print-only", "", false, "Only print the entries that would be removed")
+	del.Flags().BoolVarP(&o.deleteAll, "all", "", false, "Delete all users")
+	o.addYesFlag(del)
+	o.addUsernameFlag(del)
+
+	list := newSubcommand(o, "list", "List the users of the file", func() {
+		o.listUsers = true
+	})
+	list.Flags().StringVarP(&o.sortBy, "sort", "", "name", "Order of listed users. One of: name, algorithm")
+	list.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: json, yaml, name, wide")
+
+	verify := newSubcommand(o, "verify [<username>|-u <username>]", "Check the password of a user", func() {
+		o.verify = true
+	})
+	o.addUsernameFlag(verify)
+	o.addPasswordFlags(verify)
+	o.addInsecureAllowFlag(verify)
+
+	rename := newSubcommand(o, "rename OLDNAME NEWNAME", "Rename a user, keeping its password", func() {
+		o.renaming = true
+	})
+	o.addAllowUnicodeFlag(rename)
+
+	rehash := newSubcommand(o, "rehash [<username>|-u <username>]", "Hash the current password of a user again with a stronger algorithm", func() {
+		o.rehash = true
+	})
+	o.addUsernameFlag(rehash)
+	o.addPasswordFlags(rehash)
+	o.addHashFlags(rehash)
+
+	cmd.AddCommand(add, del, list, verify, rename, rehash)
+	return cmd
+}
+
+// validateLocal checks the flags of the local subcommands. The file takes
+// the place of the single key of a secret.
+func (o *CommandOptions) validateLocal() error {
+	if !o.local {
+		return nil
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("--from-manifest can't be combined with local files")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("local files only hold htpasswd data, not basic-auth secrets")
+	}
+	if o.localFile == "" {
+		return fmt.Errorf("--file is required, use - for stdin")
+	}
+	key := o.localFile
+	if key == "-" {
+		key = "stdin"
+	}
+	o.keyNames = []string{key}
+	return nil
+}
+
+// stdinTaken reports whether stdin carries the data being edited, either a
+// manifest or a local htpasswd file, and can't be used for passwords.
+func (o *CommandOptions) stdinTaken() bool {
+	return o.fromManifest == "-" || (o.local && o.localFile == "-")
+}
+
+// readLocalFile wraps the htpasswd file in a secret, so the local
+// subcommands share the operations on secrets. A missing file is only
+// accepted when a password is set.
+func (o *CommandOptions) readLocalFile() (*v1.Secret, error) {
+	var data []byte
+	var err error
+	o.localMode = localFileMode
+	if o.localFile == "-" {
+		data, err = ioutil.ReadAll(o.In)
+	} else if info, statErr := os.Stat(o.localFile); statErr == nil {
+		o.localMode = info.Mode().Perm()
+		data, err = ioutil.ReadFile(o.localFile)
+	} else if !os.IsNotExist(statErr) || !o.setsPassword() {
+		err = statErr
+	}
+	if os.IsNotExist(err) {
+		return nil, notFoundError("file %q does not exist", o.localFile)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", o.localFile, err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: o.localFile},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{o.keyNames[0]: data},
+	}, nil
+}
+
+// writeLocalFile replaces the htpasswd file with data or writes it to
+// stdout.
+func (o *CommandOptions) writeLocalFile(data []byte) error {
+	if o.localFile == "-" {
+		_, err := o.Out.Write(data)
+		return err
+	}
+	return writeFileAtomic(o.localFile, data, o.localMode)
+}
@@ -0,0 +1,59 @@
+package htpasswd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// Verbosity levels of logf. --quiet lowers the verbosity below
+// logNormal so only warnings, errors and the requested output remain.
+const (
+	logNormal   = 0
+	logDetails  = 1
+	logRequests = 2
+)
+
+// validateVerbosity applies --quiet.
+func (o *CommandOptions) validateVerbosity() error {
+	if !o.quiet {
+		return nil
+	}
+	if o.verbosity > 0 {
+		return fmt.Errorf("--quiet and --verbose are mutually exclusive")
+	}
+	o.verbosity = logNormal - 1
+	return nil
+}
+
+// wrapTransport makes config log every API request at the logRequests level.
+func (o *CommandOptions) wrapTransport(config *rest.Config) {
+	if o.verbosity < logRequests {
+		return
+	}
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, func(rt http.RoundTripper) http.RoundTripper {
+		return &loggingRoundTripper{o: o, rt: rt}
+	})
+}
+
+// loggingRoundTripper prints the method, URL, status and duration of each
+// request.
+type loggingRoundTripper struct {
+	o  *CommandOptions
+	rt http.RoundTripper
+}
+
+func (l *loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := l.rt.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		l.o.logf(logRequests, "%s %s failed after %v: %v", req.Method, req.URL, elapsed, err)
+		return resp, err
+	}
+	l.o.logf(logRequests, "%s %s %s in %v", req.Method, req.URL, resp.Status, elapsed)
+	return resp, err
+}
@@ -0,0 +1,134 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+func (o *CommandOptions) addDryRunFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.dryRun, "dry-run", "", "", `Print the resulting secret instead of saving it. One of: client, server. "server" submits a server-side dry run request`)
+	cmd.Flags().Lookup("dry-run").NoOptDefVal = dryRunClient
+}
+
+// validateDryRun checks --dry-run and the output formats allowed with it.
+func (o *CommandOptions) validateDryRun() error {
+	switch o.dryRun {
+	case "", dryRunClient, dryRunServer:
+	default:
+		return fmt.Errorf("invalid --dry-run value %q, must be %q or %q", o.dryRun, dryRunClient, dryRunServer)
+	}
+	if o.dryRun != "" {
+		if o.fromManifest != "" {
+			return fmt.Errorf("--dry-run can't be combined with --from-manifest")
+		}
+		if o.output != "" && o.output != "json" && o.output != "yaml" {
+			return fmt.Errorf("unsupported output format %q, must be json or yaml", o.output)
+		}
+		return nil
+	}
+	if o.listUsers {
+		return o.validateListOutput()
+	}
+	if o.output != "" && o.output != "json" {
+		return fmt.Errorf("unsupported output format %q", o.output)
+	}
+	return nil
+}
+
+// dryRunSuffix is appended to status messages during dry runs and when
+// only a manifest is written.
+func (o *CommandOptions) dryRunSuffix() string {
+	if o.outputManifest != "" {
+		return " (manifest only)"
+	}
+	if o.dryRun == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (dry run: %s)", o.dryRun)
+}
+
+// printSecret prints secret to stdout as YAML or, with -o json, as JSON.
+func (o *CommandOptions) printSecret(secret *v1.Secret) error {
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	var data []byte
+	var err error
+	if o.output == "json" {
+		data, err = json.MarshalIndent(secret, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(secret)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = o.Out.Write(data)
+	return err
+}
+
+// cleanManifest returns a copy of secret without server populated fields
+// like resourceVersion, uid and creationTimestamp.
+func cleanManifest(secret *v1.Secret) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secret.Name,
+			Namespace:   secret.Namespace,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+}
+
+// writeManifest writes the secret as YAML to path. Server populated fields
+// are dropped so the file can be committed to version control.
+func writeManifest(path string, secret *v1.Secret) error {
+	data, err := yaml.Marshal(cleanManifest(secret))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write manifest: %v", err)
+	}
+	return nil
+}
+
+// readManifest decodes the secret given by --from-manifest.
+func (o *CommandOptions) readManifest() (*v1.Secret, error) {
+	var data []byte
+	var err error
+	if o.fromManifest == "-" {
+		data, err = ioutil.ReadAll(o.In)
+	} else {
+		data, err = ioutil.ReadFile(o.fromManifest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %v", err)
+	}
+
+	secret := &v1.Secret{}
+	if err := yaml.Unmarshal(data, secret); err != nil {
+		return nil, fmt.Errorf("unable to decode manifest: %v", err)
+	}
+	if secret.Kind != "Secret" {
+		return nil, fmt.Errorf("manifest contains a %q, expected a Secret", secret.Kind)
+	}
+	return secret, nil
+}
@@ -0,0 +1,129 @@
+package htpasswd
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+const (
+	apr1Magic     = "$apr1$"
+	md5CryptMagic = "$1$"
+
+	// cryptAlphabet is the base64 variant used by crypt(3).
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// apr1Hasher creates $apr1$ entries, Apache's variant of md5-crypt and the
+// default of Apache htpasswd.
+type apr1Hasher struct{}
+
+func (apr1Hasher) Hash(password string) (string, error) {
+	salt, err := cryptSalt(8)
+	if err != nil {
+		return "", err
+	}
+	return md5Crypt(password, salt, apr1Magic), nil
+}
+
+// verifyMD5Crypt checks password against an $apr1$ or $1$ hash.
+func verifyMD5Crypt(hash, password string) (bool, error) {
+	magic := apr1Magic
+	if strings.HasPrefix(hash, md5CryptMagic) {
+		magic = md5CryptMagic
+	}
+	parts := strings.SplitN(strings.TrimPrefix(hash, magic), "$", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("malformed md5-crypt hash")
+	}
+	expected := md5Crypt(password, parts[0], magic)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1, nil
+}
+
+// md5Crypt implements the md5-crypt algorithm by Poul-Henning Kamp.
+func md5Crypt(password, salt, magic string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var buf strings.Builder
+	buf.WriteString(magic)
+	buf.WriteString(salt)
+	buf.WriteByte('$')
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[g[0]])<<16 | uint(final[g[1]])<<8 | uint(final[g[2]])
+		cryptEncode(&buf, v, 4)
+	}
+	cryptEncode(&buf, uint(final[11]), 2)
+	return buf.String()
+}
+
+// cryptEncode appends the n lowest 6 bit groups of v to buf.
+func cryptEncode(buf *strings.Builder, v uint, n int) {
+	for ; n > 0; n-- {
+		buf.WriteByte(cryptAlphabet[v&0x3f])
+		v >>= 6
+	}
+}
+
+// cryptSalt returns a random salt of n characters from cryptAlphabet.
+func cryptSalt(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = cryptAlphabet[int(b[i])%len(cryptAlphabet)]
+	}
+	return string(b), nil
+}
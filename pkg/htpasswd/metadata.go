@@ -0,0 +1,151 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// managedByAnnotation marks secrets changed by the plugin.
+	managedByAnnotation = "htpasswd.kubectl.io/managed-by"
+	managedByValue      = "kubectl-htpasswd"
+	// ownerAnnotation holds the UID of the owner set with --owner, which
+	// doesn't count as another controller managing the secret.
+	ownerAnnotation = "htpasswd.kubectl.io/owner"
+)
+
+func (o *CommandOptions) addMetadataFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&o.labels, "label", "", nil, "Label key=value of a created secret, may be repeated")
+	cmd.Flags().StringArrayVarP(&o.annotations, "annotation", "", nil, "Annotation key=value of a created secret, may be repeated")
+	cmd.Flags().StringVarP(&o.owner, "owner", "", "", "Owner of a created secret as kind/name in the same namespace, e.g. deployment/gateway")
+}
+
+// validateMetadata checks the metadata flags of created secrets.
+func (o *CommandOptions) validateMetadata() error {
+	if (len(o.labels) > 0 || len(o.annotations) > 0 || o.owner != "") && !o.createSecret {
+		return fmt.Errorf("--label, --annotation and --owner require create")
+	}
+	for _, l := range o.labels {
+		key, value, err := splitKeyValue(l)
+		if err != nil {
+			return fmt.Errorf("invalid --label: %v", err)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	for _, a := range o.annotations {
+		if _, _, err := splitKeyValue(a); err != nil {
+			return fmt.Errorf("invalid --annotation: %v", err)
+		}
+	}
+	if o.owner != "" && len(strings.Split(o.owner, "/")) != 2 {
+		return fmt.Errorf("invalid --owner %q, must be kind/name", o.owner)
+	}
+	return nil
+}
+
+// splitKeyValue splits a key=value flag value and validates the key.
+func splitKeyValue(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%q must be key=value", s)
+	}
+	if errs := validation.IsQualifiedName(parts[0]); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid key %q: %s", parts[0], strings.Join(errs, ", "))
+	}
+	return parts[0], parts[1], nil
+}
+
+// setCreateMetadata adds the labels, annotations and owner reference given
+// on the command line to a new secret.
+func (o *CommandOptions) setCreateMetadata(ctx context.Context, secret *v1.Secret) error {
+	for _, l := range o.labels {
+		key, value, _ := splitKeyValue(l)
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+		secret.Labels[key] = value
+	}
+	for _, a := range o.annotations {
+		key, value, _ := splitKeyValue(a)
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[key] = value
+	}
+	if o.owner == "" {
+		return nil
+	}
+	ref, err := o.ownerReference(ctx)
+	if err != nil {
+		return err
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{*ref}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[ownerAnnotation] = string(ref.UID)
+	return nil
+}
+
+// ownerReference looks up the object given by --owner. The reference isn't
+// a controller reference, so the plugin can still edit the secret without
+// --force, but the secret is garbage collected together with its owner.
+func (o *CommandOptions) ownerReference(ctx context.Context) (*metav1.OwnerReference, error) {
+	if o.restConfig == nil {
+		return nil, fmt.Errorf("--owner requires a cluster connection")
+	}
+	parts := strings.Split(o.owner, "/")
+	mapper, err := o.configFlags.ToRESTMapper()
+	if err != nil {
+		return nil, err
+	}
+	gvk, err := mapper.KindFor(schema.GroupVersionResource{Resource: parts[0]})
+	if err != nil {
+		return nil, fmt.Errorf("unknown owner kind %q: %v", parts[0], err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(o.restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	obj, err := client.Resource(mapping.Resource).Namespace(o.namespace).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, apiError(err, "unable to get owner %q", o.owner)
+	}
+	return &metav1.OwnerReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+	}, nil
+}
+
+// setManagedBy records that the plugin changed secret, along with its
+// version, e.g. kubectl-htpasswd/v1.2.3.
+func setManagedBy(secret *v1.Secret) {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	value := managedByValue
+	if v := buildVersion(); v != "" {
+		value += "/" + v
+	}
+	secret.Annotations[managedByAnnotation] = value
+}
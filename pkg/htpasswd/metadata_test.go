@@ -0,0 +1,40 @@
+package htpasswd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOwnerDoesNotNeedForce(t *testing.T) {
+	c := newTestCluster(t, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "gateway", UID: "d-1234"}})
+	defer c.close()
+	// --owner looks up the owner with the REST mapper and the dynamic
+	// client, which connect to the server of the kubeconfig
+	c.writeFile("kubeconfig", strings.Replace(testKubeconfig, "https://kubernetes.invalid", c.server.URL, 1))
+	cacheDir := "--cache-dir=" + filepath.Join(c.dir, "cache")
+
+	c.mustRun("secret1\n", cacheDir, "create", "gateway-auth", "alice", "--password-stdin", "--owner", "deployment/gateway")
+	secret := c.secret("gateway-auth")
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != "d-1234" || secret.OwnerReferences[0].Kind != "Deployment" {
+		t.Fatalf("owner references = %v, want deployment gateway", secret.OwnerReferences)
+	}
+
+	c.mustRun("secret2\n", "add", "gateway-auth", "bob", "--password-stdin")
+	if users := parseTestUsers(t, c.secret("gateway-auth").Data["auth"]); strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("users = %v, want [alice bob]", users)
+	}
+
+	// an owner added by someone else still needs --force
+	c.mu.Lock()
+	owned := c.objects[objectKey("secrets", testNamespace, "gateway-auth")]
+	owned.(*v1.Secret).OwnerReferences = append(owned.(*v1.Secret).OwnerReferences, metav1.OwnerReference{APIVersion: "argoproj.io/v1alpha1", Kind: "Application", Name: "gateway", UID: "a-5678"})
+	c.mu.Unlock()
+	if _, _, err := c.run("secret3\n", "add", "gateway-auth", "carol", "--password-stdin"); err == nil || !strings.Contains(err.Error(), `managed by Application "gateway"`) {
+		t.Errorf("add to a secret with a foreign owner = %v, want --force asked for", err)
+	}
+}
@@ -0,0 +1,134 @@
+package htpasswd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// secretMetrics are the values written to --metrics-file for one secret.
+type secretMetrics struct {
+	namespace, name string
+	keys            []keyMetrics
+	// lastRotation is the time of the last rotate in the history, zero if
+	// there is none.
+	lastRotation time.Time
+}
+
+type keyMetrics struct {
+	key         string
+	users, weak int
+}
+
+// collectMetrics remembers the state of secret after a successful operation
+// for writeMetrics. Dry runs and manifests don't reflect the cluster and are
+// left out.
+func (o *CommandOptions) collectMetrics(secret *v1.Secret) {
+	if o.metricsFile == "" || o.dryRun != "" || o.fromManifest != "" || o.outputManifest != "" {
+		return
+	}
+	m := secretMetrics{namespace: secret.Namespace, name: secret.Name}
+	if m.namespace == "" {
+		m.namespace = o.namespace
+	}
+	if secret.Type != v1.SecretTypeBasicAuth {
+		for _, key := range o.keyNames {
+			f, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+			if err != nil {
+				continue
+			}
+			km := keyMetrics{key: key, users: len(f.passwords)}
+			for _, hash := range f.passwords {
+				if isWeakHash(hash) {
+					km.weak++
+				}
+			}
+			m.keys = append(m.keys, km)
+		}
+	}
+	history, _ := readHistory(secret)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Operation != "rotate" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, history[i].Time); err == nil {
+			m.lastRotation = t
+		}
+		break
+	}
+	o.metrics = append(o.metrics, m)
+}
+
+// writeMetrics replaces --metrics-file with the collected metrics in the
+// Prometheus text format read by the textfile collector of node_exporter.
+// Nothing is written if no operation succeeded, so the modification time of
+// the file tells when the last successful run happened.
+func (o *CommandOptions) writeMetrics() error {
+	if o.metricsFile == "" || len(o.metrics) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP kubectl_htpasswd_users_total Number of users in the secret key.")
+	fmt.Fprintln(&buf, "# TYPE kubectl_htpasswd_users_total gauge")
+	for _, m := range o.metrics {
+		for _, k := range m.keys {
+			fmt.Fprintf(&buf, "kubectl_htpasswd_users_total%s %d\n", metricLabels(m, k.key), k.users)
+		}
+	}
+	fmt.Fprintln(&buf, "# HELP kubectl_htpasswd_weak_hashes_total Number of users in the secret key with a weak hash algorithm.")
+	fmt.Fprintln(&buf, "# TYPE kubectl_htpasswd_weak_hashes_total gauge")
+	for _, m := range o.metrics {
+		for _, k := range m.keys {
+			fmt.Fprintf(&buf, "kubectl_htpasswd_weak_hashes_total%s %d\n", metricLabels(m, k.key), k.weak)
+		}
+	}
+	fmt.Fprintln(&buf, "# HELP kubectl_htpasswd_last_rotation_timestamp_seconds Time of the last password rotation of the secret.")
+	fmt.Fprintln(&buf, "# TYPE kubectl_htpasswd_last_rotation_timestamp_seconds gauge")
+	for _, m := range o.metrics {
+		if !m.lastRotation.IsZero() {
+			fmt.Fprintf(&buf, "kubectl_htpasswd_last_rotation_timestamp_seconds%s %d\n", metricLabels(m, ""), m.lastRotation.Unix())
+		}
+	}
+
+	// node_exporter may read the file at any time, so it is replaced
+	// atomically by renaming a temporary file next to it.
+	tmp, err := ioutil.TempFile(filepath.Dir(o.metricsFile), "."+filepath.Base(o.metricsFile)+"-*")
+	if err != nil {
+		return fmt.Errorf("unable to write metrics: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write metrics: %v", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write metrics: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write metrics: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), o.metricsFile); err != nil {
+		return fmt.Errorf("unable to write metrics: %v", err)
+	}
+	o.logf(logDetails, "Wrote metrics to %s", o.metricsFile)
+	return nil
+}
+
+// metricLabels returns the label set of a series, key is left out if empty.
+func metricLabels(m secretMetrics, key string) string {
+	labels := []string{
+		fmt.Sprintf("namespace=%q", m.namespace),
+		fmt.Sprintf("secret=%q", m.name),
+	}
+	if key != "" {
+		labels = append(labels, fmt.Sprintf("key=%q", key))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
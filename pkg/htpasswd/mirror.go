@@ -0,0 +1,135 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mirrorSecret is the written state of the --mirror secret, kept to undo
+// the write if saving the primary secret fails.
+type mirrorSecret struct {
+	client   *secretsClient
+	result   *v1.Secret
+	previous *v1.Secret
+}
+
+func (o *CommandOptions) addMirrorFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.mirror, "mirror", "", "", "Also write the htpasswd data to this [NAMESPACE/]SECRET, e.g. for blue/green ingresses")
+}
+
+// validateMirror checks --mirror.
+func (o *CommandOptions) validateMirror() error {
+	if o.mirror == "" {
+		return nil
+	}
+	switch {
+	case o.local || o.fromManifest != "" || o.outputManifest != "":
+		return fmt.Errorf("--mirror requires a cluster, it can't be combined with local files or manifests")
+	case o.selector != "" || o.shards > 0:
+		return fmt.Errorf("--mirror works on a single secret, it can't be combined with --selector or --shards")
+	case o.moveKey != "":
+		return fmt.Errorf("--mirror can't be combined with --move-key")
+	case namespacedName(o.mirror, o.namespace) == namespacedName(o.secretName, o.namespace):
+		return fmt.Errorf("--mirror must differ from the secret")
+	}
+	return nil
+}
+
+// writeMirror stores the keys of secret written by the operation in the
+// --mirror secret, creating it if needed.
+func (o *CommandOptions) writeMirror(ctx context.Context, secret *v1.Secret) (*mirrorSecret, error) {
+	client := o.secrets()
+	var name string
+	client.ns, name = splitNamespacedName(o.mirror, o.namespace)
+	existing, err := client.Get(ctx, name)
+	m := &mirrorSecret{client: client}
+	switch {
+	case apierrors.IsNotFound(err):
+		existing = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: client.ns},
+			Type:       secret.Type,
+		}
+	case err != nil:
+		return nil, apiError(err, "unable to get mirror secret %q", o.mirror)
+	case existing.Type != secret.Type:
+		return nil, fmt.Errorf("invalid mirror secret type %q, expected %q", existing.Type, secret.Type)
+	default:
+		m.previous = existing.DeepCopy()
+		if manager := managedBy(existing); manager != "" {
+			if !o.force {
+				return nil, fmt.Errorf("mirror secret %q is managed by %s, use --force to write it anyway", o.mirror, manager)
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: mirror secret %q is managed by %s\n", o.mirror, manager)
+		}
+	}
+	if existing.Data == nil {
+		existing.Data = make(map[string][]byte)
+	}
+	for _, key := range o.managedKeys(secret) {
+		if data, ok := secret.Data[key]; ok {
+			existing.Data[key] = data
+		}
+	}
+	if key, ok := secret.Annotations[usersKeyAnnotation]; ok {
+		if existing.Annotations == nil {
+			existing.Annotations = make(map[string]string)
+		}
+		existing.Annotations[usersKeyAnnotation] = key
+	}
+	o.recordChange(existing, "mirror", nil, "")
+	setManagedBy(existing)
+	setLastModified(existing)
+
+	if m.previous == nil {
+		m.result, err = client.Create(ctx, existing)
+	} else {
+		m.result, err = client.Update(ctx, existing)
+	}
+	if err != nil {
+		return nil, apiError(err, "unable to save mirror secret %q", o.mirror)
+	}
+	o.logf(logNormal, "Mirrored to secret %s/%s%s", client.ns, name, o.dryRunSuffix())
+	return m, nil
+}
+
+// restoreMirror undoes writeMirror after saving the primary secret failed,
+// so both secrets keep holding the same users.
+func (o *CommandOptions) restoreMirror(ctx context.Context, m *mirrorSecret) {
+	var err error
+	if m.previous == nil {
+		err = m.client.Delete(ctx, m.result.Name)
+	} else {
+		m.previous.ResourceVersion = m.result.ResourceVersion
+		_, err = m.client.Update(ctx, m.previous)
+	}
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "Warning: unable to restore mirror secret %s/%s, it differs from the secret now: %v\n", m.client.ns, m.result.Name, err)
+	}
+}
+
+// Delete deletes the secret.
+func (c *secretsClient) Delete(ctx context.Context, name string) error {
+	return c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, true)
+			if err != nil {
+				return err
+			}
+			return typed.Secrets(c.ns).Delete(name, &metav1.DeleteOptions{})
+		}
+		return c.client.Delete().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			Name(name).
+			Body(&metav1.DeleteOptions{DryRun: c.dryRun}).
+			Do().
+			Error()
+	})
+}
@@ -0,0 +1,311 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Keys of the ConfigMap written by emit-nginx, named after the nginx
+// context they have to be included in.
+const (
+	nginxHTTPKey   = "http.conf"
+	nginxServerKey = "server.conf"
+)
+
+var (
+	// nginxRate is the rate syntax of limit_req_zone.
+	nginxRate = regexp.MustCompile(`^[1-9][0-9]*r/[sm]$`)
+	// nginxInvalidName matches the characters not allowed in variable
+	// and zone names.
+	nginxInvalidName = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+// nginxOptions holds the flags of the emit-nginx subcommand.
+type nginxOptions struct {
+	realm     string
+	filePath  string
+	allow     []string
+	rateLimit string
+	burst     int
+	configMap string
+
+	// locations are the allowed locations per user parsed from allow.
+	locations map[string][]string
+}
+
+// newEmitNginxCommand returns the emit-nginx subcommand which renders the
+// nginx configuration for a secret.
+func newEmitNginxCommand(o *CommandOptions) *cobra.Command {
+	var n nginxOptions
+	cmd := &cobra.Command{
+		Use:   "emit-nginx SECRET",
+		Short: "Render an nginx auth_basic config snippet for the secret",
+		Long: `Render an nginx auth_basic config snippet for the secret.
+
+The snippet has two parts: ` + nginxHTTPKey + ` belongs into the http context and
+holds the maps and zones, ` + nginxServerKey + ` into the server or location
+context protected by the secret, mounted at --file-path. With --configmap both
+are stored as keys of a ConfigMap for nginx sidecars, otherwise they are
+printed.
+
+--allow restricts users to locations, e.g. --allow alice=/admin,/metrics.
+Once it is given only the listed users get access, --allow bob=/ lets a user
+in everywhere. --rate-limit limits the requests per user.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateEmitNginx(&n); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunEmitNginx(ctx, &n)
+		},
+	}
+	cmd.Flags().StringVarP(&n.realm, "realm", "", defaultRealm, "Realm shown by the browser")
+	cmd.Flags().StringVarP(&n.filePath, "file-path", "", "", "Path of the htpasswd file in the nginx container, defaults to /etc/nginx/htpasswd/KEY")
+	cmd.Flags().StringArrayVarP(&n.allow, "allow", "", nil, "Only allow USER=LOCATION[,LOCATION...], may be repeated")
+	cmd.Flags().StringVarP(&n.rateLimit, "rate-limit", "", "", "Limit the requests per user, e.g. 10r/s")
+	cmd.Flags().IntVarP(&n.burst, "burst", "", 0, "Requests per user exceeding --rate-limit which are still served")
+	cmd.Flags().StringVarP(&n.configMap, "configmap", "", "", "Store the snippet in this ConfigMap instead of printing it")
+	return cmd
+}
+
+// validateEmitNginx checks the flags and arguments of the emit-nginx
+// subcommand.
+func (o *CommandOptions) validateEmitNginx(n *nginxOptions) error {
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if len(o.keyNames) != 1 {
+		return fmt.Errorf("emit-nginx reads a single key, got %d", len(o.keyNames))
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("nginx needs htpasswd data, not a basic-auth secret")
+	}
+	if len(o.args) != 1 {
+		return fmt.Errorf("secret is required")
+	}
+	o.secretName = o.args[0]
+	if n.configMap != "" && o.fromManifest != "" {
+		return fmt.Errorf("--configmap requires a cluster, it can't be combined with --from-manifest")
+	}
+	if n.filePath == "" {
+		n.filePath = "/etc/nginx/htpasswd/" + o.keyNames[0]
+	}
+	for _, value := range []string{n.realm, n.filePath} {
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("--realm and --file-path must be a non-empty single line")
+		}
+	}
+	if n.rateLimit != "" && !nginxRate.MatchString(n.rateLimit) {
+		return fmt.Errorf("invalid --rate-limit %q, must be like 10r/s or 600r/m", n.rateLimit)
+	}
+	if n.burst < 0 {
+		return fmt.Errorf("--burst must not be negative")
+	} else if n.burst > 0 && n.rateLimit == "" {
+		return fmt.Errorf("--burst requires --rate-limit")
+	}
+
+	n.locations = make(map[string][]string)
+	for _, a := range n.allow {
+		i := strings.Index(a, "=")
+		if i <= 0 || i == len(a)-1 {
+			return fmt.Errorf("invalid --allow %q, must be USER=LOCATION[,LOCATION...]", a)
+		}
+		user := a[:i]
+		for _, location := range strings.Split(a[i+1:], ",") {
+			if !strings.HasPrefix(location, "/") || strings.ContainsAny(location, "\r\n \t") {
+				return fmt.Errorf("invalid location %q of user %q, must be a path starting with /", location, user)
+			}
+			n.locations[user] = append(n.locations[user], location)
+		}
+	}
+	return nil
+}
+
+// RunEmitNginx renders the snippet for the secret and prints it or stores
+// it in the ConfigMap.
+func (o *CommandOptions) RunEmitNginx(ctx context.Context, n *nginxOptions) error {
+	secret, err := o.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+	key := o.keyNames[0]
+	data, ok := secret.Data[key]
+	if !ok {
+		return notFoundError("secret %q has no key %q", o.secretName, key)
+	}
+	if !looksLikePasswordFile(data) {
+		return fmt.Errorf("key %q does not contain htpasswd data", key)
+	}
+	f, err := newPasswordFile(data, o.hasher, o.ignoreCase)
+	if err != nil {
+		return fmt.Errorf("key %q: %v", key, err)
+	}
+	for user := range n.locations {
+		if _, ok := f.lookup(user); !ok {
+			return notFoundError("user %q of --allow does not exist in key %q", user, key)
+		}
+	}
+
+	snippets := renderNginx(o.namespace+"/"+o.secretName, key, n)
+	if n.configMap == "" {
+		fmt.Fprintf(o.Out, "# %s, include in the http context\n%s\n# %s, include in the server or location context\n%s",
+			nginxHTTPKey, snippets[nginxHTTPKey], nginxServerKey, snippets[nginxServerKey])
+		return nil
+	}
+	return o.saveConfigMapData(ctx, n.configMap, snippets)
+}
+
+// renderNginx returns the http and server snippets for the key of the secret
+// given as NAMESPACE/NAME.
+func renderNginx(secret, key string, n *nginxOptions) map[string]string {
+	// variables and zones must be unique if several secrets are used
+	id := "htpasswd_" + nginxInvalidName.ReplaceAllString(secret+"_"+key, "_")
+	header := fmt.Sprintf("# Generated by kubectl htpasswd emit-nginx from key %q of secret %s.\n", key, secret)
+
+	var http, server bytes.Buffer
+	http.WriteString(header)
+	server.WriteString(header)
+	fmt.Fprintf(&server, "auth_basic %s;\n", nginxQuote(n.realm))
+	fmt.Fprintf(&server, "auth_basic_user_file %s;\n", nginxQuote(n.filePath))
+	if len(n.locations) > 0 {
+		var users []string
+		for user := range n.locations {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+		fmt.Fprintf(&http, "map \"$remote_user:$uri\" $%s_denied {\n", id)
+		fmt.Fprintf(&http, "    default 1;\n")
+		// requests without credentials still get the 401 asking for them
+		fmt.Fprintf(&http, "    \"~^:\" 0;\n")
+		for _, user := range users {
+			for _, location := range n.locations[user] {
+				pattern := "~^" + regexp.QuoteMeta(user+":"+location)
+				if !strings.HasSuffix(location, "/") {
+					// /admin covers /admin/... but not /administrator
+					pattern += "(/|$)"
+				}
+				fmt.Fprintf(&http, "    %s 0;\n", nginxQuote(pattern))
+			}
+		}
+		fmt.Fprintf(&http, "}\n")
+		fmt.Fprintf(&server, "if ($%s_denied) {\n    return 403;\n}\n", id)
+	}
+	if n.rateLimit != "" {
+		fmt.Fprintf(&http, "limit_req_zone $remote_user zone=%s:10m rate=%s;\n", id, n.rateLimit)
+		fmt.Fprintf(&server, "limit_req zone=%s", id)
+		if n.burst > 0 {
+			fmt.Fprintf(&server, " burst=%d nodelay", n.burst)
+		}
+		fmt.Fprintf(&server, ";\n")
+	}
+	return map[string]string{nginxHTTPKey: http.String(), nginxServerKey: server.String()}
+}
+
+// nginxQuote returns s as a double quoted nginx string.
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// saveConfigMapData stores data in the ConfigMap name, creating it if needed.
+// Other keys of an existing ConfigMap are kept.
+func (o *CommandOptions) saveConfigMapData(ctx context.Context, name string, data map[string]string) error {
+	client := o.secrets()
+	cm, err := client.GetConfigMap(ctx, name)
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: o.namespace}}
+	} else if err != nil {
+		return apiError(err, "unable to get configmap %q", name)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	changed := !exists
+	var keys []string
+	for key, value := range data {
+		keys = append(keys, key)
+		if cm.Data[key] != value {
+			cm.Data[key] = value
+			changed = true
+		}
+	}
+	sort.Strings(keys)
+	if !changed {
+		o.logf(logNormal, "Configmap %q is up to date", name)
+		return nil
+	}
+	if exists {
+		err = client.UpdateConfigMap(ctx, cm)
+	} else {
+		err = client.CreateConfigMap(ctx, cm)
+	}
+	if err != nil {
+		return apiError(err, "unable to save configmap %q", name)
+	}
+	verb := "Updated"
+	if !exists {
+		verb = "Created"
+	}
+	o.logf(logNormal, "%s configmap %q with keys %s", verb, name, strings.Join(keys, ", "))
+	return nil
+}
+
+// CreateConfigMap creates the config map.
+func (c *secretsClient) CreateConfigMap(ctx context.Context, cm *v1.ConfigMap) error {
+	return c.retryIf(ctx, isRetryableCreate, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			_, err = typed.ConfigMaps(c.ns).Create(cm)
+			return err
+		}
+		return c.client.Post().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("configmaps").
+			Body(cm).
+			Do().
+			Error()
+	})
+}
+
+// UpdateConfigMap replaces the config map.
+func (c *secretsClient) UpdateConfigMap(ctx context.Context, cm *v1.ConfigMap) error {
+	return c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			_, err = typed.ConfigMaps(c.ns).Update(cm)
+			return err
+		}
+		return c.client.Put().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("configmaps").
+			Name(cm.Name).
+			VersionedParams(&metav1.UpdateOptions{}, scheme.ParameterCodec).
+			Body(cm).
+			Do().
+			Error()
+	})
+}
@@ -0,0 +1,232 @@
+package htpasswd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// readPassword returns the new password of o.username. Every command setting
+// passwords goes through here so the sources behave the same everywhere.
+// The first available source wins:
+//
+//  1. a random password with --generate, except for verify
+//  2. the first line of --password-file
+//  3. the environment variable given with --password-env
+//  4. the secret manager entry given with --password-from
+//  5. the first line of stdin with --password-stdin, or if stdin is a pipe
+//     not used for --from-manifest
+//  6. an interactive prompt asking twice
+func readPassword(o *CommandOptions, operation string) (string, error) {
+	if o.password != "" {
+		return o.password, nil
+	}
+	var password string
+	var err error
+	if o.generate && operation != "verify" {
+		password, err = generatePassword(o.generateLength, o.charsetChars())
+	} else if o.passwordFile != "" {
+		password, err = readPasswordFile(o.passwordFile)
+	} else if o.passwordEnv != "" {
+		var ok bool
+		if password, ok = os.LookupEnv(o.passwordEnv); !ok {
+			return "", fmt.Errorf("environment variable %q of --password-env is not set", o.passwordEnv)
+		}
+	} else if o.passwordFrom != "" {
+		password, err = o.fetchPassword()
+	} else if in, ok := o.pipedInput(); ok {
+		password, err = readLine(in)
+	} else if o.passwordStdin {
+		return "", fmt.Errorf("--password-stdin requires the password to be piped in")
+	} else {
+		password, err = promptPassword(o, operation)
+	}
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("password must not be empty")
+	}
+	// Generated passwords follow --length and --charset instead.
+	if operation != "verify" && !o.generate {
+		if err := o.checkPolicy(password); err != nil {
+			return "", err
+		}
+	}
+	o.password = password
+	return password, nil
+}
+
+// readPasswordFile returns the first line of the file at path.
+func readPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password file: %v", err)
+	}
+	defer f.Close()
+	return readLine(f)
+}
+
+// validatePasswordFlags checks the password source flags.
+func (o *CommandOptions) validatePasswordFlags() error {
+	sources := 0
+	for _, set := range []bool{o.passwordStdin, o.passwordFile != "", o.passwordEnv != "", o.passwordFrom != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("--password-stdin, --password-file, --password-env and --password-from are mutually exclusive")
+	}
+	if o.passwordFrom != "" {
+		if _, _, err := parsePasswordURI("--password-from", o.passwordFrom); err != nil {
+			return err
+		}
+	}
+	if o.passwordStdin && o.stdinTaken() {
+		return fmt.Errorf("--password-stdin can't be used when the manifest or file is read from stdin")
+	}
+	return nil
+}
+
+func (o *CommandOptions) addPasswordFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.passwordStdin, "password-stdin", "", false, "Read the password from stdin")
+	cmd.Flags().StringVarP(&o.passwordFile, "password-file", "", "", "Read the password from the first line of a file")
+	cmd.Flags().StringVarP(&o.passwordEnv, "password-env", "", "", "Read the password from this environment variable")
+	cmd.Flags().StringVarP(&o.passwordFrom, "password-from", "", "", "Read the password from a secret manager: vault://PATH#FIELD, op://VAULT/ITEM/FIELD or pass://PATH")
+}
+
+// pipedInput returns o.In if it can be used to read the password
+// non-interactively.
+func (o *CommandOptions) pipedInput() (io.Reader, bool) {
+	if o.In == nil || o.stdinTaken() {
+		return nil, false
+	}
+	if f, ok := o.In.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		return nil, false
+	}
+	return o.In, true
+}
+
+// readLine reads the first line of r without the trailing newline.
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptPassword asks twice for the password on o.In, or on the terminal if
+// stdin is taken.
+func promptPassword(o *CommandOptions, operation string) (string, error) {
+	in := o.In
+	// stdin is taken by the manifest or file, so prompt on the terminal
+	// directly
+	if o.stdinTaken() {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return "", fmt.Errorf("unable to open terminal for password prompt: %v", err)
+		}
+		defer tty.Close()
+		in = tty
+	}
+	if in == nil {
+		return "", fmt.Errorf("no input for the password prompt, use --password-stdin or --password-file")
+	}
+	read := passwordReader(in)
+
+	prompt := fmt.Sprintf("Set password for user %q: ", o.username)
+	switch operation {
+	case "update":
+		prompt = fmt.Sprintf("Enter new password for user %q: ", o.username)
+	case "verify":
+		prompt = fmt.Sprintf("Password for user %q: ", o.username)
+	}
+	fmt.Fprint(o.ErrOut, prompt)
+	password1, err := read()
+	if err != nil {
+		return "", err
+	}
+	if operation == "verify" {
+		fmt.Fprintf(o.ErrOut, "\n")
+		return password1, nil
+	}
+	fmt.Fprintf(o.ErrOut, "\nRepeat password for user %q: ", o.username)
+	password2, err := read()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(o.ErrOut, "\n")
+	if password1 != password2 {
+		return "", fmt.Errorf("passwords don't match")
+	}
+	return password1, nil
+}
+
+// passwordReader returns a function reading a password from in. On a
+// terminal the input isn't echoed, other input is read line by line.
+func passwordReader(in io.Reader) func() (string, error) {
+	if f, ok := in.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		return func() (string, error) {
+			password, err := terminal.ReadPassword(int(f.Fd()))
+			return string(password), err
+		}
+	}
+	r := bufio.NewReader(in)
+	return func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", fmt.Errorf("unable to read password: unexpected end of input")
+		} else if err != nil && err != io.EOF {
+			return "", fmt.Errorf("unable to read password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// promptConfirm asks a yes/no question on the terminal. Without a terminal
+// the answer is no, use --yes in scripts.
+func promptConfirm(o *CommandOptions, question string) (bool, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false, fmt.Errorf("unable to ask for confirmation, use --yes: %v", err)
+	}
+	defer tty.Close()
+	fmt.Fprintf(o.ErrOut, "%s [y/N]: ", question)
+	answer, err := readLine(tty)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// confirm asks before a destructive change of secret unless --yes is given
+// or nothing is written to the cluster or local file.
+func (o *CommandOptions) confirm(secret *v1.Secret, question string) error {
+	id := secret.Namespace + "/" + secret.Name
+	if o.yes || o.dryRun != "" || o.outputManifest != "" || o.fromManifest != "" || o.confirmed == id {
+		return nil
+	}
+	ok, err := promptConfirm(o, question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("aborted")
+	}
+	// don't ask again when retrying after a conflict
+	o.confirmed = id
+	return nil
+}
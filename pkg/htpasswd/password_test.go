@@ -0,0 +1,65 @@
+package htpasswd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestPromptPassword(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		input     string
+		want      string
+		err       string
+		prompts   string
+	}{
+		{"set", "set", "s3cret\ns3cret\n", "s3cret", "", `Set password for user "alice": ` + "\n" + `Repeat password for user "alice": ` + "\n"},
+		{"update", "update", "s3cret\r\ns3cret\r\n", "s3cret", "", `Enter new password for user "alice": ` + "\n" + `Repeat password for user "alice": ` + "\n"},
+		{"verify asks once", "verify", "s3cret\nignored\n", "s3cret", "", `Password for user "alice": ` + "\n"},
+		{"last line without newline", "set", "s3cret\ns3cret", "s3cret", "", ""},
+		{"mismatch", "set", "s3cret\nother\n", "", "passwords don't match", ""},
+		{"no repetition", "set", "s3cret\n", "", "unexpected end of input", ""},
+		{"no input", "verify", "", "", "unexpected end of input", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			streams, in, _, errOut := genericclioptions.NewTestIOStreams()
+			in.WriteString(test.input)
+			o := &CommandOptions{IOStreams: streams, username: "alice"}
+			got, err := promptPassword(o, test.operation)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("password = %q, want %q", got, test.want)
+			}
+			if test.prompts != "" && errOut.String() != test.prompts {
+				t.Errorf("prompts = %q, want %q", errOut.String(), test.prompts)
+			}
+		})
+	}
+}
+
+func TestReadPasswordFromPipe(t *testing.T) {
+	o := &CommandOptions{IOStreams: genericclioptions.IOStreams{In: strings.NewReader("s3cret\nrest\n"), Out: &bytes.Buffer{}, ErrOut: &bytes.Buffer{}}}
+	got, err := readPassword(o, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "s3cret" {
+		t.Errorf("password = %q, want the first line %q", got, "s3cret")
+	}
+	if errOut := o.ErrOut.(*bytes.Buffer).String(); errOut != "" {
+		t.Errorf("piped password prompted %q", errOut)
+	}
+}
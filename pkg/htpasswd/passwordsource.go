@@ -0,0 +1,277 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// passwordSource fetches passwords from an external secret manager for
+// --password-from.
+type passwordSource interface {
+	// Password returns the password the URI points to.
+	Password(o *CommandOptions, u *url.URL) (string, error)
+}
+
+// passwordStore is implemented by the password sources which can also store
+// generated passwords, for --store-plaintext.
+type passwordStore interface {
+	StorePassword(o *CommandOptions, u *url.URL, password string) error
+}
+
+// passwordSources is the registry of --password-from sources keyed by
+// their URI scheme.
+var passwordSources = map[string]passwordSource{
+	"vault": vaultSource{},
+	"op":    onePasswordSource{},
+	"pass":  passSource{},
+}
+
+// passwordSourceNames returns the sorted schemes of passwordSources.
+func passwordSourceNames() []string {
+	var names []string
+	for name := range passwordSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsePasswordURI parses the URI s given with flag and returns its source.
+func parsePasswordURI(flag, s string) (*url.URL, passwordSource, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s %q: %v", flag, s, err)
+	}
+	source, ok := passwordSources[u.Scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported %s %q, must start with one of: %s://", flag, s, strings.Join(passwordSourceNames(), "://, "))
+	}
+	if u.Host+u.Path == "" {
+		return nil, nil, fmt.Errorf("invalid %s %q, the path is missing", flag, s)
+	}
+	return u, source, nil
+}
+
+// fetchPassword returns the password of --password-from.
+func (o *CommandOptions) fetchPassword() (string, error) {
+	u, source, err := parsePasswordURI("--password-from", o.passwordFrom)
+	if err != nil {
+		return "", err
+	}
+	o.logf(logDetails, "Reading the password from %s", u.Scheme)
+	password, err := source.Password(o, u)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password from %s: %v", o.passwordFrom, err)
+	}
+	return password, nil
+}
+
+// runPasswordCommand runs a secret manager CLI with input on stdin and
+// returns its output without the trailing newline.
+func (o *CommandOptions) runPasswordCommand(input, name string, args ...string) (string, error) {
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &out
+	cmd.Stderr = o.ErrOut
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %v", name, err)
+	}
+	return strings.TrimRight(out.String(), "\r\n"), nil
+}
+
+// vaultSource reads vault://PATH#FIELD from the HTTP API of HashiCorp Vault
+// at $VAULT_ADDR, e.g. vault://secret/data/gateway#password for a KV v2
+// engine mounted at secret. The field defaults to password.
+type vaultSource struct{}
+
+func (vaultSource) Password(o *CommandOptions, u *url.URL) (string, error) {
+	fields, _, err := vaultRead(o, u)
+	if err != nil {
+		return "", err
+	}
+	if fields == nil {
+		return "", fmt.Errorf("the secret doesn't exist")
+	}
+	value, ok := fields[vaultField(u)].(string)
+	if !ok {
+		return "", fmt.Errorf("the secret has no string field %q", vaultField(u))
+	}
+	return value, nil
+}
+
+// StorePassword sets the field of the Vault secret, keeping its other
+// fields.
+func (vaultSource) StorePassword(o *CommandOptions, u *url.URL, password string) error {
+	fields, kv2, err := vaultRead(o, u)
+	if err != nil {
+		return err
+	}
+	if fields == nil {
+		// a new secret, KV v2 paths have data after the mount
+		fields = make(map[string]interface{})
+		kv2 = strings.HasPrefix(u.Path, "/data/")
+	}
+	fields[vaultField(u)] = password
+	var body interface{} = fields
+	if kv2 {
+		body = map[string]interface{}{"data": fields}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := vaultRequest(o, "POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// vaultField returns the field of the Vault secret u points to.
+func vaultField(u *url.URL) string {
+	if u.Fragment == "" {
+		return "password"
+	}
+	return u.Fragment
+}
+
+// vaultRead returns the fields of the Vault secret and whether it's stored
+// in a KV v2 engine. The fields are nil if the secret doesn't exist.
+func vaultRead(o *CommandOptions, u *url.URL) (map[string]interface{}, bool, error) {
+	resp, err := vaultRequest(o, "GET", u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, false, fmt.Errorf("invalid response: %v", err)
+	}
+	// KV v2 nests the fields next to their metadata
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return nested, true, nil
+		}
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string]interface{})
+	}
+	return secret.Data, false, nil
+}
+
+// vaultRequest sends a request for the path of u to $VAULT_ADDR, with
+// $VAULT_TOKEN or the token written by vault login.
+func vaultRequest(o *CommandOptions, method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		data, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".vault-token"))
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_TOKEN is not set and there is no ~/.vault-token")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(addr, "/")+"/v1/"+u.Host+u.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: o.timeout}
+	return client.Do(req)
+}
+
+// onePasswordSource reads op://VAULT/ITEM/FIELD with the 1Password CLI,
+// which understands these secret references itself.
+type onePasswordSource struct{}
+
+func (onePasswordSource) Password(o *CommandOptions, u *url.URL) (string, error) {
+	return o.runPasswordCommand("", "op", "read", "--no-newline", u.String())
+}
+
+// passSource reads pass://PATH from the password store of pass, which keeps
+// the password on the first line.
+type passSource struct{}
+
+func (passSource) Password(o *CommandOptions, u *url.URL) (string, error) {
+	out, err := o.runPasswordCommand("", "pass", "show", u.Host+u.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(out, "\n", 2)[0], nil
+}
+
+// StorePassword replaces the entry with the password.
+func (passSource) StorePassword(o *CommandOptions, u *url.URL, password string) error {
+	_, err := o.runPasswordCommand(password+"\n", "pass", "insert", "--multiline", "--force", u.Host+u.Path)
+	return err
+}
+
+// userPlaceholder in --store-plaintext is replaced by the username, so each
+// user gets an entry of its own.
+const userPlaceholder = "{user}"
+
+// parseStorePlaintext parses --store-plaintext for username and returns its
+// store.
+func parseStorePlaintext(s, username string) (*url.URL, passwordStore, error) {
+	u, source, err := parsePasswordURI("--store-plaintext", strings.Replace(s, userPlaceholder, username, -1))
+	if err != nil {
+		return nil, nil, err
+	}
+	store, ok := source.(passwordStore)
+	if !ok {
+		return nil, nil, fmt.Errorf("--store-plaintext doesn't support %s://, passwords can only be read from it", u.Scheme)
+	}
+	return u, store, nil
+}
+
+// storePlaintext writes the generated password of username to
+// --store-plaintext.
+func (o *CommandOptions) storePlaintext(username, password string) error {
+	u, store, err := parseStorePlaintext(o.storePlaintextURI, username)
+	if err != nil {
+		return err
+	}
+	if err := store.StorePassword(o, u, password); err != nil {
+		return fmt.Errorf("unable to store password of user %q in %s: %v", username, u, err)
+	}
+	o.logf(logNormal, "Generated password for user %q stored in %s", username, u)
+	return nil
+}
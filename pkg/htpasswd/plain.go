@@ -0,0 +1,71 @@
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func (o *CommandOptions) addInsecureAllowFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.insecureAllow, "insecure-allow", "", false, "Allow --hash plain and crypt and verify plaintext entries, e.g. to migrate legacy htpasswd data")
+}
+
+// newInsecureHasher returns a constructor for hashers which are only
+// available with --insecure-allow.
+func newInsecureHasher(name string, hasher Hasher) func(hashOptions) (Hasher, error) {
+	return func(opts hashOptions) (Hasher, error) {
+		if !opts.insecure {
+			return nil, fmt.Errorf("--hash %s stores passwords insecurely and requires --insecure-allow", name)
+		}
+		return hasher, nil
+	}
+}
+
+// plainHasher stores the password as is, like Apache htpasswd -p. Apache
+// only accepts such entries on Windows and NetWare.
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) (string, error) {
+	switch {
+	case strings.Contains(password, ":"):
+		return "", fmt.Errorf("plaintext passwords must not contain a colon")
+	case strings.TrimSpace(password) != password:
+		return "", fmt.Errorf("plaintext passwords must not start or end with whitespace")
+	case hashAlgorithm(password) != "":
+		return "", fmt.Errorf("plaintext password would be read as a %s hash", hashAlgorithm(password))
+	}
+	return password, nil
+}
+
+// cryptHasher creates traditional DES based crypt(3) entries, like Apache
+// htpasswd -d.
+type cryptHasher struct{}
+
+func (cryptHasher) Hash(password string) (string, error) {
+	if len(password) > 8 {
+		return "", fmt.Errorf("crypt only uses the first 8 characters of the password, use a shorter one")
+	}
+	salt, err := cryptSalt(2)
+	if err != nil {
+		return "", err
+	}
+	return desCrypt(password, salt), nil
+}
+
+// verifyPlain checks password against an entry in no known hash format,
+// which is taken to be the plaintext password.
+func verifyPlain(hash, password string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+}
+
+// algorithm returns the hash algorithm of the entry of username, reporting
+// unknown formats as plaintext if those are allowed.
+func (f *passwordFile) algorithm(username string) string {
+	algorithm := hashAlgorithm(f.passwords[username])
+	if algorithm == "" && f.allowPlain {
+		return "plain"
+	}
+	return algorithm
+}
@@ -0,0 +1,161 @@
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// pwnedRangeURL is the k-anonymity endpoint of Have I Been Pwned. Only the
+// first 5 hex digits of the SHA-1 of the password are sent.
+const pwnedRangeURL = "https://api.pwnedpasswords.com/range/"
+
+// passwordPolicy are the rules new passwords are checked against before
+// they get hashed. The same fields can be loaded from --password-policy.
+type passwordPolicy struct {
+	MinLength         int    `json:"minLength,omitempty"`
+	RequireComplexity bool   `json:"requireComplexity,omitempty"`
+	Denylist          string `json:"denylist,omitempty"`
+	CheckPwned        bool   `json:"checkPwned,omitempty"`
+}
+
+func (o *CommandOptions) addPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&o.policy.MinLength, "min-length", "", 0, "Reject passwords shorter than this")
+	cmd.Flags().BoolVarP(&o.policy.RequireComplexity, "require-complexity", "", false, "Require at least three of lowercase letters, uppercase letters, digits and other characters")
+	cmd.Flags().StringVarP(&o.policy.Denylist, "denylist", "", "", "Reject passwords listed in this file, one per line")
+	cmd.Flags().BoolVarP(&o.policy.CheckPwned, "check-pwned", "", false, "Reject passwords known from data breaches, using the k-anonymity API of haveibeenpwned.com")
+	cmd.Flags().StringVarP(&o.policyFile, "password-policy", "", "", "YAML file with the password policy, combined with the flags above")
+}
+
+// validatePolicy loads --password-policy. The stricter setting of the file
+// and the flags wins.
+func (o *CommandOptions) validatePolicy() error {
+	if o.policy.MinLength < 0 {
+		return fmt.Errorf("--min-length must not be negative")
+	}
+	if o.policyFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(o.policyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read password policy: %v", err)
+	}
+	var file passwordPolicy
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("invalid password policy %q: %v", o.policyFile, err)
+	}
+	if file.MinLength > o.policy.MinLength {
+		o.policy.MinLength = file.MinLength
+	}
+	o.policy.RequireComplexity = o.policy.RequireComplexity || file.RequireComplexity
+	o.policy.CheckPwned = o.policy.CheckPwned || file.CheckPwned
+	if o.policy.Denylist == "" {
+		o.policy.Denylist = file.Denylist
+	} else if file.Denylist != "" && file.Denylist != o.policy.Denylist {
+		return fmt.Errorf("--denylist conflicts with the denylist of %q", o.policyFile)
+	}
+	return nil
+}
+
+// checkPolicy returns an error describing why password violates the policy.
+func (o *CommandOptions) checkPolicy(password string) error {
+	p := o.policy
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("password is too short, %d of at least %d characters", n, p.MinLength)
+	}
+	if p.RequireComplexity && characterClasses(password) < 3 {
+		return fmt.Errorf("password must contain at least three of lowercase letters, uppercase letters, digits and other characters")
+	}
+	if p.Denylist != "" {
+		denied, err := inDenylist(p.Denylist, password)
+		if err != nil {
+			return err
+		}
+		if denied {
+			return fmt.Errorf("password is on the denylist")
+		}
+	}
+	if p.CheckPwned {
+		count, err := o.pwnedCount(password)
+		if err != nil {
+			return fmt.Errorf("unable to check password against haveibeenpwned.com: %v", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("password appeared %d times in data breaches", count)
+		}
+	}
+	return nil
+}
+
+// characterClasses counts the classes of characters used in password.
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// inDenylist reports whether password is listed in the file at path,
+// ignoring case. Blank lines and # comments are skipped.
+func inDenylist(path, password string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read denylist: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if isComment(l) {
+			continue
+		}
+		if strings.EqualFold(l, password) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// pwnedCount returns how often password appeared in known breaches.
+func (o *CommandOptions) pwnedCount(password string) (int, error) {
+	sum := fmt.Sprintf("%X", sha1.Sum([]byte(password)))
+	prefix, suffix := sum[:5], sum[5:]
+
+	client := &http.Client{Timeout: o.timeout}
+	resp, err := client.Get(pwnedRangeURL + prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) == 2 && parts[0] == suffix {
+			var count int
+			fmt.Sscanf(parts[1], "%d", &count)
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
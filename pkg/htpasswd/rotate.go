@@ -0,0 +1,222 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func newRotateCommand(o *CommandOptions) *cobra.Command {
+	cmd := newSubcommand(o, "rotate SECRET [<username>...]", "Replace the passwords of all or the given users with random ones", func() {
+		o.rotate = true
+		o.generate = true
+	})
+	cmd.Long = `Replace the passwords of all or the given users with random ones.
+
+The new passwords are printed once as a table on stdout, or written to
+--passwords-file or the secret --passwords-secret, which is created or
+replaced. They can't be recovered later.`
+	cmd.Flags().StringVarP(&o.passwordsFile, "passwords-file", "", "", "Write the new passwords to this file instead of stdout")
+	cmd.Flags().StringVarP(&o.passwordsSecret, "passwords-secret", "", "", "Store the new passwords in this secret, one key per user, instead of printing them")
+	o.addStorePlaintextFlag(cmd)
+	o.addCharsetFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addWriteFlags(cmd)
+	o.addYesFlag(cmd)
+	o.addShardsFlag(cmd)
+	return cmd
+}
+
+// validateRotate checks the flags of rotate.
+func (o *CommandOptions) validateRotate() error {
+	if !o.rotate {
+		return nil
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("rotate isn't supported for basic-auth secrets, use add --generate")
+	}
+	if o.storePlaintextURI != "" && len(o.usernames) != 1 && !strings.Contains(o.storePlaintextURI, userPlaceholder) {
+		return fmt.Errorf("--store-plaintext needs %s to store the password of each user separately", userPlaceholder)
+	}
+	if o.passwordsFile != "" && o.passwordsSecret != "" {
+		return fmt.Errorf("--passwords-file and --passwords-secret are mutually exclusive")
+	}
+	if o.passwordsSecret != "" {
+		if o.fromManifest != "" || o.outputManifest != "" {
+			return fmt.Errorf("--passwords-secret requires a cluster, it can't be combined with manifests")
+		}
+		if o.passwordsSecret == o.secretName {
+			return fmt.Errorf("--passwords-secret must differ from the rotated secret")
+		}
+	}
+	if o.passwordsFile == "" && o.passwordsSecret == "" && o.dryRun == "" {
+		if o.output != "" || o.fromManifest != "" || o.outputManifest == "-" {
+			return fmt.Errorf("stdout is taken, use --passwords-file or --passwords-secret")
+		}
+	}
+	return nil
+}
+
+// runRotate sets a random password for the selected users in every key
+// they exist in and hands out the passwords once the secret was saved.
+func (o *CommandOptions) runRotate(ctx context.Context, secret *v1.Secret, files []*keyFile) error {
+	usernames := o.usernames
+	if len(usernames) == 0 {
+		seen := make(map[string]bool)
+		for _, f := range files {
+			users, _ := f.ListUsers()
+			for _, u := range users {
+				if !seen[u] {
+					seen[u] = true
+					usernames = append(usernames, u)
+				}
+			}
+		}
+		sort.Strings(usernames)
+	} else {
+		for _, username := range usernames {
+			for _, f := range files {
+				if _, ok := f.lookup(username); !ok {
+					return notFoundError("user %q does not exist in key %q", username, f.key)
+				}
+			}
+		}
+	}
+	if len(usernames) == 0 {
+		return notFoundError("secret %q has no users", secret.Name)
+	}
+	if o.passwordsSecret != "" {
+		// check before rotating, the keys are the usernames
+		for _, username := range usernames {
+			if errs := validation.IsConfigMapKey(username); len(errs) > 0 {
+				return fmt.Errorf("user %q can't be a key of secret %q: %s", username, o.passwordsSecret, strings.Join(errs, ", "))
+			}
+		}
+	}
+	if err := o.confirm(secret, fmt.Sprintf("Replace the passwords of %d user(s) in secret %q?", len(usernames), secret.Name)); err != nil {
+		return err
+	}
+
+	passwords := make(map[string]string)
+	for _, username := range usernames {
+		password, err := generatePassword(o.generateLength, o.charsetChars())
+		if err != nil {
+			return err
+		}
+		passwords[username] = password
+		for _, f := range files {
+			if _, ok := f.lookup(username); !ok {
+				continue
+			}
+			if err := f.SetPassword(username, password); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		f.operation = "rotate"
+		secret.Data[f.key] = f.Bytes()
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	o.recordChange(secret, "rotate", usernames, o.newHashName())
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	o.logf(logNormal, "Rotated the passwords of %d user(s) in secret %q%s", len(usernames), secret.Name, o.dryRunSuffix())
+	if o.dryRun != "" {
+		return o.printResults(files)
+	}
+	if err := o.writePasswords(ctx, usernames, passwords); err != nil {
+		if o.storePlaintextURI != "" {
+			// the passwords are meant for the store only, withhold them
+			return fmt.Errorf("%v; the secret was saved with passwords which aren't shown, rotate again", err)
+		}
+		// the secret is already saved, don't lose the only copy
+		fmt.Fprintf(o.ErrOut, "Warning: the new passwords couldn't be stored, printing them instead\n")
+		printPasswords(o.ErrOut, usernames, passwords)
+		return err
+	}
+	return o.printResults(files)
+}
+
+// writePasswords hands out the rotated passwords to the selected target.
+func (o *CommandOptions) writePasswords(ctx context.Context, usernames []string, passwords map[string]string) error {
+	switch {
+	case o.storePlaintextURI != "":
+		for _, u := range usernames {
+			if err := o.storePlaintext(u, passwords[u]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case o.passwordsSecret != "":
+		return o.savePasswordsSecret(ctx, passwords)
+	case o.passwordsFile != "":
+		f, err := os.OpenFile(o.passwordsFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to write new passwords: %v", err)
+		}
+		printPasswords(f, usernames, passwords)
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("unable to write new passwords: %v", err)
+		}
+		o.logf(logNormal, "New passwords written to %q", o.passwordsFile)
+		return nil
+	}
+	printPasswords(o.Out, usernames, passwords)
+	return nil
+}
+
+// printPasswords prints a table of the users and their new passwords.
+func printPasswords(out io.Writer, usernames []string, passwords map[string]string) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tPASSWORD")
+	for _, u := range usernames {
+		fmt.Fprintf(w, "%s\t%s\n", u, passwords[u])
+	}
+	w.Flush()
+}
+
+// savePasswordsSecret creates or replaces --passwords-secret with one key
+// per user holding the plaintext password.
+func (o *CommandOptions) savePasswordsSecret(ctx context.Context, passwords map[string]string) error {
+	data := make(map[string][]byte, len(passwords))
+	for u, p := range passwords {
+		data[u] = []byte(p)
+	}
+	client := o.secrets()
+	secret, err := client.Get(ctx, o.passwordsSecret)
+	if apierrors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      o.passwordsSecret,
+				Namespace: o.namespace,
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		}
+		_, err = client.Create(ctx, secret)
+	} else if err == nil {
+		secret.Data = data
+		_, err = client.Update(ctx, secret)
+	}
+	if err != nil {
+		return apiError(err, "unable to save secret %q", o.passwordsSecret)
+	}
+	o.logf(logNormal, "New passwords stored in secret %q", o.passwordsSecret)
+	return nil
+}
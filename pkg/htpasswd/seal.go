@@ -0,0 +1,96 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// encryptCommands are the command lines of the tools known to --encrypt.
+// Both read the manifest from stdin and print the encrypted one.
+var encryptCommands = map[string][]string{
+	"kubeseal": {"kubeseal", "--format", "yaml"},
+	// only encrypt the data, sops would otherwise also encrypt the kind
+	// and metadata
+	"sops": {"sops", "--encrypt", "--input-type", "yaml", "--output-type", "yaml", "--encrypted-regex", "^(data|stringData)$", "/dev/stdin"},
+}
+
+// validateOutputManifest checks --output-manifest and --encrypt.
+func (o *CommandOptions) validateOutputManifest() error {
+	if o.outputManifest == "" {
+		if o.encrypt != "" {
+			return fmt.Errorf("--encrypt requires --output-manifest")
+		}
+		return nil
+	}
+	switch {
+	case o.dryRun != "":
+		return fmt.Errorf("--output-manifest can't be combined with --dry-run")
+	case o.serverSideApply:
+		return fmt.Errorf("--output-manifest can't be combined with --server-side-apply")
+	case o.traefik:
+		return fmt.Errorf("--output-manifest can't be combined with --traefik")
+	case o.manifestPath != "":
+		return fmt.Errorf("--output-manifest can't be combined with --to-manifest")
+	case o.selector != "":
+		return fmt.Errorf("--output-manifest can't be combined with --selector")
+	case o.outputManifest == "-" && o.output != "":
+		return fmt.Errorf("--output can't be used when the manifest is written to stdout")
+	}
+	if o.encrypt != "" && len(strings.Fields(o.encrypt)) == 0 {
+		return fmt.Errorf("--encrypt must not be empty")
+	}
+	return nil
+}
+
+// writeOutputManifest writes secret as YAML to --output-manifest, encrypted
+// with --encrypt if given, instead of saving it in the cluster.
+func (o *CommandOptions) writeOutputManifest(ctx context.Context, secret *v1.Secret) error {
+	data, err := yaml.Marshal(cleanManifest(secret))
+	if err != nil {
+		return err
+	}
+	if o.encrypt != "" {
+		data, err = o.encryptManifest(ctx, data)
+		if err != nil {
+			return err
+		}
+	}
+	if o.outputManifest == "-" {
+		_, err = o.Out.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(o.outputManifest, data, 0600); err != nil {
+		return fmt.Errorf("unable to write manifest: %v", err)
+	}
+	return nil
+}
+
+// encryptManifest pipes the manifest through the --encrypt command and
+// returns its output. The command line is split on whitespace, it isn't run
+// by a shell.
+func (o *CommandOptions) encryptManifest(ctx context.Context, manifest []byte) ([]byte, error) {
+	args, ok := encryptCommands[o.encrypt]
+	if !ok {
+		args = strings.Fields(o.encrypt)
+	}
+	o.logf(logDetails, "Encrypting the manifest with %q", strings.Join(args, " "))
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(manifest)
+	cmd.Stdout = &out
+	cmd.Stderr = o.ErrOut
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to encrypt the manifest with %s: %v", args[0], err)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("%s didn't print an encrypted manifest", args[0])
+	}
+	return out.Bytes(), nil
+}
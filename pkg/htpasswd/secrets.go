@@ -0,0 +1,291 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// defaultRequestTimeout is used unless --request-timeout is given.
+const defaultRequestTimeout = "30s"
+
+// secretsClient mirrors the typed secrets client, but binds every request to
+// a context so hanging API servers time out and Ctrl-C cancels cleanly.
+// client is CommandOptions.coreClient. Without one, e.g. with the fake
+// clientset in tests, the requests go through the typed clients of typed.
+type secretsClient struct {
+	client       rest.Interface
+	typed        corev1client.CoreV1Interface
+	ns           string
+	timeout      time.Duration
+	maxRetries   int
+	dryRun       []string
+	fieldManager string
+}
+
+func (o *CommandOptions) secrets() *secretsClient {
+	var dryRun []string
+	if o.dryRun == dryRunServer {
+		dryRun = []string{metav1.DryRunAll}
+	}
+	var typed corev1client.CoreV1Interface
+	if o.coreClient == nil && o.clientset != nil {
+		typed = o.clientset.CoreV1()
+	}
+	return &secretsClient{
+		client:       o.coreClient,
+		typed:        typed,
+		ns:           o.namespace,
+		timeout:      o.timeout,
+		maxRetries:   o.maxRetries,
+		dryRun:       dryRun,
+		fieldManager: o.fieldManager,
+	}
+}
+
+// withTimeout bounds a single request by the request timeout.
+func (c *secretsClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// errNoRESTClient is returned by the requests the typed clients can't send.
+var errNoRESTClient = fmt.Errorf("the clientset has no REST client for this request")
+
+// typedCore returns the typed clients used without a REST client. Their
+// requests can't be bound to ctx, only one done before is honored. The typed
+// clients of this client-go release can't send dry runs either.
+func (c *secretsClient) typedCore(ctx context.Context, write bool) (corev1client.CoreV1Interface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if write && len(c.dryRun) > 0 {
+		return nil, fmt.Errorf("--dry-run=server requires a REST client, the clientset can't send dry runs")
+	}
+	return c.typed, nil
+}
+
+// Get returns the secret with the given name.
+func (c *secretsClient) Get(ctx context.Context, name string) (*v1.Secret, error) {
+	result := &v1.Secret{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			secret, err := typed.Secrets(c.ns).Get(name, metav1.GetOptions{})
+			if err == nil {
+				result = secret
+			}
+			return err
+		}
+		return c.client.Get().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			Name(name).
+			VersionedParams(&metav1.GetOptions{}, scheme.ParameterCodec).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// List returns the secrets matching selector. An empty namespace lists the
+// secrets of all namespaces.
+func (c *secretsClient) List(ctx context.Context, selector string) (*v1.SecretList, error) {
+	result := &v1.SecretList{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			list, err := typed.Secrets(c.ns).List(metav1.ListOptions{LabelSelector: selector})
+			if err == nil {
+				result = list
+			}
+			return err
+		}
+		return c.client.Get().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			VersionedParams(&metav1.ListOptions{LabelSelector: selector}, scheme.ParameterCodec).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// Create creates the secret.
+func (c *secretsClient) Create(ctx context.Context, secret *v1.Secret) (*v1.Secret, error) {
+	result := &v1.Secret{}
+	// a POST which timed out may have created the secret, retrying it would
+	// fail with AlreadyExists
+	err := c.retryIf(ctx, isRetryableCreate, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, true)
+			if err != nil {
+				return err
+			}
+			created, err := typed.Secrets(c.ns).Create(secret)
+			if err == nil {
+				result = created
+			}
+			return err
+		}
+		return c.client.Post().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			VersionedParams(&metav1.CreateOptions{DryRun: c.dryRun, FieldManager: c.fieldManager}, scheme.ParameterCodec).
+			Body(secret).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// Update updates the secret.
+func (c *secretsClient) Update(ctx context.Context, secret *v1.Secret) (*v1.Secret, error) {
+	result := &v1.Secret{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, true)
+			if err != nil {
+				return err
+			}
+			updated, err := typed.Secrets(c.ns).Update(secret)
+			if err == nil {
+				result = updated
+			}
+			return err
+		}
+		return c.client.Put().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("secrets").
+			Name(secret.Name).
+			VersionedParams(&metav1.UpdateOptions{DryRun: c.dryRun, FieldManager: c.fieldManager}, scheme.ParameterCodec).
+			Body(secret).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
+
+// retry runs fn with exponential backoff as long as it fails with a
+// transient error and retries are left. Every attempt is bound by the
+// request timeout.
+func (c *secretsClient) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	return c.retryIf(ctx, isRetryable, fn)
+}
+
+// retryIf is retry with the errors worth retrying selected by retryable.
+func (c *secretsClient) retryIf(ctx context.Context, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	backoff := wait.Backoff{
+		Duration: 500 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    c.maxRetries,
+	}
+	for {
+		attemptCtx, cancel := c.withTimeout(ctx)
+		err := fn(attemptCtx)
+		cancel()
+		if err == nil || !retryable(err) || backoff.Steps == 0 || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// isRetryable reports whether err is a transient error worth retrying.
+// Errors like NotFound or Forbidden won't go away by trying again.
+func isRetryable(err error) bool {
+	switch {
+	case apierrors.IsServerTimeout(err),
+		apierrors.IsTimeout(err),
+		apierrors.IsTooManyRequests(err),
+		apierrors.IsInternalError(err),
+		apierrors.IsServiceUnavailable(err),
+		apierrors.IsUnexpectedServerError(err),
+		utilnet.IsProbableEOF(err):
+		return true
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		_, ok := urlErr.Err.(*net.OpError)
+		return ok
+	}
+	return false
+}
+
+// isRetryableCreate reports whether err means a request which isn't
+// idempotent was never processed, so it is safe to send it again: the server
+// turned it away or the connection couldn't be established.
+func isRetryableCreate(err error) bool {
+	if apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) {
+		return true
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		opErr, ok := urlErr.Err.(*net.OpError)
+		return ok && opErr.Op == "dial"
+	}
+	return false
+}
+
+// requestTimeout parses the --request-timeout flag the same way kubectl does:
+// a bare integer is a number of seconds and zero disables the timeout.
+func (o *CommandOptions) requestTimeout() (time.Duration, error) {
+	value := defaultRequestTimeout
+	if o.configFlags.Timeout != nil {
+		value = *o.configFlags.Timeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if i, intErr := strconv.ParseInt(value, 10, 64); intErr == nil {
+		timeout, err = time.Duration(i)*time.Second, nil
+	}
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid --request-timeout %q, must be a positive duration like 30s", value)
+	}
+	return timeout, nil
+}
+
+// newContext returns a context which is cancelled on Ctrl-C.
+func (o *CommandOptions) newContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
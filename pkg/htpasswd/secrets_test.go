@@ -0,0 +1,39 @@
+package htpasswd
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		valid bool
+	}{
+		{"", 30 * time.Second, true},
+		{"0", 0, true},
+		{"5", 5 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"-5", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		o := &CommandOptions{configFlags: genericclioptions.NewConfigFlags(true)}
+		if test.value != "" {
+			o.configFlags.Timeout = &test.value
+		} else {
+			o.configFlags.Timeout = nil
+		}
+		got, err := o.requestTimeout()
+		switch {
+		case !test.valid && err == nil:
+			t.Errorf("requestTimeout(%q) = %v, want an error", test.value, got)
+		case test.valid && (err != nil || got != test.want):
+			t.Errorf("requestTimeout(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func (o *CommandOptions) addSelectorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "Apply the operation to every secret matching this label selector instead of a single SECRET")
+	cmd.Flags().BoolVarP(&o.allNamespaces, "all-namespaces", "A", false, "With --selector, look for secrets in all namespaces")
+}
+
+// validateSelector checks the flags of the selector mode.
+func (o *CommandOptions) validateSelector() error {
+	if o.selector == "" {
+		if o.allNamespaces {
+			return fmt.Errorf("--all-namespaces requires --selector")
+		}
+		return nil
+	}
+	if _, err := labels.Parse(o.selector); err != nil {
+		return fmt.Errorf("invalid selector %q: %v", o.selector, err)
+	}
+	if o.fromManifest != "" || o.createSecret || o.moveKey != "" {
+		return fmt.Errorf("--selector can't be combined with --from-manifest, create or --move-key")
+	}
+	return nil
+}
+
+// runSelector runs the operation on every matching secret holding the
+// selected keys. Secrets of another layout are skipped, failures are
+// reported once all secrets were processed.
+func (o *CommandOptions) runSelector(ctx context.Context) error {
+	client := o.secrets()
+	if o.allNamespaces {
+		client.ns = ""
+	}
+	list, err := client.List(ctx, o.selector)
+	if err != nil {
+		return apiError(err, "unable to list secrets")
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	var matched, failed int
+	for i := range list.Items {
+		secret := &list.Items[i]
+		if !o.hasLayout(secret) {
+			o.logf(logDetails, "Skipping secret %s/%s without the selected keys", secret.Namespace, secret.Name)
+			continue
+		}
+		matched++
+		o.secretName, o.namespace = secret.Name, secret.Namespace
+		o.logf(logNormal, "Secret %s/%s:", secret.Namespace, secret.Name)
+		if err := o.runWithRetry(ctx); err != nil {
+			fmt.Fprintf(o.ErrOut, "Error: %v\n", err)
+			failed++
+		}
+	}
+	if matched == 0 {
+		return notFoundError("no htpasswd secret matches selector %q", o.selector)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed on %d of %d secrets", failed, matched)
+	}
+	return nil
+}
+
+// hasLayout reports whether secret has the selected type and keys.
+func (o *CommandOptions) hasLayout(secret *v1.Secret) bool {
+	if secret.Type != o.secretType {
+		return false
+	}
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return true
+	}
+	for _, key := range o.keyNames {
+		if _, ok := secret.Data[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package htpasswd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func (o *CommandOptions) addShardsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&o.shards, "shards", "", 0, "Spread the users over this many secrets SECRET-0, SECRET-1, ... to stay below the size limit")
+}
+
+// shardedAnnotations are the per-user annotations merged when the shards
+// are read together.
+var shardedAnnotations = []string{expiresAnnotation, commentsAnnotation}
+
+// validateShards checks --shards against the selected operation.
+func (o *CommandOptions) validateShards() error {
+	if o.shards < 0 {
+		return fmt.Errorf("--shards must not be negative")
+	}
+	if o.shards == 0 {
+		return nil
+	}
+	switch {
+	case o.selector != "" || o.local || o.fromManifest != "" || o.outputManifest != "":
+		return fmt.Errorf("--shards can't be combined with --selector, --from-manifest, --output-manifest or local files")
+	case o.moveKey != "" || o.importFile != "":
+		return fmt.Errorf("--shards can't be combined with --move-key or import")
+	case o.secretType == v1.SecretTypeBasicAuth:
+		return fmt.Errorf("--shards isn't supported for basic-auth secrets")
+	case o.renaming && o.shardOf(o.username) != o.shardOf(o.renameTo):
+		return fmt.Errorf("%q and %q belong to different shards, delete and add the user instead", o.username, o.renameTo)
+	}
+	return nil
+}
+
+// shardOf returns the index of the shard holding username. The assignment
+// only depends on the name and the number of shards, so it is stable across
+// runs.
+func (o *CommandOptions) shardOf(username string) int {
+	if o.ignoreCase {
+		username = strings.ToLower(username)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	return int(h.Sum32() % uint32(o.shards))
+}
+
+// shardName returns the name of the secret holding the given shard.
+func shardName(base string, shard int) string {
+	return fmt.Sprintf("%s-%d", base, shard)
+}
+
+// runShards runs the operation on the shards of o.secretName. Operations on
+// users only touch the shards of those users, where adding a user creates a
+// missing shard, the others run on every existing shard.
+func (o *CommandOptions) runShards(ctx context.Context) error {
+	base := o.secretName
+	defer func() { o.secretName = base }()
+	if o.listUsers {
+		return o.runShardedList(ctx, base)
+	}
+
+	if len(o.usernames) == 0 {
+		found := 0
+		for i := 0; i < o.shards; i++ {
+			o.secretName = shardName(base, i)
+			if exists, err := o.shardExists(ctx); err != nil {
+				return err
+			} else if !exists {
+				continue
+			}
+			found++
+			if err := o.runWithRetry(ctx); err != nil {
+				return err
+			}
+		}
+		if found == 0 {
+			return notFoundError("no shard of secret %q found in namespace %q", base, o.namespace)
+		}
+		return nil
+	}
+
+	groups := make(map[int][]string)
+	for _, u := range o.usernames {
+		shard := o.shardOf(u)
+		groups[shard] = append(groups[shard], u)
+	}
+	var shards []int
+	for shard := range groups {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	if o.setsPassword() && !o.rotate && !o.createSecret {
+		o.createSecret, o.ifNotExists = true, true
+	}
+	usernames := o.usernames
+	defer func() { o.usernames = usernames }()
+	for _, shard := range shards {
+		o.secretName = shardName(base, shard)
+		o.usernames = groups[shard]
+		o.username = strings.Join(o.usernames, ",")
+		o.logf(logDetails, "Using shard %q", o.secretName)
+		if err := o.runWithRetry(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardExists reports whether the shard o.secretName exists. Missing shards
+// simply hold no users yet.
+func (o *CommandOptions) shardExists(ctx context.Context) (bool, error) {
+	_, err := o.secrets().Get(ctx, o.secretName)
+	if apierrors.IsNotFound(err) {
+		o.logf(logDetails, "Skipping missing shard %q", o.secretName)
+		return false, nil
+	} else if err != nil {
+		return false, apiError(err, "unable to get secret %q", o.secretName)
+	}
+	return true, nil
+}
+
+// runShardedList lists the users of all shards as if they were stored in a
+// single secret.
+func (o *CommandOptions) runShardedList(ctx context.Context, base string) error {
+	merged := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: base, Namespace: o.namespace, Annotations: make(map[string]string)},
+		Data:       make(map[string][]byte),
+	}
+	found := 0
+	for i := 0; i < o.shards; i++ {
+		o.secretName = shardName(base, i)
+		if exists, err := o.shardExists(ctx); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		found++
+		secret, err := o.getSecret(ctx)
+		if err != nil {
+			return err
+		}
+		for _, key := range o.keyNames {
+			data := secret.Data[key]
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				data = append(data, '\n')
+			}
+			merged.Data[key] = append(merged.Data[key], data...)
+		}
+		if err := mergeUserAnnotations(merged, secret); err != nil {
+			return err
+		}
+	}
+	if found == 0 {
+		return notFoundError("no shard of secret %q found in namespace %q", base, o.namespace)
+	}
+	files, err := o.loadPasswordFiles(merged)
+	if err != nil {
+		return err
+	}
+	return o.runList(merged, files)
+}
+
+// mergeUserAnnotations adds the per-user annotations of shard to merged.
+func mergeUserAnnotations(merged, shard *v1.Secret) error {
+	for _, annotation := range shardedAnnotations {
+		data, ok := shard.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		users := make(map[string]json.RawMessage)
+		if existing, ok := merged.Annotations[annotation]; ok {
+			if err := json.Unmarshal([]byte(existing), &users); err != nil {
+				return err
+			}
+		}
+		if err := json.Unmarshal([]byte(data), &users); err != nil {
+			return fmt.Errorf("invalid %s annotation of secret %q: %v", annotation, shard.Name, err)
+		}
+		encoded, _ := json.Marshal(users)
+		merged.Annotations[annotation] = string(encoded)
+	}
+	return nil
+}
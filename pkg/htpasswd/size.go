@@ -0,0 +1,79 @@
+package htpasswd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxSecretSize is the maximum total size of the data of a secret
+	// accepted by the API server.
+	maxSecretSize = 1024 * 1024
+
+	// defaultSizeWarn is the default of --size-warn, the percentage of
+	// maxSecretSize above which a warning is printed.
+	defaultSizeWarn = 90
+)
+
+// secretSize returns the size of the secret data as counted by the API
+// server.
+func secretSize(secret *v1.Secret) int {
+	size := 0
+	for key, value := range secret.Data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// checkSize warns when the secret approaches the size limit and fails if
+// it is exceeded or --strict is set. Local files have no size limit.
+func (o *CommandOptions) checkSize(secret *v1.Secret, files []*keyFile) error {
+	if o.local {
+		return nil
+	}
+	size := secretSize(secret)
+	if !o.nearSizeLimit(size) {
+		return nil
+	}
+
+	users, entrySize := 0, 0
+	for _, f := range files {
+		for username, hash := range f.passwords {
+			users++
+			entrySize += len(username) + len(hash) + 2
+		}
+	}
+	msg := fmt.Sprintf("secret %q uses %d of %d bytes", secret.Name, size, maxSecretSize)
+	if users > 0 {
+		fit := users + (maxSecretSize-size)*users/entrySize
+		msg += fmt.Sprintf(", about %d users of the current average size fit", fit)
+	}
+	if o.shards == 0 {
+		msg += ", use --shards to spread the users over several secrets"
+	}
+	if size > maxSecretSize || o.strict {
+		return fmt.Errorf("%s", msg)
+	}
+	fmt.Fprintf(o.ErrOut, "Warning: %s\n", msg)
+	return nil
+}
+
+func (o *CommandOptions) addSizeWarnFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&o.sizeWarn, "size-warn", "", defaultSizeWarn, "Warn when the secret uses more than this percentage of the 1 MiB size limit")
+}
+
+// validateSizeWarn checks --size-warn.
+func (o *CommandOptions) validateSizeWarn() error {
+	if o.sizeWarn < 1 || o.sizeWarn > 100 {
+		return fmt.Errorf("--size-warn must be a percentage between 1 and 100")
+	}
+	return nil
+}
+
+// nearSizeLimit reports whether size exceeds the --size-warn threshold.
+func (o *CommandOptions) nearSizeLimit(size int) bool {
+	return size*100 >= o.sizeWarn*maxSecretSize
+}
@@ -0,0 +1,281 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// syncOptions holds the flags of the sync subcommand.
+type syncOptions struct {
+	fromFile   string
+	fromSecret string
+	namespaces []string
+	prune      bool
+}
+
+// newSyncCommand returns the sync subcommand which makes a secret in several
+// namespaces hold the same users as a canonical source.
+func newSyncCommand(o *CommandOptions) *cobra.Command {
+	var s syncOptions
+	cmd := &cobra.Command{
+		Use:   "sync SECRET --namespaces NS,... (--from-file FILE | --from-secret [NAMESPACE/]NAME)",
+		Short: "Make a secret in several namespaces match a canonical set of users",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateSync(&s); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunSync(ctx, &s)
+		},
+	}
+	cmd.Flags().StringVarP(&s.fromFile, "from-file", "f", "", "Local htpasswd file holding the canonical users, - for stdin")
+	cmd.Flags().StringVarP(&s.fromSecret, "from-secret", "", "", "Secret holding the canonical users, in the current namespace unless given as NAMESPACE/NAME")
+	cmd.Flags().StringSliceVarP(&s.namespaces, "namespaces", "", nil, "Namespaces of the target secrets")
+	cmd.Flags().BoolVarP(&s.prune, "prune", "", false, "Remove users missing in the source from the targets")
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit secrets even if they are managed by another controller")
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when a secret approaches the size limit")
+	o.addDryRunFlag(cmd)
+	return cmd
+}
+
+// validateSync checks the flags and arguments of the sync subcommand.
+func (o *CommandOptions) validateSync(s *syncOptions) error {
+	if err := o.validateDryRun(); err != nil {
+		return err
+	}
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("sync doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("sync only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 1 {
+		return fmt.Errorf("secret is required")
+	}
+	o.secretName = o.args[0]
+	if (s.fromFile == "") == (s.fromSecret == "") {
+		return fmt.Errorf("exactly one of --from-file and --from-secret is required")
+	}
+	if len(s.namespaces) == 0 {
+		return fmt.Errorf("--namespaces is required")
+	}
+	return nil
+}
+
+// userDiff lists the differences of a target to the source.
+type userDiff struct {
+	added, updated, removed []string
+}
+
+func (d userDiff) empty() bool {
+	return len(d.added)+len(d.updated)+len(d.removed) == 0
+}
+
+func (d userDiff) String() string {
+	var parts []string
+	for _, p := range []struct {
+		prefix string
+		users  []string
+	}{{"+", d.added}, {"~", d.updated}, {"-", d.removed}} {
+		for _, u := range p.users {
+			parts = append(parts, p.prefix+u)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// diffUsers compares target to source. Users only present in target are
+// only reported as removed if prune is set.
+func diffUsers(source, target *passwordFile, prune bool) userDiff {
+	var d userDiff
+	users, _ := source.ListUsers()
+	for _, u := range users {
+		existing, ok := target.lookup(u)
+		switch {
+		case !ok:
+			d.added = append(d.added, u)
+		case target.passwords[existing] != source.passwords[u]:
+			d.updated = append(d.updated, u)
+		}
+	}
+	if prune {
+		users, _ = target.ListUsers()
+		for _, u := range users {
+			if _, ok := source.lookup(u); !ok {
+				d.removed = append(d.removed, u)
+			}
+		}
+	}
+	return d
+}
+
+// RunSync reconciles the target secrets with the source.
+func (o *CommandOptions) RunSync(ctx context.Context, s *syncOptions) error {
+	sources, err := o.loadSources(ctx, s.fromFile, s.fromSecret)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, ns := range s.namespaces {
+		o.namespace = ns
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			return o.syncSecret(ctx, sources, s.prune)
+		})
+		if err != nil {
+			fmt.Fprintf(o.ErrOut, "%s/%s: error: %v\n", ns, o.secretName, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d of %d secrets", failed, len(s.namespaces))
+	}
+	return nil
+}
+
+// loadSources returns the htpasswd data for every key, either from a local
+// file (- for stdin) used for all keys or from the same keys of the secret
+// fromSecret, given as [NAMESPACE/]NAME.
+func (o *CommandOptions) loadSources(ctx context.Context, fromFile, fromSecret string) (map[string]*passwordFile, error) {
+	sources := make(map[string]*passwordFile)
+	if fromFile != "" {
+		var data []byte
+		var err error
+		if fromFile == "-" {
+			data, err = ioutil.ReadAll(o.In)
+		} else {
+			data, err = ioutil.ReadFile(fromFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %q: %v", fromFile, err)
+		}
+		f, err := newPasswordFile(data, o.hasher, o.ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fromFile, err)
+		}
+		for _, key := range o.keyNames {
+			sources[key] = f
+		}
+		return sources, nil
+	}
+
+	client := o.secrets()
+	var name string
+	client.ns, name = splitNamespacedName(fromSecret, client.ns)
+	secret, err := client.Get(ctx, name)
+	if err != nil {
+		return nil, apiError(err, "unable to get source secret %q", fromSecret)
+	}
+	for _, key := range o.keyNames {
+		data, ok := secret.Data[key]
+		if !ok {
+			return nil, notFoundError("source secret %q has no key %q", fromSecret, key)
+		}
+		f, err := newPasswordFile(data, o.hasher, o.ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("source secret %q, key %q: %v", fromSecret, key, err)
+		}
+		sources[key] = f
+	}
+	return sources, nil
+}
+
+// syncSecret updates or creates the secret in o.namespace. The differences
+// are printed before the secret is saved.
+func (o *CommandOptions) syncSecret(ctx context.Context, sources map[string]*passwordFile, prune bool) error {
+	target := fmt.Sprintf("%s/%s", o.namespace, o.secretName)
+	secret, err := o.secrets().Get(ctx, o.secretName)
+	o.createSecret = apierrors.IsNotFound(err)
+	if o.createSecret {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: o.secretName, Namespace: o.namespace},
+			Type:       v1.SecretTypeOpaque,
+			Data:       make(map[string][]byte),
+		}
+	} else if err != nil {
+		return err
+	} else if secret.Type != v1.SecretTypeOpaque {
+		return fmt.Errorf("invalid secret type %q", secret.Type)
+	} else if manager := managedBy(secret); manager != "" {
+		if !o.force {
+			return fmt.Errorf("secret is managed by %s, use --force to sync it anyway", manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %s is managed by %s\n", target, manager)
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	changed := o.createSecret
+	changedUsers := make(map[string]bool)
+	var files []*keyFile
+	for _, key := range o.keyNames {
+		current, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		source := sources[key]
+		d := diffUsers(source, current, prune)
+		switch {
+		case o.createSecret:
+			o.logf(logNormal, "%s: create key %q: %s", target, key, d)
+		case d.empty():
+			o.logf(logNormal, "%s: key %q is up to date", target, key)
+			continue
+		default:
+			o.logf(logNormal, "%s: key %q: %s", target, key, d)
+		}
+		for _, u := range append(d.added, d.updated...) {
+			current.SetHash(u, source.passwords[u])
+			changedUsers[u] = true
+		}
+		for _, u := range d.removed {
+			if err := current.DeleteUser(u); err != nil {
+				return err
+			}
+			changedUsers[u] = true
+		}
+		secret.Data[key] = current.Bytes()
+		files = append(files, &keyFile{passwordFile: current, key: key})
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return err
+	}
+	var users []string
+	for u := range changedUsers {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	o.recordChange(secret, "sync", users, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return err
+	}
+	verb := "Updated"
+	if o.createSecret {
+		verb = "Created"
+	}
+	o.logf(logNormal, "%s secret %s%s", verb, target, o.dryRunSuffix())
+	return nil
+}
@@ -0,0 +1,101 @@
+package htpasswd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// targets lists the hash algorithms, as named by hashAlgorithm, each consumer
+// of htpasswd data is able to check.
+var targets = map[string][]string{
+	// Apache uses APR, which falls back to crypt(3) for unknown formats
+	"apache": {"apr1", "bcrypt", "crypt", "md5-crypt", "sha1", "sha256-crypt", "sha512-crypt"},
+	// nginx uses crypt(3), the ingress controller image is based on musl
+	"nginx":        {"apr1", "bcrypt", "crypt", "md5-crypt", "sha1", "sha256-crypt", "sha512-crypt"},
+	"oauth2-proxy": {"bcrypt", "sha1"},
+	"traefik":      {"apr1", "bcrypt", "digest", "md5-crypt", "sha1"},
+}
+
+// hasherAlgorithms maps the --hash names to the algorithm of their hashes.
+var hasherAlgorithms = map[string]string{
+	"apr1":     "apr1",
+	"argon2id": "argon2id",
+	"bcrypt":   "bcrypt",
+	"crypt":    "crypt",
+	"md5":      "apr1",
+	"plain":    "plain",
+	"sha":      "sha1",
+	"sha256":   "sha256-crypt",
+	"sha512":   "sha512-crypt",
+}
+
+// targetNames returns the sorted names of the supported --target consumers.
+func targetNames() []string {
+	var names []string
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetSupports reports whether target can check hashes of algorithm. Any
+// algorithm is fine without a target.
+func targetSupports(target, algorithm string) bool {
+	if target == "" {
+		return true
+	}
+	for _, a := range targets[target] {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// targetHashes returns the --hash names target supports.
+func targetHashes(target string) []string {
+	var names []string
+	for _, name := range hasherNames() {
+		if targetSupports(target, hasherAlgorithms[name]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateTarget checks that --target can check the hashes of new passwords.
+func (o *CommandOptions) validateTarget() error {
+	if o.target == "" || !o.setsPassword() {
+		return nil
+	}
+	algorithm := hasherAlgorithms[o.hashName]
+	if o.format == formatHTDigest {
+		algorithm = "digest"
+	}
+	if !targetSupports(o.target, algorithm) {
+		if algorithm == "digest" {
+			return fmt.Errorf("%s doesn't read htdigest data", o.target)
+		}
+		return fmt.Errorf("%s can't check %s hashes, use --hash with one of: %s", o.target, algorithm, strings.Join(targetHashes(o.target), ", "))
+	}
+	return nil
+}
+
+// warnTarget warns about the entries of f which --target can't check, as
+// those users can't log in.
+func (o *CommandOptions) warnTarget(key string, f *passwordFile) {
+	if o.target == "" {
+		return
+	}
+	users, _ := f.ListUsers()
+	for _, u := range users {
+		if algorithm := f.algorithm(u); !targetSupports(o.target, algorithm) {
+			if algorithm == "" {
+				algorithm = "unknown"
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: key %q: %s can't check the %s hash of user %q\n", key, o.target, algorithm, u)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package htpasswd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// middlewareResource is the Traefik v2 Middleware custom resource.
+var middlewareResource = schema.GroupVersionResource{
+	Group:    "traefik.containo.us",
+	Version:  "v1alpha1",
+	Resource: "middlewares",
+}
+
+// traefikUsersKey is the key Traefik reads the htpasswd data from.
+const traefikUsersKey = "users"
+
+func (o *CommandOptions) addTraefikFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.traefik, "traefik", "", false, "Also create or update a Traefik basicAuth (digestAuth with --format htdigest) Middleware referencing the secret")
+	cmd.Flags().StringVarP(&o.middlewareName, "middleware-name", "", "", "Name of the Traefik Middleware, defaults to the name of the secret")
+}
+
+// validateTraefik checks the flags of the Traefik integration.
+func (o *CommandOptions) validateTraefik() error {
+	if !o.traefik {
+		if o.middlewareName != "" {
+			return fmt.Errorf("--middleware-name requires --traefik")
+		}
+		return nil
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("--traefik can't be combined with --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("--traefik only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.keyNames) != 1 || o.keyNames[0] != traefikUsersKey {
+		return fmt.Errorf("traefik reads the htpasswd data from key %q, use --controller traefik", traefikUsersKey)
+	}
+	return nil
+}
+
+// applyTraefikMiddleware creates the Middleware for the secret or points an
+// existing one at it. Other settings of an existing Middleware are kept.
+func (o *CommandOptions) applyTraefikMiddleware(ctx context.Context) error {
+	name := o.middlewareName
+	if name == "" {
+		name = o.secretName
+	}
+	client, err := dynamic.NewForConfig(o.restConfig)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var dryRun []string
+	if o.dryRun == dryRunServer {
+		dryRun = []string{metav1.DryRunAll}
+	}
+	middlewares := client.Resource(middlewareResource).Namespace(o.namespace)
+
+	middleware, err := middlewares.Get(name, metav1.GetOptions{})
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		middleware = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": middlewareResource.GroupVersion().String(),
+			"kind":       "Middleware",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": o.namespace,
+			},
+		}}
+	} else if err != nil {
+		return apiError(err, "unable to get Traefik Middleware %q", name)
+	}
+	// htdigest data is served by the digestAuth middleware
+	auth := "basicAuth"
+	if o.format == formatHTDigest {
+		auth = "digestAuth"
+	}
+	if err := unstructured.SetNestedField(middleware.Object, o.secretName, "spec", auth, "secret"); err != nil {
+		return err
+	}
+	if o.realm != "" {
+		if err := unstructured.SetNestedField(middleware.Object, o.realm, "spec", auth, "realm"); err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		_, err = middlewares.Update(middleware, metav1.UpdateOptions{DryRun: dryRun})
+	} else {
+		_, err = middlewares.Create(middleware, metav1.CreateOptions{DryRun: dryRun})
+	}
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to save Traefik Middleware %q, is Traefik v2 installed? %v", name, err)
+	} else if err != nil {
+		return apiError(err, "unable to save Traefik Middleware %q", name)
+	}
+	verb := "Updated"
+	if !exists {
+		verb = "Created"
+	}
+	o.logf(logNormal, "%s Traefik Middleware %q using secret %q%s", verb, name, o.secretName, o.dryRunSuffix())
+	return nil
+}
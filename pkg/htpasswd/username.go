@@ -0,0 +1,102 @@
+package htpasswd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/text/unicode/norm"
+)
+
+func (o *CommandOptions) addAllowUnicodeFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&o.allowUnicode, "allow-unicode", "", false, "Accept non-ASCII usernames without a warning, normalizing them to NFC")
+}
+
+// checkUsername validates a username about to be stored. Names which would
+// break the htpasswd file or not match when read back are rejected, unusual
+// ones only produce warnings. The returned name has surrounding whitespace
+// removed, as the file is read that way, and with allowUnicode is in NFC so
+// differently composed spellings map to the same entry.
+func checkUsername(username string, allowUnicode bool) (string, []string, error) {
+	if !utf8.ValidString(username) {
+		return "", nil, fmt.Errorf("invalid username %q: not valid UTF-8", username)
+	}
+	var warnings []string
+	if trimmed := strings.TrimSpace(username); trimmed != username {
+		warnings = append(warnings, fmt.Sprintf("removed the whitespace around username %q", username))
+		username = trimmed
+	}
+	switch {
+	case username == "":
+		return "", nil, fmt.Errorf("username must not be empty")
+	case strings.Contains(username, ":"):
+		return "", nil, fmt.Errorf("invalid username %q: must not contain a colon, it separates the username from the hash", username)
+	case strings.ContainsAny(username, "\r\n"):
+		return "", nil, fmt.Errorf("invalid username %q: must not contain line breaks", username)
+	case strings.HasPrefix(username, "#"):
+		return "", nil, fmt.Errorf("invalid username %q: must not start with #, the entry would be read as a comment", username)
+	}
+	var space, control, nonASCII bool
+	for _, r := range username {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+		case unicode.IsControl(r):
+			control = true
+		}
+		if r > unicode.MaxASCII {
+			nonASCII = true
+		}
+	}
+	if space {
+		warnings = append(warnings, fmt.Sprintf("username %q contains whitespace", username))
+	}
+	if control {
+		warnings = append(warnings, fmt.Sprintf("username %q contains control characters", username))
+	}
+	if nonASCII {
+		if allowUnicode {
+			username = norm.NFC.String(username)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("username %q contains non-ASCII characters, which clients may encode differently, pass --allow-unicode to accept them", username))
+		}
+	}
+	return username, warnings, nil
+}
+
+// validateNewUsernames checks the usernames an operation is going to store
+// and prints the warnings.
+func (o *CommandOptions) validateNewUsernames() error {
+	if o.renaming {
+		name, warnings, err := checkUsername(o.renameTo, o.allowUnicode)
+		if err != nil {
+			return err
+		}
+		o.renameTo = name
+		o.printUsernameWarnings(warnings)
+		return nil
+	}
+	if !o.setsPassword() || o.rotate || o.rehash {
+		return nil
+	}
+	for i, u := range o.usernames {
+		name, warnings, err := checkUsername(u, o.allowUnicode)
+		if err != nil {
+			return err
+		}
+		o.usernames[i] = name
+		o.printUsernameWarnings(warnings)
+	}
+	if len(o.usernames) == 1 {
+		o.username = o.usernames[0]
+	}
+	return nil
+}
+
+func (o *CommandOptions) printUsernameWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(o.ErrOut, "Warning: %s\n", w)
+	}
+}
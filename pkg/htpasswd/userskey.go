@@ -0,0 +1,121 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// usersKeyAnnotation names the key of the secret holding the plain list of
+// usernames, so it's kept up to date by every later modification.
+const usersKeyAnnotation = "htpasswd.kubectl.io/users-key"
+
+// usersKeyNone as --users-key stops maintaining the user list and removes it.
+const usersKeyNone = "none"
+
+func (o *CommandOptions) addUsersKeyFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.usersKey, "users-key", "", "", "Also maintain this key with the usernames only, one per line or as JSON array if it ends in .json. none removes it")
+}
+
+// validateUsersKey checks --users-key.
+func (o *CommandOptions) validateUsersKey() error {
+	if o.usersKey == "" || o.usersKey == usersKeyNone {
+		return nil
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("--users-key isn't supported for basic-auth secrets")
+	}
+	if errs := validation.IsConfigMapKey(o.usersKey); len(errs) > 0 {
+		return fmt.Errorf("invalid key name %q: %s", o.usersKey, strings.Join(errs, ", "))
+	}
+	for _, key := range o.keyNames {
+		if key == o.usersKey {
+			return fmt.Errorf("--users-key must differ from --key-name")
+		}
+	}
+	return nil
+}
+
+// updateUsersKey regenerates the user list of secret from all its keys with
+// htpasswd data. The key is taken from --users-key or the annotation left by
+// an earlier run.
+func (o *CommandOptions) updateUsersKey(secret *v1.Secret) error {
+	if secret.Type == v1.SecretTypeBasicAuth {
+		return nil
+	}
+	key := o.usersKey
+	previous := secret.Annotations[usersKeyAnnotation]
+	if key == "" {
+		key = previous
+	}
+	if key == "" {
+		return nil
+	}
+	if key == usersKeyNone {
+		if previous != "" {
+			delete(secret.Data, previous)
+			delete(secret.Annotations, usersKeyAnnotation)
+			o.logf(logDetails, "Removed user list key %q", previous)
+		}
+		return nil
+	}
+	for _, name := range o.keyNames {
+		if name == key {
+			return fmt.Errorf("key %q holds the user list of the secret, use --users-key %s first", key, usersKeyNone)
+		}
+	}
+	if previous != "" && previous != key {
+		delete(secret.Data, previous)
+	}
+
+	seen := make(map[string]bool)
+	users := []string{}
+	var names []string
+	for name := range secret.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == key || !looksLikePasswordFile(secret.Data[name]) {
+			continue
+		}
+		f, err := newPasswordFile(secret.Data[name], nil, o.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", name, err)
+		}
+		list, _ := f.ListUsers()
+		for _, u := range list {
+			if !seen[u] {
+				seen[u] = true
+				users = append(users, u)
+			}
+		}
+	}
+	sort.Strings(users)
+
+	var data []byte
+	if strings.HasSuffix(key, ".json") {
+		data, _ = json.Marshal(users)
+		data = append(data, '\n')
+	} else {
+		for _, u := range users {
+			data = append(data, u+"\n"...)
+		}
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[key] = data
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[usersKeyAnnotation] = key
+	o.logf(logDetails, "Updated user list key %q with %d users", key, len(users))
+	return nil
+}
@@ -0,0 +1,669 @@
+package htpasswd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// userSpecFile declares the users of a secret. A YAML file may hold several
+// of them as separate documents.
+type userSpecFile struct {
+	Secret    string     `json:"secret,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Users     []userSpec `json:"users"`
+
+	// owners are set by the controller and become the owner references of
+	// the secret, so secrets it manages can be told apart.
+	owners []metav1.OwnerReference
+	// key replaces --key-name and passwordsSecret receives the generated
+	// passwords, both only used by the controller.
+	key, passwordsSecret string
+}
+
+// userSpec declares a single user. Either Hash is set or Generate, in which
+// case a password is only generated if the user doesn't exist yet, hashed
+// with Algorithm instead of --hash if set.
+type userSpec struct {
+	Name      string `json:"name"`
+	Hash      string `json:"hash,omitempty"`
+	Generate  bool   `json:"generate,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+
+	expires time.Time
+	hasher  Hasher
+}
+
+// applyOptions holds the flags of the apply subcommand.
+type applyOptions struct {
+	filename      string
+	fromConfigMap string
+	configMapKey  string
+}
+
+// newApplyCommand returns the apply subcommand which reconciles secrets
+// with a declarative list of users.
+func newApplyCommand(o *CommandOptions) *cobra.Command {
+	var a applyOptions
+	cmd := &cobra.Command{
+		Use:   "apply [SECRET] (-f FILE | --from-configmap [NAMESPACE/]NAME)",
+		Short: "Make secrets hold exactly the users declared in a YAML or CSV file",
+		Long: `Make secrets hold exactly the users declared in a YAML or CSV file.
+
+Each YAML document names its secret, unless SECRET is given, and lists the
+users:
+
+  secret: gateway
+  users:
+  - name: alice
+    hash: $2y$10$...
+  - name: bob
+    generate: true
+    algorithm: argon2id
+    expires: 2026-12-31
+    comment: Bob from SRE
+
+CSV files have a header line with the columns name, hash, generate,
+algorithm, expires and comment and need SECRET. Users missing in the file are
+deleted, generated passwords are only set for new users, hashed with
+algorithm or --hash, and printed once.
+
+With --from-configmap the users are read from a key of a ConfigMap instead,
+which keeps the list of usernames next to the other configuration of a team.
+Keys ending in .yaml, .yml or .csv are read like files, otherwise the key holds
+one username per line and passwords are generated for new users.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.validateApplySpec(&a); err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunApplySpec(ctx, &a)
+		},
+	}
+	cmd.Flags().StringVarP(&a.filename, "filename", "f", "", "YAML or CSV file declaring the users, - for stdin")
+	cmd.Flags().StringVarP(&a.fromConfigMap, "from-configmap", "", "", "ConfigMap declaring the users, in the current namespace unless given as NAMESPACE/NAME")
+	cmd.Flags().StringVarP(&a.configMapKey, "configmap-key", "", "users", "Key of --from-configmap holding the users")
+	cmd.Flags().StringVarP(&o.passwordsFile, "passwords-file", "", "", "Write the generated passwords to this file instead of stdout")
+	cmd.Flags().BoolVarP(&o.force, "force", "", false, "Edit secrets even if they are managed by another controller")
+	cmd.Flags().BoolVarP(&o.strict, "strict", "", false, "Fail instead of warning when a secret approaches the size limit")
+	o.addSizeWarnFlag(cmd)
+	o.addUsersKeyFlag(cmd)
+	o.addCharsetFlags(cmd)
+	o.addHashFlags(cmd)
+	o.addOverridePolicyFlag(cmd)
+	o.addAllowUnicodeFlag(cmd)
+	o.addDryRunFlag(cmd)
+	return cmd
+}
+
+// validateApplySpec checks the flags and arguments of the apply subcommand.
+func (o *CommandOptions) validateApplySpec(a *applyOptions) error {
+	if err := o.validateDryRun(); err != nil {
+		return err
+	}
+	if err := o.validateKeyNames(); err != nil {
+		return err
+	}
+	if err := o.validateSizeWarn(); err != nil {
+		return err
+	}
+	if err := o.validateUsersKey(); err != nil {
+		return err
+	}
+	if o.fromManifest != "" {
+		return fmt.Errorf("apply doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return fmt.Errorf("apply only supports htpasswd data, not basic-auth secrets")
+	}
+	if (a.filename == "") == (a.fromConfigMap == "") {
+		return fmt.Errorf("exactly one of --filename and --from-configmap is required")
+	}
+	if a.fromConfigMap != "" && a.configMapKey == "" {
+		return fmt.Errorf("--configmap-key must not be empty")
+	}
+	if len(o.args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	if len(o.args) == 1 {
+		o.secretName = o.args[0]
+	}
+	if o.generateLength < 8 {
+		return fmt.Errorf("--length must be at least 8")
+	}
+	hasher, err := newHasher(o.hashName, o.hashOptions())
+	if err != nil {
+		return err
+	}
+	o.hasher = hasher
+	if err := o.validateFormat(); err != nil {
+		return err
+	}
+	return o.validateTarget()
+}
+
+// readUserSpecs reads the declared users from --filename or
+// --from-configmap.
+func (o *CommandOptions) readUserSpecs(ctx context.Context, a *applyOptions) ([]*userSpecFile, error) {
+	if a.fromConfigMap != "" {
+		return o.readConfigMapSpecs(ctx, a.fromConfigMap, a.configMapKey)
+	}
+	var data []byte
+	var err error
+	if a.filename == "-" {
+		data, err = ioutil.ReadAll(o.In)
+	} else {
+		data, err = ioutil.ReadFile(a.filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", a.filename, err)
+	}
+	return parseUserSpecs(a.filename, data)
+}
+
+// readConfigMapSpecs reads the declared users from key of the ConfigMap
+// name, given as [NAMESPACE/]NAME.
+func (o *CommandOptions) readConfigMapSpecs(ctx context.Context, name, key string) ([]*userSpecFile, error) {
+	client := o.secrets()
+	var cmName string
+	client.ns, cmName = splitNamespacedName(name, client.ns)
+	cm, err := client.GetConfigMap(ctx, cmName)
+	if apierrors.IsNotFound(err) {
+		return nil, notFoundError("configmap %q not found in namespace %q", cmName, client.ns)
+	} else if err != nil {
+		return nil, apiError(err, "unable to get configmap %q", name)
+	}
+	return parseConfigMapSpecs(cm, key)
+}
+
+// parseConfigMapSpecs parses the declared users in key of cm. Keys ending in
+// .yaml, .yml or .csv are read like files, otherwise the key holds one
+// username per line.
+func parseConfigMapSpecs(cm *v1.ConfigMap, key string) ([]*userSpecFile, error) {
+	name := cm.Namespace + "/" + cm.Name
+	data, ok := cm.Data[key]
+	if !ok {
+		return nil, notFoundError("configmap %q has no key %q", name, key)
+	}
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".yaml", ".yml", ".csv":
+		return parseUserSpecs(fmt.Sprintf("configmap %s, key %s", name, key), []byte(data))
+	}
+	spec := &userSpecFile{}
+	for _, l := range strings.Split(data, "\n") {
+		l = strings.TrimSpace(l)
+		if isComment(l) {
+			continue
+		}
+		spec.Users = append(spec.Users, userSpec{Name: l, Generate: true})
+	}
+	// an empty list would delete every user of the secret
+	if len(spec.Users) == 0 {
+		return nil, fmt.Errorf("configmap %s, key %s: no users declared", name, key)
+	}
+	return []*userSpecFile{spec}, nil
+}
+
+// parseUserSpecs parses the YAML documents or, for names ending in .csv, the
+// CSV table in data.
+func parseUserSpecs(filename string, data []byte) ([]*userSpecFile, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		spec, err := parseUserCSV(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return []*userSpecFile{spec}, nil
+	}
+
+	var specs []*userSpecFile
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		spec := &userSpecFile{}
+		if err := yaml.UnmarshalStrict(doc, spec); err != nil {
+			return nil, fmt.Errorf("%s: document %d: %v", filename, i, err)
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%s: no users declared", filename)
+	}
+	return specs, nil
+}
+
+// parseUserCSV reads a table of users with a header line naming the
+// columns.
+func parseUserCSV(data []byte) (*userSpecFile, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header line")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "name", "hash", "generate", "algorithm", "expires", "comment":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("missing column \"name\"")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	spec := &userSpecFile{}
+	for n, record := range records[1:] {
+		u := userSpec{
+			Name:      field(record, "name"),
+			Hash:      field(record, "hash"),
+			Algorithm: field(record, "algorithm"),
+			Expires:   field(record, "expires"),
+			Comment:   field(record, "comment"),
+		}
+		if value := field(record, "generate"); value != "" {
+			if u.Generate, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("line %d: invalid generate value %q", n+2, value)
+			}
+		}
+		spec.Users = append(spec.Users, u)
+	}
+	return spec, nil
+}
+
+// validateUserSpec checks the declared users and resolves the expiry times.
+func (o *CommandOptions) validateUserSpec(spec *userSpecFile) error {
+	seen := make(map[string]bool)
+	for i := range spec.Users {
+		u := &spec.Users[i]
+		name, warnings, err := checkUsername(u.Name, o.allowUnicode)
+		if err != nil {
+			return err
+		}
+		if o.ignoreCase {
+			name = strings.ToLower(name)
+		}
+		u.Name = name
+		o.printUsernameWarnings(warnings)
+		if seen[name] {
+			return fmt.Errorf("user %q is declared more than once", name)
+		}
+		seen[name] = true
+
+		switch {
+		case u.Hash != "" && u.Generate:
+			return fmt.Errorf("user %q: hash and generate are mutually exclusive", name)
+		case u.Hash == "" && !u.Generate:
+			return fmt.Errorf("user %q needs a hash or generate: true", name)
+		case u.Hash != "" && hashAlgorithm(u.Hash) == "":
+			return fmt.Errorf("user %q: unknown hash format", name)
+		case u.Hash != "":
+			if err := checkHashFormat(u.Hash); err != nil {
+				return fmt.Errorf("user %q: %v", name, err)
+			}
+		}
+		if u.Algorithm != "" {
+			if !u.Generate {
+				return fmt.Errorf("user %q: algorithm only applies to generated passwords", name)
+			}
+			hasher, err := newHasher(u.Algorithm, o.hashOptions())
+			if err != nil {
+				return fmt.Errorf("user %q: %v", name, err)
+			}
+			u.hasher = hasher
+			if !targetSupports(o.target, hasherAlgorithms[u.Algorithm]) {
+				return fmt.Errorf("user %q: %s can't check %s hashes", name, o.target, hasherAlgorithms[u.Algorithm])
+			}
+		}
+		if u.Hash != "" && !targetSupports(o.target, hashAlgorithm(u.Hash)) {
+			return fmt.Errorf("user %q: %s can't check %s hashes", name, o.target, hashAlgorithm(u.Hash))
+		}
+		if strings.ContainsAny(u.Comment, "\r\n") {
+			return fmt.Errorf("user %q: comment must be a single line", name)
+		}
+		if u.Expires != "" {
+			// durations would move the expiry on every run
+			t, err := time.Parse("2006-01-02", u.Expires)
+			if err == nil {
+				t = t.AddDate(0, 0, 1)
+			} else if t, err = time.Parse(time.RFC3339, u.Expires); err != nil {
+				return fmt.Errorf("user %q: invalid expires %q, must be a date like 2006-01-02 or an RFC 3339 time", name, u.Expires)
+			}
+			u.expires = t.UTC()
+		}
+	}
+	return nil
+}
+
+// RunApplySpec reconciles every declared secret and hands out the generated
+// passwords once all secrets were saved.
+func (o *CommandOptions) RunApplySpec(ctx context.Context, a *applyOptions) error {
+	specs, err := o.readUserSpecs(ctx, a)
+	if err != nil {
+		return err
+	}
+	secretArg, namespace := o.secretName, o.namespace
+	for i, spec := range specs {
+		if spec.Secret == "" {
+			spec.Secret = secretArg
+		}
+		if spec.Secret == "" {
+			return validationError(fmt.Errorf("document %d names no secret, pass SECRET", i+1))
+		}
+		if spec.Namespace == "" {
+			spec.Namespace = namespace
+		}
+		if err := o.validateUserSpec(spec); err != nil {
+			return validationError(fmt.Errorf("secret %q: %v", spec.Secret, err))
+		}
+	}
+	if err := o.enforceClusterPolicy(ctx, o.specHashNames(specs)); err != nil {
+		return err
+	}
+
+	var labels []string
+	passwords := make(map[string]string)
+	for _, spec := range specs {
+		o.secretName, o.namespace = spec.Secret, spec.Namespace
+		var generated map[string]string
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			var err error
+			generated, err = o.applyUserSpec(ctx, spec)
+			return err
+		})
+		if err != nil {
+			return apiError(err, "%s/%s", spec.Namespace, spec.Secret)
+		}
+		var users []string
+		for u := range generated {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		for _, u := range users {
+			label := u
+			if len(specs) > 1 {
+				label = fmt.Sprintf("%s/%s/%s", spec.Namespace, spec.Secret, u)
+			}
+			labels = append(labels, label)
+			passwords[label] = generated[u]
+		}
+	}
+	if len(labels) == 0 || o.dryRun != "" {
+		return nil
+	}
+	return o.writePasswords(ctx, labels, passwords)
+}
+
+// applyUserSpec makes the secret o.secretName in o.namespace hold the users
+// of spec, creating it if needed, and returns the generated passwords.
+func (o *CommandOptions) applyUserSpec(ctx context.Context, spec *userSpecFile) (map[string]string, error) {
+	target := fmt.Sprintf("%s/%s", o.namespace, o.secretName)
+	secret, err := o.secrets().Get(ctx, o.secretName)
+	o.createSecret = apierrors.IsNotFound(err)
+	if o.createSecret {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: o.secretName, Namespace: o.namespace},
+			Type:       v1.SecretTypeOpaque,
+			Data:       make(map[string][]byte),
+		}
+	} else if err != nil {
+		return nil, apiError(err, "unable to get secret %q", o.secretName)
+	} else if secret.Type != v1.SecretTypeOpaque {
+		return nil, fmt.Errorf("invalid secret type %q", secret.Type)
+	} else if manager := managedBy(secret); manager != "" && !ownedBy(secret, spec.owners) {
+		if !o.force {
+			return nil, fmt.Errorf("secret is managed by %s, use --force to apply to it anyway", manager)
+		}
+		fmt.Fprintf(o.ErrOut, "Warning: secret %s is managed by %s\n", target, manager)
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	declared := make(map[string]*userSpec)
+	for i := range spec.Users {
+		declared[spec.Users[i].Name] = &spec.Users[i]
+	}
+	added := make(map[string]bool)
+	updated := make(map[string]bool)
+	removed := make(map[string]bool)
+	generated := make(map[string]string)
+	var files []*keyFile
+	for _, key := range o.keyNames {
+		if !o.createSecret && secret.Data[key] != nil && !looksLikePasswordFile(secret.Data[key]) {
+			return nil, fmt.Errorf("key %q does not contain htpasswd data", key)
+		}
+		f, err := newPasswordFile(secret.Data[key], o.hasher, o.ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key, err)
+		}
+		changed := false
+		for _, u := range spec.Users {
+			existing, ok := f.lookup(u.Name)
+			switch {
+			case !ok && u.Generate:
+				password, ok := generated[u.Name]
+				if !ok {
+					if password, err = generatePassword(o.generateLength, o.charsetChars()); err != nil {
+						return nil, err
+					}
+					generated[u.Name] = password
+				}
+				if u.hasher == nil {
+					if err := f.SetPassword(u.Name, password); err != nil {
+						return nil, err
+					}
+				} else {
+					hash, err := u.hasher.Hash(password)
+					if err != nil {
+						return nil, err
+					}
+					f.SetHash(u.Name, hash)
+				}
+				added[u.Name] = true
+			case !ok:
+				f.SetHash(u.Name, u.Hash)
+				added[u.Name] = true
+			case u.Hash != "" && f.passwords[existing] != u.Hash:
+				f.SetHash(existing, u.Hash)
+				updated[u.Name] = true
+			default:
+				continue
+			}
+			changed = true
+		}
+		users, _ := f.ListUsers()
+		for _, u := range users {
+			if _, ok := f.lookupIn(declared, u); ok {
+				continue
+			}
+			if err := f.DeleteUser(u); err != nil {
+				return nil, err
+			}
+			removed[u] = true
+			changed = true
+		}
+		if changed || o.createSecret {
+			secret.Data[key] = f.Bytes()
+		}
+		files = append(files, &keyFile{passwordFile: f, key: key, operation: "apply"})
+	}
+
+	metadataChanged, err := applyUserMetadata(secret, spec)
+	if err != nil {
+		return nil, err
+	}
+	for u := range metadataChanged {
+		if !added[u] && !removed[u] {
+			updated[u] = true
+		}
+	}
+
+	// the secrets of the controller follow the objects declaring them
+	ownersChanged := false
+	if spec.owners != nil && (o.createSecret || ownedBy(secret, spec.owners)) && !reflect.DeepEqual(secret.OwnerReferences, spec.owners) {
+		secret.OwnerReferences = spec.owners
+		ownersChanged = true
+	}
+
+	d := userDiff{added: sortedKeys(added), updated: sortedKeys(updated), removed: sortedKeys(removed)}
+	switch {
+	case o.createSecret:
+		o.logf(logNormal, "%s: create: %s", target, d)
+	case d.empty() && ownersChanged:
+		o.logf(logNormal, "%s: update owner references", target)
+	case d.empty():
+		o.logf(logNormal, "%s: up to date", target)
+		return nil, nil
+	default:
+		o.logf(logNormal, "%s: %s", target, d)
+	}
+	if err := o.checkSize(secret, files); err != nil {
+		return nil, err
+	}
+	changedUsers := append(append(d.added, d.updated...), d.removed...)
+	sort.Strings(changedUsers)
+	o.recordChange(secret, "apply", changedUsers, "")
+	if err := o.saveSecret(ctx, secret); err != nil {
+		return nil, err
+	}
+	verb := "Updated"
+	if o.createSecret {
+		verb = "Created"
+	}
+	o.logf(logNormal, "%s secret %s%s", verb, target, o.dryRunSuffix())
+	return generated, nil
+}
+
+// lookupIn returns the declared user matching the stored username u, taking
+// --ignore-case into account.
+func (f *passwordFile) lookupIn(declared map[string]*userSpec, u string) (*userSpec, bool) {
+	if spec, ok := declared[u]; ok {
+		return spec, true
+	}
+	if f.ignoreCase {
+		for name, spec := range declared {
+			if strings.EqualFold(name, u) {
+				return spec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// applyUserMetadata replaces the expiry and comment annotations with the
+// declared values and returns the users whose values changed.
+func applyUserMetadata(secret *v1.Secret, spec *userSpecFile) (map[string]bool, error) {
+	expiries, err := readExpiries(secret)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := readComments(secret)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool)
+	newExpiries := make(map[string]time.Time)
+	newComments := make(map[string]string)
+	for _, u := range spec.Users {
+		if !u.expires.IsZero() {
+			newExpiries[u.Name] = u.expires
+		}
+		if u.Comment != "" {
+			newComments[u.Name] = u.Comment
+		}
+	}
+	for u, t := range expiries {
+		if !t.Equal(newExpiries[u]) {
+			changed[u] = true
+		}
+	}
+	for u, t := range newExpiries {
+		if !t.Equal(expiries[u]) {
+			changed[u] = true
+		}
+	}
+	for u, c := range comments {
+		if c != newComments[u] {
+			changed[u] = true
+		}
+	}
+	for u, c := range newComments {
+		if c != comments[u] {
+			changed[u] = true
+		}
+	}
+	writeExpiries(secret, newExpiries)
+	writeComments(secret, newComments)
+	return changed, nil
+}
+
+// GetConfigMap returns the config map with the given name.
+func (c *secretsClient) GetConfigMap(ctx context.Context, name string) (*v1.ConfigMap, error) {
+	result := &v1.ConfigMap{}
+	err := c.retry(ctx, func(ctx context.Context) error {
+		if c.client == nil {
+			typed, err := c.typedCore(ctx, false)
+			if err != nil {
+				return err
+			}
+			cm, err := typed.ConfigMaps(c.ns).Get(name, metav1.GetOptions{})
+			if err == nil {
+				result = cm
+			}
+			return err
+		}
+		return c.client.Get().
+			Context(ctx).
+			Namespace(c.ns).
+			Resource("configmaps").
+			Name(name).
+			VersionedParams(&metav1.GetOptions{}, scheme.ParameterCodec).
+			Do().
+			Into(result)
+	})
+	return result, err
+}
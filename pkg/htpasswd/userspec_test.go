@@ -0,0 +1,50 @@
+package htpasswd
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseConfigMapSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		data  string
+		users []string
+		want  string
+	}{
+		{"usernames", "users", "# team a\nalice\n\nbob\n", []string{"alice", "bob"}, ""},
+		{"empty", "users", "", nil, "configmap team-a/users, key users: no users declared"},
+		{"comments only", "users", "# nobody yet\n\n", nil, "no users declared"},
+		{"empty yaml", "users.yaml", "", nil, "no users declared"},
+		{"missing key", "htpasswd", "", nil, `configmap "team-a/users" has no key "htpasswd"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cm := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "users", Namespace: testNamespace},
+				Data:       map[string]string{"users": test.data, "users.yaml": test.data},
+			}
+			specs, err := parseConfigMapSpecs(cm, test.key)
+			if test.want != "" {
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("error = %v, want %q", err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var users []string
+			for _, u := range specs[0].Users {
+				users = append(users, u.Name)
+			}
+			if strings.Join(users, ",") != strings.Join(test.users, ",") {
+				t.Errorf("users = %v, want %v", users, test.users)
+			}
+		})
+	}
+}
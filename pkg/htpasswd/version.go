@@ -0,0 +1,95 @@
+package htpasswd
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Build information, stamped by the release build with e.g.
+// -ldflags "-X github.com/buztard/kubectl-htpasswd/pkg/htpasswd.version=v1.2.3".
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// versionInfo is the output of the version subcommand.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// buildVersion returns the version of the plugin, falling back to the module
+// version of a `go get` build. It's empty for development builds.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return ""
+}
+
+// newVersionCommand returns the version subcommand which prints the build
+// information.
+func newVersionCommand(o *CommandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the plugin",
+		Long: `Print the version of the plugin.
+
+Installed with krew, "kubectl krew upgrade htpasswd" updates it.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return validationError(fmt.Errorf("version takes no arguments"))
+			}
+			if o.output != "" && o.output != "json" {
+				return validationError(fmt.Errorf("unsupported output format %q, must be: json", o.output))
+			}
+			c.SilenceUsage = true
+			return o.RunVersion()
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: json")
+	return cmd
+}
+
+// RunVersion prints the build information.
+func (o *CommandOptions) RunVersion() error {
+	info := versionInfo{
+		Version:   buildVersion(),
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if o.output == "json" {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.Out, string(data))
+		return nil
+	}
+	fmt.Fprintf(o.Out, "%s %s\n", pluginName, info.Version)
+	if info.Commit != "" {
+		fmt.Fprintf(o.Out, "Commit:     %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Fprintf(o.Out, "Built:      %s\n", info.BuildDate)
+	}
+	fmt.Fprintf(o.Out, "Go version: %s\n", info.GoVersion)
+	fmt.Fprintf(o.Out, "Platform:   %s\n", info.Platform)
+	return nil
+}
@@ -0,0 +1,232 @@
+package htpasswd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// watchRetryDelay is the minimum time between the starts of two watches.
+const watchRetryDelay = 5 * time.Second
+
+// newWatchCommand returns the watch subcommand which keeps a local file in
+// sync with the htpasswd data of a secret, e.g. as a sidecar of a proxy
+// which isn't aware of Kubernetes.
+func newWatchCommand(o *CommandOptions) *cobra.Command {
+	var out, mode string
+	cmd := &cobra.Command{
+		Use:   "watch SECRET --out FILE",
+		Short: "Keep a local file in sync with the htpasswd data of a secret",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			perm, err := o.validateWatch(out, mode)
+			if err != nil {
+				return validationError(err)
+			}
+			c.SilenceUsage = true
+			ctx, cancel := o.newContext()
+			defer cancel()
+			return o.RunWatch(ctx, out, perm)
+		},
+	}
+	cmd.Flags().StringVarP(&out, "out", "", "", "File to write the htpasswd data to")
+	cmd.Flags().StringVarP(&mode, "mode", "", "0600", "Permissions of the written file, in octal")
+	return cmd
+}
+
+// validateWatch checks the arguments of the watch subcommand and returns the
+// permissions of the file.
+func (o *CommandOptions) validateWatch(out, mode string) (os.FileMode, error) {
+	if err := o.validateKeyNames(); err != nil {
+		return 0, err
+	}
+	if len(o.keyNames) != 1 {
+		return 0, fmt.Errorf("watch writes a single key, got %d", len(o.keyNames))
+	}
+	if o.fromManifest != "" {
+		return 0, fmt.Errorf("watch doesn't support --from-manifest")
+	}
+	if o.secretType == v1.SecretTypeBasicAuth {
+		return 0, fmt.Errorf("watch only supports htpasswd data, not basic-auth secrets")
+	}
+	if len(o.args) != 1 {
+		return 0, fmt.Errorf("secret is required")
+	}
+	o.secretName = o.args[0]
+	if out == "" {
+		return 0, fmt.Errorf("--out is required")
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid --mode %q, must be octal permissions like 0644", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// RunWatch writes the htpasswd data of the secret to out and rewrites it
+// whenever the secret changes, until ctx is cancelled. A deleted secret or
+// key leaves the file untouched, so the proxy keeps working with the last
+// known users.
+func (o *CommandOptions) RunWatch(ctx context.Context, out string, perm os.FileMode) error {
+	client := o.secrets()
+	key := o.keyNames[0]
+	var written []byte
+	update := func(secret *v1.Secret) error {
+		data, ok := secret.Data[key]
+		switch {
+		case !ok:
+			fmt.Fprintf(o.ErrOut, "Warning: secret %q has no key %q, keeping %q\n", secret.Name, key, out)
+			return nil
+		case !looksLikePasswordFile(data):
+			fmt.Fprintf(o.ErrOut, "Warning: key %q does not contain htpasswd data, keeping %q\n", key, out)
+			return nil
+		case written != nil && bytes.Equal(data, written):
+			return nil
+		}
+		if err := writeFileAtomic(out, data, perm); err != nil {
+			return err
+		}
+		written = data
+		o.logf(logNormal, "Wrote key %q of secret %q (resource version %s) to %q", key, secret.Name, secret.ResourceVersion, out)
+		return nil
+	}
+
+	resourceVersion := ""
+	for {
+		if resourceVersion == "" {
+			secret, err := o.getSecret(ctx)
+			if ctx.Err() != nil {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := update(secret); err != nil {
+				return err
+			}
+			resourceVersion = secret.ResourceVersion
+		}
+
+		started := time.Now()
+		w, err := client.Watch(ctx, o.secretName, resourceVersion)
+		if err == nil {
+			resourceVersion, err = o.handleEvents(w, resourceVersion, update)
+			w.Stop()
+			if err != nil {
+				return err
+			}
+		} else if ctx.Err() == nil {
+			fmt.Fprintf(o.ErrOut, "Warning: unable to watch secret %q: %v\n", o.secretName, err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		// don't hammer the API server if watches end right away
+		if time.Since(started) < watchRetryDelay {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(watchRetryDelay - time.Since(started)):
+			}
+		}
+		o.logf(logDetails, "Watch of secret %q ended, restarting at resource version %q", o.secretName, resourceVersion)
+	}
+}
+
+// handleEvents passes the changes of the secret to update until the watch
+// ends and returns the resource version to continue from. An empty version
+// means the secret has to be read again.
+func (o *CommandOptions) handleEvents(w watch.Interface, resourceVersion string, update func(*v1.Secret) error) (string, error) {
+	for event := range w.ResultChan() {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			secret, ok := event.Object.(*v1.Secret)
+			if !ok {
+				continue
+			}
+			if err := update(secret); err != nil {
+				return "", err
+			}
+			resourceVersion = secret.ResourceVersion
+		case watch.Deleted:
+			fmt.Fprintf(o.ErrOut, "Warning: secret %q was deleted, keeping the last written data\n", o.secretName)
+			if secret, ok := event.Object.(*v1.Secret); ok {
+				resourceVersion = secret.ResourceVersion
+			}
+		case watch.Error:
+			err := apierrors.FromObject(event.Object)
+			if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+				// the version is too old, start over with a fresh read
+				return "", nil
+			}
+			fmt.Fprintf(o.ErrOut, "Warning: watch of secret %q failed: %v\n", o.secretName, err)
+			return resourceVersion, nil
+		}
+	}
+	return resourceVersion, nil
+}
+
+// Watch watches the secret with the given name for changes after
+// resourceVersion. The watch ends after the request timeout at the latest.
+func (c *secretsClient) Watch(ctx context.Context, name, resourceVersion string) (watch.Interface, error) {
+	if c.client == nil {
+		typed, err := c.typedCore(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		return typed.Secrets(c.ns).Watch(metav1.ListOptions{
+			Watch:           true,
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion,
+		})
+	}
+	return c.client.Get().
+		Context(ctx).
+		Namespace(c.ns).
+		Resource("secrets").
+		VersionedParams(&metav1.ListOptions{
+			Watch:           true,
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion,
+		}, scheme.ParameterCodec).
+		Watch()
+}
+
+// writeFileAtomic replaces the file at path with data, so readers never see
+// a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write %q: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package htpasswdsecret manages htpasswd data stored in Kubernetes secrets
+// for Go programs like operators. It shares the format handling with the
+// kubectl plugin but doesn't depend on flags or terminals.
+//
+//	s, err := htpasswdsecret.Load(ctx, clientset, "default", "basic-auth", "auth")
+//	if err != nil {
+//		return err
+//	}
+//	if err := s.SetPassword("alice", password); err != nil {
+//		return err
+//	}
+//	return s.Save(ctx)
+package htpasswdsecret
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/buztard/kubectl-htpasswd/pkg/htpasswd"
+)
+
+// Secret is the htpasswd data of a single key of a loaded secret.
+type Secret struct {
+	client corev1client.SecretInterface
+	secret *v1.Secret
+	key    string
+	file   *htpasswd.File
+}
+
+// Option changes how the htpasswd data is handled.
+type Option func(*htpasswd.FileOptions)
+
+// WithHash selects the algorithm of new passwords, e.g. "bcrypt" or "apr1".
+func WithHash(name string) Option {
+	return func(o *htpasswd.FileOptions) {
+		o.Hash = name
+	}
+}
+
+// WithBcryptCost sets the work factor of new bcrypt hashes.
+func WithBcryptCost(cost int) Option {
+	return func(o *htpasswd.FileOptions) {
+		o.BcryptCost = cost
+	}
+}
+
+// WithIgnoreCase matches usernames case-insensitively.
+func WithIgnoreCase() Option {
+	return func(o *htpasswd.FileOptions) {
+		o.IgnoreCase = true
+	}
+}
+
+// Load reads key of the secret namespace/name. client may be any clientset,
+// e.g. the fake one of k8s.io/client-go/kubernetes/fake. The typed clients of
+// this client-go release can't be bound to ctx, a request is only skipped if
+// ctx is done before it.
+func Load(ctx context.Context, client kubernetes.Interface, namespace, name, key string, opts ...Option) (*Secret, error) {
+	var fileOpts htpasswd.FileOptions
+	for _, opt := range opts {
+		opt(&fileOpts)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := &Secret{
+		client: client.CoreV1().Secrets(namespace),
+		key:    key,
+	}
+	var err error
+	s.secret, err = s.client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := s.secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %q has no key %q", name, key)
+	}
+	s.file, err = htpasswd.ParseFile(data, fileOpts)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %v", key, err)
+	}
+	return s, nil
+}
+
+// Users returns the sorted usernames.
+func (s *Secret) Users() []string {
+	return s.file.Users()
+}
+
+// SetPassword adds username or changes its password. The change is kept in
+// memory until Save is called.
+func (s *Secret) SetPassword(username, password string) error {
+	return s.file.SetPassword(username, password)
+}
+
+// DeleteUser removes username. The change is kept in memory until Save is
+// called.
+func (s *Secret) DeleteUser(username string) error {
+	return s.file.DeleteUser(username)
+}
+
+// CheckPassword reports whether password matches the stored hash of
+// username.
+func (s *Secret) CheckPassword(username, password string) (bool, error) {
+	return s.file.CheckPassword(username, password)
+}
+
+// Secret returns the loaded secret. Changes to the htpasswd data are only
+// reflected after Save.
+func (s *Secret) Secret() *v1.Secret {
+	return s.secret
+}
+
+// Save updates the secret. The update fails with a conflict error, see
+// k8s.io/apimachinery/pkg/api/errors.IsConflict, if the secret was changed
+// since it was loaded; Load it again and reapply the change in that case.
+func (s *Secret) Save(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	updated := s.secret.DeepCopy()
+	updated.Data[s.key] = s.file.Bytes()
+	result, err := s.client.Update(updated)
+	if err != nil {
+		return err
+	}
+	s.secret = result
+	return nil
+}
@@ -0,0 +1,92 @@
+package htpasswdsecret
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newClientset() *fake.Clientset {
+	return fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-auth", Namespace: "default"},
+		Type:       v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"auth":  []byte("alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"),
+			"other": []byte("unchanged"),
+		},
+	})
+}
+
+func TestLoadAndSave(t *testing.T) {
+	client := newClientset()
+	ctx := context.Background()
+	s, err := Load(ctx, client, "default", "basic-auth", "auth", WithHash("bcrypt"), WithBcryptCost(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := strings.Join(s.Users(), ","); users != "alice" {
+		t.Errorf("Users() = %q, want alice", users)
+	}
+	if ok, err := s.CheckPassword("alice", "secret"); !ok || err != nil {
+		t.Errorf("CheckPassword(alice) = %v, %v, want true", ok, err)
+	}
+	if err := s.SetPassword("bob", "secret2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(ctx, client, "default", "basic-auth", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := strings.Join(s.Users(), ","); users != "bob" {
+		t.Errorf("Users() after Save = %q, want bob", users)
+	}
+	if ok, err := s.CheckPassword("bob", "secret2"); !ok || err != nil {
+		t.Errorf("CheckPassword(bob) = %v, %v, want true", ok, err)
+	}
+	if other := string(s.Secret().Data["other"]); other != "unchanged" {
+		t.Errorf("other key = %q after Save, want it unchanged", other)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		secret    string
+		key       string
+		want      string
+		isMissing bool
+	}{
+		{"missing secret", context.Background(), "missing", "auth", "", true},
+		{"missing key", context.Background(), "basic-auth", "htpasswd", `secret "basic-auth" has no key "htpasswd"`, false},
+		{"malformed data", context.Background(), "basic-auth", "other", `key "other": line 1`, false},
+		{"cancelled", cancelled, "basic-auth", "auth", context.Canceled.Error(), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(test.ctx, newClientset(), "default", test.secret, test.key)
+			switch {
+			case err == nil:
+				t.Fatalf("Load succeeded")
+			case test.isMissing && !apierrors.IsNotFound(err):
+				t.Errorf("Load error = %v, want NotFound", err)
+			case !strings.Contains(err.Error(), test.want):
+				t.Errorf("Load error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
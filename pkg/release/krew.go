@@ -0,0 +1,68 @@
+package release
+
+import (
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+const krewDescription = `Creates and edits htpasswd data in secrets, as used by the basic
+authentication of ingress controllers like ingress-nginx and Traefik.
+Users can be added, renamed, verified, rotated and expired, secrets
+kept in sync with declarative user lists, and passwords generated.
+`
+
+// The krew plugin manifest, see
+// https://krew.sigs.k8s.io/docs/developer-guide/plugin-manifest/.
+type (
+	krewPlugin struct {
+		APIVersion string         `json:"apiVersion"`
+		Kind       string         `json:"kind"`
+		Metadata   krewMetadata   `json:"metadata"`
+		Spec       krewPluginSpec `json:"spec"`
+	}
+	krewMetadata struct {
+		Name string `json:"name"`
+	}
+	krewPluginSpec struct {
+		Version          string         `json:"version"`
+		Homepage         string         `json:"homepage"`
+		ShortDescription string         `json:"shortDescription"`
+		Description      string         `json:"description"`
+		Platforms        []krewPlatform `json:"platforms"`
+	}
+	krewPlatform struct {
+		Selector krewSelector `json:"selector"`
+		URI      string       `json:"uri"`
+		SHA256   string       `json:"sha256"`
+		Bin      string       `json:"bin"`
+	}
+	krewSelector struct {
+		MatchLabels map[string]string `json:"matchLabels"`
+	}
+)
+
+// KrewManifest returns the krew plugin manifest for the artifacts of
+// version, which are downloaded from the GitHub release of the version.
+func KrewManifest(version string, artifacts []*Artifact) ([]byte, error) {
+	plugin := krewPlugin{
+		APIVersion: "krew.googlecontainertools.github.com/v1alpha2",
+		Kind:       "Plugin",
+		Metadata:   krewMetadata{Name: PluginName},
+		Spec: krewPluginSpec{
+			Version:          version,
+			Homepage:         Homepage,
+			ShortDescription: "Manage htpasswd data in secrets",
+			Description:      krewDescription,
+		},
+	}
+	for _, a := range artifacts {
+		plugin.Spec.Platforms = append(plugin.Spec.Platforms, krewPlatform{
+			Selector: krewSelector{MatchLabels: map[string]string{"os": a.Platform.OS, "arch": a.Platform.Arch}},
+			URI:      Homepage + "/releases/download/" + version + "/" + filepath.Base(a.Path),
+			SHA256:   a.SHA256,
+			Bin:      a.Platform.binary(),
+		})
+	}
+	return yaml.Marshal(plugin)
+}
@@ -0,0 +1,166 @@
+// Package release builds the release archives of kubectl-htpasswd and the
+// krew plugin manifest referencing them.
+package release
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Binary is the name of the plugin executable, kubectl finds plugins
+	// by the kubectl- prefix.
+	Binary = "kubectl-htpasswd"
+	// PluginName is the name of the plugin in the krew index.
+	PluginName = "htpasswd"
+	// Homepage is the project page linked from the krew index.
+	Homepage = "https://github.com/buztard/kubectl-htpasswd"
+
+	versionPackage = "github.com/buztard/kubectl-htpasswd/pkg/htpasswd"
+)
+
+// Platform is a GOOS/GOARCH combination a release is built for.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+// Platforms are the platforms of a release.
+var Platforms = []Platform{
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+	{"darwin", "amd64"},
+	{"darwin", "arm64"},
+	{"windows", "amd64"},
+}
+
+// String returns the platform as os/arch.
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// binary returns the file name of the executable on the platform.
+func (p Platform) binary() string {
+	if p.OS == "windows" {
+		return Binary + ".exe"
+	}
+	return Binary
+}
+
+// ArchiveName returns the file name of the release archive of the platform.
+func (p Platform) ArchiveName(version string) string {
+	return fmt.Sprintf("%s_%s_%s_%s.tar.gz", Binary, version, p.OS, p.Arch)
+}
+
+// Artifact is a release archive written by Build.
+type Artifact struct {
+	Platform Platform
+	Path     string
+	SHA256   string
+}
+
+// Build cross-compiles the plugin in the module directory src for p with
+// the version stamped in, and packs it into an archive in dir.
+func Build(src, dir, version, commit string, p Platform) (*Artifact, error) {
+	tmp, err := ioutil.TempDir("", "kubectl-htpasswd-release")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	binary := filepath.Join(tmp, p.binary())
+	ldflags := strings.Join([]string{
+		"-s", "-w",
+		"-X", versionPackage + ".version=" + version,
+		"-X", versionPackage + ".commit=" + commit,
+		"-X", versionPackage + ".buildDate=" + time.Now().UTC().Format(time.RFC3339),
+	}, " ")
+	cmd := exec.Command("go", "build", "-trimpath", "-ldflags", ldflags, "-o", binary, ".")
+	cmd.Dir = src
+	cmd.Env = append(os.Environ(), "GOOS="+p.OS, "GOARCH="+p.Arch, "CGO_ENABLED=0")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to build %s: %v", p, err)
+	}
+
+	path := filepath.Join(dir, p.ArchiveName(version))
+	if err := writeArchive(path, binary, filepath.Join(src, "README.md")); err != nil {
+		return nil, fmt.Errorf("unable to write archive for %s: %v", p, err)
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Platform: p, Path: path, SHA256: sum}, nil
+}
+
+// writeArchive writes the files into a gzipped tarball at path, without
+// directories.
+func writeArchive(path string, files ...string) (err error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, name := range files {
+		if err := addFile(tw, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addFile adds the file name to tw.
+func addFile(tw *tar.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file name.
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,17 @@
+module github.com/buztard/kubectl-htpasswd/test/integration
+
+go 1.12
+
+require (
+	github.com/buztard/kubectl-htpasswd v0.0.0
+	k8s.io/api v0.0.0-20190515023547-db5a9d1c40eb
+	k8s.io/apimachinery v0.0.0-20190515023456-b74e4c97951f
+	k8s.io/cli-runtime v0.0.0-20190515024640-178667528169
+	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
+	sigs.k8s.io/controller-runtime v0.2.2
+)
+
+replace github.com/buztard/kubectl-htpasswd => ../..
+
+// controller-runtime requires an older client-go release
+replace k8s.io/client-go => k8s.io/client-go v0.0.0-20190515023709-78e94f51a042
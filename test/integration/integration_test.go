@@ -0,0 +1,211 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/buztard/kubectl-htpasswd/pkg/htpasswd"
+	"github.com/buztard/kubectl-htpasswd/pkg/htpasswdsecret"
+)
+
+// The integration tests run the commands against the etcd and kube-apiserver
+// of envtest, found in $KUBEBUILDER_ASSETS or /usr/local/kubebuilder/bin:
+//
+//	cd test/integration && KUBEBUILDER_ASSETS=... go test ./...
+
+const namespace = "team-a"
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: ` + namespace + `
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+
+var (
+	kubeconfig string
+	clientset  kubernetes.Interface
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	env := &envtest.Environment{}
+	config, err := env.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to start envtest: %v\n", err)
+		return 1
+	}
+	defer env.Stop()
+
+	dir, err := ioutil.TempDir("", "kubectl-htpasswd-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig = filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(fmt.Sprintf(kubeconfigTemplate, config.Host)), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yaml"), nil, 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	clientset, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	if _, err := clientset.CoreV1().Namespaces().Create(ns); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create namespace %q: %v\n", namespace, err)
+		return 1
+	}
+	return m.Run()
+}
+
+// runCommand runs the command line args with stdin against the envtest API
+// server.
+func runCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cmd := htpasswd.NewCommand(genericclioptions.IOStreams{In: strings.NewReader(stdin), Out: &out, ErrOut: &errOut})
+	config := filepath.Join(filepath.Dir(kubeconfig), "config.yaml")
+	cmd.SetArgs(append([]string{"--kubeconfig", kubeconfig, "--config", config}, args...))
+	cmd.SetOutput(&errOut)
+	err := cmd.Execute()
+	if err != nil {
+		t.Logf("%s: %v\n%s", strings.Join(args, " "), err, errOut.String())
+	}
+	return out.String(), err
+}
+
+func getSecret(t *testing.T, name string) *v1.Secret {
+	t.Helper()
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// users returns the usernames of htpasswd data.
+func users(data []byte) []string {
+	var users []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" {
+			users = append(users, strings.SplitN(line, ":", 2)[0])
+		}
+	}
+	return users
+}
+
+func TestLifecycle(t *testing.T) {
+	if _, err := runCommand(t, "secret1\n", "create", "lifecycle", "alice", "--password-stdin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "secret2\n", "add", "lifecycle", "bob", "--password-stdin", "--hash", "sha256"); err != nil {
+		t.Fatal(err)
+	}
+	secret := getSecret(t, "lifecycle")
+	if u := users(secret.Data["auth"]); strings.Join(u, ",") != "alice,bob" {
+		t.Errorf("users = %v, want [alice bob]", u)
+	}
+	if manager := secret.Annotations["htpasswd.kubectl.io/managed-by"]; !strings.HasPrefix(manager, "kubectl-htpasswd") {
+		t.Errorf("managed-by annotation = %q, want the plugin", manager)
+	}
+
+	if _, err := runCommand(t, "secret2\n", "verify", "lifecycle", "bob", "--password-stdin"); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+	if _, err := runCommand(t, "", "rename", "lifecycle", "bob", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "", "delete", "lifecycle", "alice", "--yes"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCommand(t, "", "list", "lifecycle", "-o", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "carol\n" {
+		t.Errorf("list = %q, want %q", out, "carol\n")
+	}
+}
+
+func TestServerDryRun(t *testing.T) {
+	if _, err := runCommand(t, "secret1\n", "create", "dry-run", "alice", "--password-stdin"); err != nil {
+		t.Fatal(err)
+	}
+	before := getSecret(t, "dry-run")
+	if _, err := runCommand(t, "secret2\n", "add", "dry-run", "bob", "--password-stdin", "--dry-run=server"); err != nil {
+		t.Fatal(err)
+	}
+	after := getSecret(t, "dry-run")
+	if after.ResourceVersion != before.ResourceVersion {
+		t.Errorf("server-side dry run changed the secret")
+	}
+}
+
+func TestManagedSecretNeedsForce(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed",
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "Helm"},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{"auth": []byte("alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")},
+	}
+	if _, err := clientset.CoreV1().Secrets(namespace).Create(secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "secret2\n", "add", "managed", "bob", "--password-stdin"); err == nil {
+		t.Errorf("add to a secret managed by Helm succeeded without --force")
+	}
+	if _, err := runCommand(t, "secret2\n", "add", "managed", "bob", "--password-stdin", "--force"); err != nil {
+		t.Errorf("add with --force failed: %v", err)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	if _, err := runCommand(t, "secret1\n", "create", "cancelled", "alice", "--password-stdin"); err != nil {
+		t.Fatal(err)
+	}
+	// requests are bound to the context, a cancelled one must not reach
+	// the API server
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := htpasswdsecret.Load(ctx, clientset, namespace, "cancelled", "auth"); err == nil {
+		t.Errorf("Load with a cancelled context succeeded")
+	}
+}